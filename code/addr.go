@@ -0,0 +1,29 @@
+package socks5
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/yongfrank/go-socks5/wire"
+)
+
+var ErrDomainNameLength = wire.ErrDomainNameLength
+
+// Addr is the address of requests, replies and UDP headers, an IP or a
+// fully-qualified domain name plus a port. The zero Addr is 0.0.0.0:0.
+type Addr = wire.Addr
+
+// ParseAddr parses host:port, the host being an IP or a domain name.
+func ParseAddr(s string) (Addr, error) {
+	return wire.ParseAddr(s)
+}
+
+// AddrFromAddrPort returns the Addr of an IP and port.
+func AddrFromAddrPort(ap netip.AddrPort) Addr {
+	return wire.AddrFromAddrPort(ap)
+}
+
+// AddrFromIP returns the Addr of a net.IP and port, 0.0.0.0 if ip is nil.
+func AddrFromIP(ip net.IP, port uint16) Addr {
+	return wire.AddrFromIP(ip, port)
+}
@@ -0,0 +1,194 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAddr parses host:port for test requests
+func testAddr(s string) Addr {
+	a, err := ParseAddr(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestAddrRoundTrip(t *testing.T) {
+	tests := []struct {
+		addr string
+		atyp AddressType
+		wire []byte
+	}{
+		{"1.2.3.4:80", TypeIPv4, []byte{TypeIPv4, 1, 2, 3, 4, 0, 80}},
+		{"[2001:db8::1]:443", TypeIPv6, []byte{TypeIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 0xbb}},
+		{"example.com:8080", TypeDomain, append([]byte{TypeDomain, 11}, append([]byte("example.com"), 0x1f, 0x90)...)},
+	}
+	for _, test := range tests {
+		a, err := ParseAddr(test.addr)
+		if err != nil {
+			t.Fatalf("%s: should get error nil but got %s", test.addr, err)
+		}
+		if a.Type() != test.atyp || a.String() != test.addr {
+			t.Fatalf("%s: want type %d but got %d and %s", test.addr, test.atyp, a.Type(), a)
+		}
+		wire, err := a.MarshalBinary()
+		if err != nil || !bytes.Equal(wire, test.wire) {
+			t.Fatalf("%s: want %v but got %v, %v", test.addr, test.wire, wire, err)
+		}
+
+		var b Addr
+		if err := b.UnmarshalBinary(wire); err != nil || b != a {
+			t.Fatalf("%s: want %s unmarshaled but got %s, %v", test.addr, a, b, err)
+		}
+		var buf bytes.Buffer
+		if n, err := a.WriteTo(&buf); err != nil || n != int64(len(wire)) {
+			t.Fatalf("%s: want %d octets written but got %d, %v", test.addr, len(wire), n, err)
+		}
+		buf.WriteString("rest")
+		var c Addr
+		if n, err := c.ReadFrom(&buf); err != nil || c != a || n != int64(len(wire)) {
+			t.Fatalf("%s: want %s read but got %s, %d, %v", test.addr, a, c, n, err)
+		}
+		if buf.String() != "rest" {
+			t.Fatalf("%s: want only the address read but %q is left", test.addr, buf.String())
+		}
+	}
+}
+
+func TestAddrConversions(t *testing.T) {
+	a, err := ParseAddr("[::ffff:192.0.2.1]:53")
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if a.Type() != TypeIPv4 || a.String() != "192.0.2.1:53" {
+		t.Fatalf("want the IPv4-mapped address as IPv4 but got %s", a)
+	}
+	if ap := a.AddrPort(); ap != netip.MustParseAddrPort("192.0.2.1:53") {
+		t.Fatalf("want AddrPort 192.0.2.1:53 but got %s", ap)
+	}
+	if ap := (Addr{FQDN: "example.com", Port: 53}).AddrPort(); ap.IsValid() {
+		t.Fatalf("want no AddrPort for a domain but got %s", ap)
+	}
+	if a := AddrFromIP(net.ParseIP("10.0.0.1"), 1080); a.Type() != TypeIPv4 || a.String() != "10.0.0.1:1080" {
+		t.Fatalf("want 10.0.0.1:1080 from a 16 octet net.IP but got %s", a)
+	}
+
+	// the zero Addr is the unspecified IPv4 address
+	var zero Addr
+	if wire, _ := zero.MarshalBinary(); !bytes.Equal(wire, []byte{TypeIPv4, 0, 0, 0, 0, 0, 0}) || zero.String() != "0.0.0.0:0" {
+		t.Fatalf("want 0.0.0.0:0 but got %s %v", zero, wire)
+	}
+	if AddrFromIP(nil, 0) != zero {
+		t.Fatal("want the zero Addr from a nil IP")
+	}
+	var _ net.Addr = zero
+}
+
+func TestAddrErrors(t *testing.T) {
+	for _, s := range []string{"example.com", "example.com:http", "1.2.3.4:65536", "[fe80::1%eth0]:80", ":80", strings.Repeat("a", 256) + ":80"} {
+		if _, err := ParseAddr(s); err == nil {
+			t.Fatalf("%s: want an error", s)
+		}
+	}
+	if _, err := (Addr{FQDN: strings.Repeat("a", 256)}).MarshalBinary(); err != ErrDomainNameLength {
+		t.Fatalf("want error %s but got %v", ErrDomainNameLength, err)
+	}
+
+	tests := []struct {
+		wire []byte
+		err  error
+	}{
+		{[]byte{}, io.ErrUnexpectedEOF},
+		{[]byte{0x02, 1, 2, 3, 4, 0, 80}, ErrAddressTypeNotSupported},
+		{[]byte{TypeDomain, 0, 0, 80}, ErrDomainNameLength},
+		{[]byte{TypeIPv4, 1, 2, 3, 4, 0}, io.ErrUnexpectedEOF},
+		{[]byte{TypeIPv6, 1, 2, 3, 4, 0, 80}, io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		var a Addr
+		if err := a.UnmarshalBinary(test.wire); err != test.err {
+			t.Fatalf("%v: want error %v but got %v", test.wire, test.err, err)
+		}
+	}
+	var a Addr
+	if err := a.UnmarshalBinary([]byte{TypeIPv4, 1, 2, 3, 4, 0, 80, 0}); err == nil {
+		t.Fatal("want an error for octets after the address")
+	}
+	if _, err := a.ReadFrom(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("want error EOF before an address but got %v", err)
+	}
+}
+
+func TestRequestAddressTypes(t *testing.T) {
+	tests := []struct {
+		wire []byte
+		atyp AddressType
+		addr string
+	}{
+		{[]byte{TypeIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 80}, TypeIPv6, "[2001:db8::1]:80"},
+		{append(append([]byte{TypeDomain, 11}, "example.com"...), 0, 80), TypeDomain, "example.com:80"},
+		// a domain name looking like an IP stays a domain name
+		{append(append([]byte{TypeDomain, 7}, "1.2.3.4"...), 0, 80), TypeDomain, "1.2.3.4:80"},
+	}
+	for _, test := range tests {
+		buf := bytes.NewBuffer([]byte{SOCKS5Version, CmdConnect, ReqReservedField})
+		buf.Write(test.wire)
+		msg, err := NewClientRequestMessage(buf)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		if msg.Dst.Type() != test.atyp || msg.Dst.String() != test.addr {
+			t.Fatalf("want type %d %s but got %d %s", test.atyp, test.addr, msg.Dst.Type(), msg.Dst)
+		}
+	}
+}
+
+func TestConnectIPv6(t *testing.T) {
+	target, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback")
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	config := &Config{AuthMethod: MethodNoAuth}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "over ipv6")
+}
+
+func TestAddressTypeNotSupportedReply(t *testing.T) {
+	config := &Config{AuthMethod: MethodNoAuth}
+	conn, err := net.Dial("tcp", startTestServer(t, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{SOCKS5Version, 1, MethodNoAuth, SOCKS5Version, CmdConnect, ReqReservedField, 0x02})
+	got := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	want := []byte{SOCKS5Version, MethodNoAuth, SOCKS5Version, ReplyAddressTypeNotSupported, ReqReservedField, TypeIPv4, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+}
@@ -0,0 +1,108 @@
+package socks5
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+Admin HTTP API of a running server, mounted with NewAdminHandler:
+
+	o  GET    /sessions                 list the active sessions
+	o  DELETE /sessions/{id}            kill a session
+	o  DELETE /users/{name}/sessions    kill every session of a user
+	o  GET    /stats                    connection counts, in total and per listener
+	o  POST   /reload                   reload the configuration
+
+Requests carry "Authorization: Bearer <token>". Without a token only
+clients on the loopback interface are served.
+*/
+
+// AdminHandler serves the admin API of a server.
+type AdminHandler struct {
+	Server *SOCKS5Server
+	// Token is required from clients, if empty only loopback clients are allowed
+	Token string
+	// Reload, if set, is called by POST /reload
+	Reload func() error
+}
+
+func NewAdminHandler(server *SOCKS5Server, token string, reload func() error) *AdminHandler {
+	return &AdminHandler{Server: server, Token: token, Reload: reload}
+}
+
+type adminStats struct {
+	Total     Stats            `json:"total"`
+	Listeners map[string]Stats `json:"listeners"`
+	Sessions  int              `json:"sessions"`
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="socks5 admin"`)
+		writeAdminError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "sessions" && r.Method == http.MethodGet:
+		writeAdminJSON(w, h.Server.Sessions())
+	case len(parts) == 2 && parts[0] == "sessions" && r.Method == http.MethodDelete:
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid session id")
+			return
+		}
+		if !h.Server.KillSession(id) {
+			writeAdminError(w, http.StatusNotFound, "no such session")
+			return
+		}
+		writeAdminJSON(w, map[string]int{"killed": 1})
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "sessions" && r.Method == http.MethodDelete:
+		writeAdminJSON(w, map[string]int{"killed": h.Server.KillUserSessions(parts[1])})
+	case len(parts) == 1 && parts[0] == "stats" && r.Method == http.MethodGet:
+		writeAdminJSON(w, adminStats{
+			Total:     h.Server.Stats(),
+			Listeners: h.Server.StatsByListener(),
+			Sessions:  len(h.Server.Sessions()),
+		})
+	case len(parts) == 1 && parts[0] == "reload" && r.Method == http.MethodPost:
+		if h.Reload == nil {
+			writeAdminError(w, http.StatusNotImplemented, "reload not supported")
+			return
+		}
+		if err := h.Reload(); err != nil {
+			writeAdminError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeAdminJSON(w, map[string]bool{"reloaded": true})
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		return err == nil && ip != nil && ip.IsLoopback()
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
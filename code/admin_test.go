@@ -0,0 +1,161 @@
+package socks5
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, path, remoteAddr, token string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: should get error nil but got %s", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	s := &SOCKS5Server{}
+	loopback := NewAdminHandler(s, "", nil)
+	if code := adminRequest(t, loopback, "GET", "/stats", "127.0.0.1:40000", "", nil); code != http.StatusOK {
+		t.Fatalf("loopback: want status 200 but got %d", code)
+	}
+	if code := adminRequest(t, loopback, "GET", "/stats", "192.0.2.1:40000", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("remote: want status 401 but got %d", code)
+	}
+
+	withToken := NewAdminHandler(s, "secret", nil)
+	if code := adminRequest(t, withToken, "GET", "/stats", "192.0.2.1:40000", "secret", nil); code != http.StatusOK {
+		t.Fatalf("token: want status 200 but got %d", code)
+	}
+	if code := adminRequest(t, withToken, "GET", "/stats", "127.0.0.1:40000", "wrong", nil); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: want status 401 but got %d", code)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	echo := startEchoServer(t)
+	config := &Config{AuthMethod: MethodPassword, PasswordChecker: func(u, p string) bool { return p == "123456" }}
+	s := &SOCKS5Server{Config: config}
+	addr := startListener(t, s, &Listener{Label: "public"})
+	reloaded := false
+	h := NewAdminHandler(s, "secret", func() error {
+		reloaded = true
+		return errors.New("broken config")
+	})
+
+	d := &Dialer{ProxyAddress: addr, Username: "admin", Password: "123456", Timeout: 5 * time.Second}
+	tunnel, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer tunnel.Close()
+	tunnel.SetDeadline(time.Now().Add(5 * time.Second))
+	tunnel.Write([]byte("ping"))
+	io.ReadFull(tunnel, make([]byte, 4))
+	d.Username = "bob"
+	other, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer other.Close()
+
+	var sessions []SessionInfo
+	if code := adminRequest(t, h, "GET", "/sessions", "127.0.0.1:40000", "secret", &sessions); code != http.StatusOK {
+		t.Fatalf("want status 200 but got %d", code)
+	}
+	if len(sessions) != 2 || sessions[0].User != "admin" || sessions[0].Destination != echo.String() {
+		t.Fatalf("want sessions of admin and bob to %s but got %+v", echo, sessions)
+	}
+	if sessions[0].BytesIn == 0 || sessions[0].BytesOut == 0 {
+		t.Fatalf("want bytes counted but got %+v", sessions[0])
+	}
+
+	var stats adminStats
+	adminRequest(t, h, "GET", "/stats", "127.0.0.1:40000", "secret", &stats)
+	if stats.Total.Active != 2 || stats.Listeners["public"].Accepted != 2 || stats.Sessions != 2 {
+		t.Fatalf("want 2 active sessions but got %+v", stats)
+	}
+
+	// killing the user's sessions ends the tunnel
+	var killed map[string]int
+	adminRequest(t, h, "DELETE", "/users/admin/sessions", "127.0.0.1:40000", "secret", &killed)
+	if killed["killed"] != 1 {
+		t.Fatalf("want 1 session killed but got %v", killed)
+	}
+	if _, err := tunnel.Read(make([]byte, 1)); err == nil {
+		t.Fatal("should get an error reading a killed tunnel")
+	}
+
+	if code := adminRequest(t, h, "DELETE", "/sessions/"+strconv.FormatUint(sessions[1].ID, 10), "127.0.0.1:40000", "secret", nil); code != http.StatusOK {
+		t.Fatalf("want status 200 but got %d", code)
+	}
+	if code := adminRequest(t, h, "DELETE", "/sessions/999", "127.0.0.1:40000", "secret", nil); code != http.StatusNotFound {
+		t.Fatalf("want status 404 but got %d", code)
+	}
+
+	if code := adminRequest(t, h, "POST", "/reload", "127.0.0.1:40000", "secret", nil); code != http.StatusUnprocessableEntity || !reloaded {
+		t.Fatalf("want status 422 from a failed reload but got %d", code)
+	}
+}
+
+// accessLog passes the access log records of sessions to dst on
+type accessLog struct {
+	dst     string
+	records chan string
+}
+
+func (l *accessLog) Write(p []byte) (int, error) {
+	if line := string(p); strings.Contains(line, "access session=") && strings.Contains(line, "destination="+l.dst+" ") {
+		select {
+		case l.records <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func TestAccessLogResult(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := ln.Addr().String()
+	ln.Close()
+	addr := startTestServer(t, &Config{AuthMethod: MethodNoAuth})
+
+	records := &accessLog{dst: refused, records: make(chan string, 1)}
+	log.SetOutput(records)
+	defer log.SetOutput(os.Stderr)
+
+	d := &Dialer{ProxyAddress: addr, Timeout: 5 * time.Second}
+	if _, err := d.Dial("tcp", refused); err == nil {
+		t.Fatal("should get an error dialing a closed port")
+	}
+	select {
+	case record := <-records.records:
+		if strings.Contains(record, `result="ok"`) {
+			t.Fatalf("want the dial error logged but got %s", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access log record")
+	}
+}
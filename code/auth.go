@@ -12,8 +12,9 @@
 package socks5
 
 import (
-	"errors"
 	"io"
+
+	"github.com/yongfrank/go-socks5/wire"
 )
 
 type ClientAuthMessage struct {
@@ -32,36 +33,22 @@ const (
 )
 
 func NewClientAuthMessage(conn io.Reader) (*ClientAuthMessage, error) {
-
-	// Read version, nMedthods
-	buf := make([]byte, 2)
-	_, err := io.ReadFull(conn, buf)
-	if err != nil {
+	// version, nmethods and methods, at least one of them
+	var selection wire.MethodSelection
+	if _, err := selection.ReadFrom(conn); err != nil {
 		return nil, err
 	}
 
-	// Validate Version
-	if buf[0] != SOCKS5Version {
-		return nil, ErrVersionNotSupported
-	}
-
-	nmethods := buf[1]
-	buf = make([]byte, nmethods)
-	_, err2 := io.ReadFull(conn, buf)
-	if err2 != nil {
-		return nil, errors.New("read nmethods failed")
-	}
-
 	return &ClientAuthMessage{
 		Version:  SOCKS5Version,
-		NMethods: nmethods,
-		Methods:  buf,
+		NMethods: byte(len(selection.Methods)),
+		Methods:  selection.Methods,
 	}, nil
 }
 
 func NewServerAuthMessage(conn io.Writer, method Method) error {
-	buf := []byte{SOCKS5Version, method}
-	_, err := conn.Write(buf)
+	reply := wire.MethodReply{Method: method}
+	_, err := reply.WriteTo(conn)
 	return err
 }
 
@@ -80,38 +67,19 @@ o  The PASSWD field contains the password association with the given UNAME.
 	+----+------+----------+------+----------+
 */
 const (
-	PasswordMethodVersion = 0x01
+	PasswordMethodVersion = wire.PasswordVersion
 	PasswordAuthSuccess   = 0x00
 	PasswordAuthFailure   = 0x01
 )
 
 func NewPasswordAuthMessage(conn io.Reader) (*ClientPasswordMessage, error) {
-	buf := make([]byte, 2)
-	if _, err := io.ReadFull(conn, buf); err != nil {
-		return nil, err
-	}
-	version, usernameLen := buf[0], buf[1]
-	if version != PasswordMethodVersion {
-		return nil, ErrMethodVersionNotSupported
-	}
-
-	// Read username
-	buf = make([]byte, usernameLen+1)
-	if _, err := io.ReadFull(conn, buf); err != nil {
+	var req wire.PasswordRequest
+	if _, err := req.ReadFrom(conn); err != nil {
 		return nil, err
 	}
-	username, passwordLen := string(buf[:len(buf)-1]), buf[len(buf)-1]
-
-	// if len(buf) < int(passwordLen) {
-	buf = make([]byte, passwordLen)
-	// }
-	if _, err := io.ReadFull(conn, buf); err != nil {
-		return nil, err
-	}
-
 	return &ClientPasswordMessage{
-		Username: username,
-		Password: string(buf[:passwordLen]),
+		Username: req.Username,
+		Password: req.Password,
 	}, nil
 }
 
@@ -121,6 +89,7 @@ type ClientPasswordMessage struct {
 }
 
 func WriteServerPasswordMessage(conn io.Writer, status byte) error {
-	_, err := conn.Write([]byte{PasswordMethodVersion, status})
+	resp := wire.PasswordResponse{Status: status}
+	_, err := resp.WriteTo(conn)
 	return err
 }
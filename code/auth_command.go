@@ -0,0 +1,185 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+/*
+CommandAuthenticator checks credentials by running a local executable,
+in the spirit of checkpassword.
+
+The credentials are passed on stdin, one per line:
+
+	username
+	password
+	client address
+
+or, when UseEnv is set, in the SOCKS5_USERNAME, SOCKS5_PASSWORD and
+SOCKS5_CLIENT_ADDR environment variables with an empty stdin.
+
+The exit code is the verdict:
+
+	o  0     credentials accepted
+	o  1     credentials rejected
+	o  other the command failed, the login is refused but not cached
+
+On success the command may print a JSON object describing the user:
+
+	{"name": "alice", "groups": ["staff"], "attributes": {"team": "infra"}}
+*/
+type CommandAuthenticator struct {
+	Path string
+	Args []string
+
+	// UseEnv passes the credentials in environment variables instead of stdin.
+	UseEnv bool
+
+	// Timeout bounds a single run of the command, defaults to 5 seconds.
+	Timeout time.Duration
+
+	// MaxConcurrent limits the number of commands running at the same time,
+	// zero means no limit.
+	MaxConcurrent int
+
+	// CacheTTL keeps accepted credentials for the given time,
+	// FailureCacheTTL does the same for rejected ones. Zero disables caching.
+	// Results are kept per client IP, as the command may decide by the client address.
+	CacheTTL        time.Duration
+	FailureCacheTTL time.Duration
+
+	once  sync.Once
+	sem   chan struct{}
+	cache credentialCache
+}
+
+// commandOutput is the optional JSON printed by the command.
+type commandOutput struct {
+	Name       string            `json:"name"`
+	Groups     []string          `json:"groups"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+const (
+	commandExitAccepted = 0
+	commandExitRejected = 1
+
+	defaultCommandTimeout = 5 * time.Second
+	// commandWaitDelay is how long output is waited for after the command is killed,
+	// a child it started may hold stdout open
+	commandWaitDelay = time.Second
+)
+
+func (a *CommandAuthenticator) init() {
+	if a.MaxConcurrent > 0 {
+		a.sem = make(chan struct{}, a.MaxConcurrent)
+	}
+}
+
+func (a *CommandAuthenticator) Authenticate(username, password string, clientAddr net.Addr) (*User, error) {
+	a.once.Do(a.init)
+
+	key := credentialKey(username, password, clientHost(clientAddr))
+	if user, ok := a.cache.lookup(key); ok {
+		if user == nil {
+			return nil, ErrPasswordAuthFailure
+		}
+		return user, nil
+	}
+
+	user, err := a.run(username, password, clientAddr)
+	switch {
+	case err == nil:
+		a.cache.store(key, user, a.CacheTTL)
+	case errors.Is(err, ErrPasswordAuthFailure):
+		a.cache.store(key, nil, a.FailureCacheTTL)
+	}
+	return user, err
+}
+
+// clientHost is the IP of a TCP client, without the port, or the address of others
+func clientHost(clientAddr net.Addr) string {
+	if ip, _ := tcpAddr(clientAddr); ip != nil {
+		return ip.String()
+	}
+	if clientAddr == nil {
+		return ""
+	}
+	return clientAddr.String()
+}
+
+func (a *CommandAuthenticator) run(username, password string, clientAddr net.Addr) (*User, error) {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if a.sem != nil {
+		select {
+		case a.sem <- struct{}{}:
+			defer func() { <-a.sem }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("command auth: waiting for a free slot: %w", ctx.Err())
+		}
+	}
+
+	addr := ""
+	if clientAddr != nil {
+		addr = clientAddr.String()
+	}
+
+	cmd := exec.CommandContext(ctx, a.Path, a.Args...)
+	if a.UseEnv {
+		cmd.Env = append(os.Environ(),
+			"SOCKS5_USERNAME="+username,
+			"SOCKS5_PASSWORD="+password,
+			"SOCKS5_CLIENT_ADDR="+addr,
+		)
+	} else {
+		cmd.Stdin = bytes.NewBufferString(username + "\n" + password + "\n" + addr + "\n")
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.WaitDelay = commandWaitDelay
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("command auth: %s: %w", a.Path, ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == commandExitRejected {
+			return nil, ErrPasswordAuthFailure
+		}
+		return nil, fmt.Errorf("command auth: %s exited with status %d", a.Path, exitErr.ExitCode())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command auth: %w", err)
+	}
+
+	user := &User{Name: username}
+	out := bytes.TrimSpace(stdout.Bytes())
+	if len(out) == 0 || out[0] != '{' {
+		return user, nil
+	}
+	var info commandOutput
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("command auth: invalid output from %s: %w", a.Path, err)
+	}
+	if info.Name != "" {
+		user.Name = info.Name
+	}
+	user.Groups = info.Groups
+	user.Attributes = info.Attributes
+	return user, nil
+}
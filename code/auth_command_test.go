@@ -0,0 +1,140 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "check.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCommandAuthenticator(t *testing.T) {
+	clientAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4321}
+
+	t.Run("credentials on stdin", func(t *testing.T) {
+		script := writeScript(t, `
+read user
+read pass
+read addr
+[ "$user" = admin ] && [ "$pass" = 123456 ] && [ "$addr" = 127.0.0.1:4321 ] && exit 0
+exit 1
+`)
+		a := &CommandAuthenticator{Path: script}
+		user, err := a.Authenticate("admin", "123456", clientAddr)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		if user.Name != "admin" {
+			t.Fatalf("want user admin but got %s", user.Name)
+		}
+		if _, err := a.Authenticate("admin", "wrong", clientAddr); err != ErrPasswordAuthFailure {
+			t.Fatalf("want error %s but got %v", ErrPasswordAuthFailure, err)
+		}
+	})
+
+	t.Run("credentials in environment with json output", func(t *testing.T) {
+		script := writeScript(t, `
+[ "$SOCKS5_USERNAME" = zhangsan ] && [ "$SOCKS5_PASSWORD" = 1234 ] || exit 1
+echo '{"name": "zs", "groups": ["staff"], "attributes": {"team": "infra"}}'
+`)
+		a := &CommandAuthenticator{Path: script, UseEnv: true}
+		user, err := a.Authenticate("zhangsan", "1234", clientAddr)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		want := &User{Name: "zs", Groups: []string{"staff"}, Attributes: map[string]string{"team": "infra"}}
+		if !reflect.DeepEqual(user, want) {
+			t.Fatalf("want user %v but got %v", want, user)
+		}
+	})
+
+	t.Run("unexpected exit status is an error", func(t *testing.T) {
+		a := &CommandAuthenticator{Path: writeScript(t, "exit 111\n")}
+		_, err := a.Authenticate("admin", "123456", nil)
+		if err == nil || errors.Is(err, ErrPasswordAuthFailure) {
+			t.Fatalf("want backend error but got %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		a := &CommandAuthenticator{Path: writeScript(t, "exec sleep 5\n"), Timeout: 100 * time.Millisecond}
+		start := time.Now()
+		if _, err := a.Authenticate("admin", "123456", nil); err == nil {
+			t.Fatalf("should get timeout error but got nil")
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("command was not killed after the timeout")
+		}
+	})
+
+	t.Run("timeout with a child holding stdout", func(t *testing.T) {
+		a := &CommandAuthenticator{Path: writeScript(t, "sleep 5 &\nsleep 5\n"), Timeout: 100 * time.Millisecond}
+		start := time.Now()
+		if _, err := a.Authenticate("admin", "123456", nil); err == nil {
+			t.Fatalf("should get timeout error but got nil")
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("command output was waited for after the timeout")
+		}
+	})
+
+	t.Run("results are cached", func(t *testing.T) {
+		counter := filepath.Join(t.TempDir(), "runs")
+		script := writeScript(t, `
+echo run >> "`+counter+`"
+read user
+[ "$user" = admin ] && exit 0
+exit 1
+`)
+		a := &CommandAuthenticator{Path: script, CacheTTL: time.Minute, FailureCacheTTL: time.Minute, MaxConcurrent: 1}
+		for i := 0; i < 3; i++ {
+			if _, err := a.Authenticate("admin", "123456", nil); err != nil {
+				t.Fatalf("should get error nil but got %s", err)
+			}
+			if _, err := a.Authenticate("lisi", "abde", nil); err != ErrPasswordAuthFailure {
+				t.Fatalf("want error %s but got %v", ErrPasswordAuthFailure, err)
+			}
+		}
+		runs, err := os.ReadFile(counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(runs), "run"); n != 2 {
+			t.Fatalf("want command run 2 times but ran %d times", n)
+		}
+	})
+
+	t.Run("results are cached per client", func(t *testing.T) {
+		script := writeScript(t, `
+read user
+read pass
+read addr
+case "$addr" in 127.0.0.1:*) exit 0 ;; esac
+exit 1
+`)
+		a := &CommandAuthenticator{Path: script, CacheTTL: time.Minute, FailureCacheTTL: time.Minute}
+		if _, err := a.Authenticate("admin", "123456", clientAddr); err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		other := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321}
+		if _, err := a.Authenticate("admin", "123456", other); err != ErrPasswordAuthFailure {
+			t.Fatalf("want error %s for another client but got %v", ErrPasswordAuthFailure, err)
+		}
+		// another port of the same client is answered from the cache
+		if _, err := a.Authenticate("admin", "123456", &net.TCPAddr{IP: clientAddr.IP, Port: 5678}); err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package socks5
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+LDAPAuthenticator checks credentials by binding to an LDAP directory.
+
+Two ways of finding the user's DN are supported:
+
+	o  BindDN template, the username is escaped and substituted for %s,
+	   e.g. "uid=%s,ou=people,dc=example,dc=com"
+	o  search then bind, SearchFilter is run below SearchBase with the
+	   service account ServiceDN/ServicePassword, e.g. "(uid=%s)", and
+	   the single entry found is bound with the user's password
+
+Group membership is read from GroupAttribute of the user's entry, memberOf
+by convention. Each value's first RDN value becomes a group, so
+"cn=staff,ou=groups,dc=example,dc=com" is reported as "staff".
+*/
+type LDAPAuthenticator struct {
+	// URL of the directory, ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades an ldap:// connection before binding
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// Timeout bounds connecting and every single operation, defaults to 5 seconds.
+	Timeout time.Duration
+
+	BindDN string
+
+	SearchBase      string
+	SearchFilter    string
+	ServiceDN       string
+	ServicePassword string
+
+	GroupAttribute string
+	// RequiredGroups rejects users who are in none of these groups.
+	RequiredGroups []string
+	// Attributes are copied from the user's entry to User.Attributes.
+	Attributes []string
+
+	// PoolSize is the number of idle connections kept open, defaults to 4.
+	PoolSize int
+	// CacheTTL keeps successful logins for the given time. Zero disables caching.
+	CacheTTL time.Duration
+
+	mu sync.Mutex
+	// closed makes logins still going on close their connections instead of pooling them
+	closed bool
+	idle   []*ldapConn
+	cache  credentialCache
+}
+
+const (
+	defaultLDAPTimeout  = 5 * time.Second
+	defaultLDAPPoolSize = 4
+)
+
+var errLDAPNoUserDN = errors.New("ldap: either BindDN or SearchBase and SearchFilter must be set")
+
+func (a *LDAPAuthenticator) Authenticate(username, password string, clientAddr net.Addr) (*User, error) {
+	// an empty password is an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, ErrPasswordAuthFailure
+	}
+
+	key := credentialKey(username, password)
+	if user, ok := a.cache.lookup(key); ok {
+		return user, nil
+	}
+
+	conn, pooled, err := a.get()
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.authenticate(conn, username, password)
+	if err != nil && !errors.Is(err, ErrPasswordAuthFailure) && pooled {
+		// the server may have closed the idle connection, retry once on a new one
+		conn.close()
+		if conn, err = a.dial(); err != nil {
+			return nil, err
+		}
+		user, err = a.authenticate(conn, username, password)
+	}
+	if err != nil && !errors.Is(err, ErrPasswordAuthFailure) {
+		// the connection may be in an unknown state
+		conn.close()
+		return nil, err
+	}
+	a.put(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cache.store(key, user, a.CacheTTL)
+	return user, nil
+}
+
+func (a *LDAPAuthenticator) authenticate(conn *ldapConn, username, password string) (*User, error) {
+	attributes := append([]string{}, a.Attributes...)
+	if a.GroupAttribute != "" {
+		attributes = append(attributes, a.GroupAttribute)
+	}
+
+	var dn string
+	var entry *ldapEntry
+	switch {
+	case a.SearchBase != "" && a.SearchFilter != "":
+		if err := conn.bind(a.ServiceDN, a.ServicePassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind: %w", err)
+		}
+		filter := strings.ReplaceAll(a.SearchFilter, "%s", escapeLDAPFilterValue(username))
+		entries, err := conn.search(a.SearchBase, ldapScopeSubtree, filter, attributes)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 {
+			return nil, ErrPasswordAuthFailure
+		}
+		entry = &entries[0]
+		dn = entry.DN
+	case a.BindDN != "":
+		dn = strings.ReplaceAll(a.BindDN, "%s", escapeLDAPDN(username))
+	default:
+		return nil, errLDAPNoUserDN
+	}
+
+	if err := conn.bind(dn, password); err != nil {
+		if errors.Is(err, errLDAPInvalidCredentials) {
+			return nil, ErrPasswordAuthFailure
+		}
+		return nil, err
+	}
+
+	if entry == nil && len(attributes) > 0 {
+		entries, err := conn.search(dn, ldapScopeBase, "(objectClass=*)", attributes)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 1 {
+			entry = &entries[0]
+		}
+	}
+
+	user := &User{Name: username}
+	if entry != nil {
+		if a.GroupAttribute != "" {
+			for _, v := range entry.Attributes[strings.ToLower(a.GroupAttribute)] {
+				user.Groups = append(user.Groups, ldapGroupName(v))
+			}
+		}
+		for _, name := range a.Attributes {
+			if values := entry.Attributes[strings.ToLower(name)]; len(values) > 0 {
+				if user.Attributes == nil {
+					user.Attributes = make(map[string]string)
+				}
+				user.Attributes[name] = values[0]
+			}
+		}
+	}
+
+	if len(a.RequiredGroups) > 0 {
+		member := false
+		for _, g := range a.RequiredGroups {
+			if user.InGroup(g) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return nil, ErrPasswordAuthFailure
+		}
+	}
+	return user, nil
+}
+
+// ldapGroupName returns the value of the first RDN of dn, or dn itself if it is not a DN.
+func ldapGroupName(dn string) string {
+	rdn := dn
+	for i := 0; i < len(dn); i++ {
+		if dn[i] == '\\' {
+			i++
+			continue
+		}
+		if dn[i] == ',' {
+			rdn = dn[:i]
+			break
+		}
+	}
+	if eq := strings.IndexByte(rdn, '='); eq >= 0 {
+		return rdn[eq+1:]
+	}
+	return dn
+}
+
+// get returns an idle connection from the pool or a new one.
+func (a *LDAPAuthenticator) get() (conn *ldapConn, pooled bool, err error) {
+	a.mu.Lock()
+	if n := len(a.idle); n > 0 {
+		conn := a.idle[n-1]
+		a.idle = a.idle[:n-1]
+		a.mu.Unlock()
+		return conn, true, nil
+	}
+	a.mu.Unlock()
+
+	conn, err = a.dial()
+	return conn, false, err
+}
+
+func (a *LDAPAuthenticator) dial() (*ldapConn, error) {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = defaultLDAPTimeout
+	}
+	return dialLDAP(a.URL, a.StartTLS, a.TLSConfig, timeout)
+}
+
+func (a *LDAPAuthenticator) put(conn *ldapConn) {
+	size := a.PoolSize
+	if size <= 0 {
+		size = defaultLDAPPoolSize
+	}
+	a.mu.Lock()
+	if !a.closed && len(a.idle) < size {
+		a.idle = append(a.idle, conn)
+		conn = nil
+	}
+	a.mu.Unlock()
+	if conn != nil {
+		conn.close()
+	}
+}
+
+// Close closes the idle connections, and those of logins going on once they are done.
+func (a *LDAPAuthenticator) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	idle := a.idle
+	a.idle = nil
+	a.mu.Unlock()
+	for _, conn := range idle {
+		conn.close()
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package socks5
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeLDAPEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLDAPServer is an in-process stand-in for a directory. It understands
+// simple bind, search with equality, presence and and filters, and unbind.
+type fakeLDAPServer struct {
+	entries map[string]fakeLDAPEntry
+	binds   int32
+	l       net.Listener
+}
+
+func startFakeLDAPServer(t *testing.T, entries map[string]fakeLDAPEntry) *fakeLDAPServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeLDAPServer{entries: entries, l: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAPServer) url() string {
+	return "ldap://" + s.l.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readBER(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.child(0).int(), msg.child(1)
+		reply := func(op *berPacket) {
+			conn.Write(berSequence(berInt(id), op).bytes())
+		}
+		result := func(tag byte, code int64) {
+			reply(berConstruct(berClassApplication, tag, berEnum(code), berString(""), berString("")))
+		}
+
+		switch op.tag {
+		case ldapOpBindRequest:
+			atomic.AddInt32(&s.binds, 1)
+			dn, password := op.child(1).str(), op.child(2).str()
+			if entry, ok := s.entries[dn]; ok && entry.password == password {
+				result(ldapOpBindResponse, ldapResultSuccess)
+			} else {
+				result(ldapOpBindResponse, ldapResultInvalidCredentials)
+			}
+		case ldapOpSearchRequest:
+			base, scope, filter := op.child(0).str(), op.child(1).int(), op.child(6)
+			for dn, entry := range s.entries {
+				if scope == ldapScopeBase && dn != base || !strings.HasSuffix(dn, base) {
+					continue
+				}
+				if !fakeLDAPMatch(filter, dn, entry) {
+					continue
+				}
+				attrs := berSequence()
+				for name, values := range entry.attributes {
+					set := berConstruct(berClassUniversal, berTagSet)
+					for _, v := range values {
+						set.children = append(set.children, berString(v))
+					}
+					attrs.children = append(attrs.children, berSequence(berString(name), set))
+				}
+				reply(berConstruct(berClassApplication, ldapOpSearchResultEntry, berString(dn), attrs))
+			}
+			result(ldapOpSearchResultDone, ldapResultSuccess)
+		case ldapOpUnbindRequest:
+			return
+		}
+	}
+}
+
+func fakeLDAPMatch(filter *berPacket, dn string, entry fakeLDAPEntry) bool {
+	switch filter.tag {
+	case 0:
+		for _, f := range filter.children {
+			if !fakeLDAPMatch(f, dn, entry) {
+				return false
+			}
+		}
+		return true
+	case 3:
+		for _, v := range entry.attributes[filter.child(0).str()] {
+			if v == filter.child(1).str() {
+				return true
+			}
+		}
+	case 7:
+		return filter.str() == "objectClass" || entry.attributes[filter.str()] != nil
+	}
+	return false
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	server := startFakeLDAPServer(t, map[string]fakeLDAPEntry{
+		"cn=proxy,dc=example,dc=com": {password: "service"},
+		"uid=admin,ou=people,dc=example,dc=com": {
+			password: "123456",
+			attributes: map[string][]string{
+				"uid":      {"admin"},
+				"mail":     {"admin@example.com"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=com", "cn=ops,ou=groups,dc=example,dc=com"},
+			},
+		},
+		"uid=lisi,ou=people,dc=example,dc=com": {
+			password:   "abde",
+			attributes: map[string][]string{"uid": {"lisi"}},
+		},
+	})
+
+	t.Run("bind dn template", func(t *testing.T) {
+		a := &LDAPAuthenticator{
+			URL:            server.url(),
+			BindDN:         "uid=%s,ou=people,dc=example,dc=com",
+			GroupAttribute: "memberOf",
+			Attributes:     []string{"mail"},
+		}
+		defer a.Close()
+		user, err := a.Authenticate("admin", "123456", nil)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		want := &User{Name: "admin", Groups: []string{"staff", "ops"}, Attributes: map[string]string{"mail": "admin@example.com"}}
+		if !reflect.DeepEqual(user, want) {
+			t.Fatalf("want user %v but got %v", want, user)
+		}
+		if _, err := a.Authenticate("admin", "wrong", nil); err != ErrPasswordAuthFailure {
+			t.Fatalf("want error %s but got %v", ErrPasswordAuthFailure, err)
+		}
+		if _, err := a.Authenticate("admin", "", nil); err != ErrPasswordAuthFailure {
+			t.Fatalf("empty password: want error %s but got %v", ErrPasswordAuthFailure, err)
+		}
+	})
+
+	t.Run("search then bind", func(t *testing.T) {
+		a := &LDAPAuthenticator{
+			URL:             server.url(),
+			SearchBase:      "ou=people,dc=example,dc=com",
+			SearchFilter:    "(uid=%s)",
+			ServiceDN:       "cn=proxy,dc=example,dc=com",
+			ServicePassword: "service",
+			GroupAttribute:  "memberOf",
+			RequiredGroups:  []string{"ops"},
+		}
+		defer a.Close()
+		user, err := a.Authenticate("admin", "123456", nil)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		if !user.InGroup("ops") {
+			t.Fatalf("want user in group ops but got %v", user.Groups)
+		}
+		if _, err := a.Authenticate("lisi", "abde", nil); err != ErrPasswordAuthFailure {
+			t.Fatalf("user outside required groups: want error %s but got %v", ErrPasswordAuthFailure, err)
+		}
+		if _, err := a.Authenticate("*", "123456", nil); err != ErrPasswordAuthFailure {
+			t.Fatalf("wildcard username: want error %s but got %v", ErrPasswordAuthFailure, err)
+		}
+	})
+
+	t.Run("successful logins are cached", func(t *testing.T) {
+		a := &LDAPAuthenticator{
+			URL:      server.url(),
+			BindDN:   "uid=%s,ou=people,dc=example,dc=com",
+			CacheTTL: time.Minute,
+		}
+		defer a.Close()
+		before := atomic.LoadInt32(&server.binds)
+		for i := 0; i < 3; i++ {
+			if _, err := a.Authenticate("lisi", "abde", nil); err != nil {
+				t.Fatalf("should get error nil but got %s", err)
+			}
+		}
+		if binds := atomic.LoadInt32(&server.binds) - before; binds != 1 {
+			t.Fatalf("want 1 bind but got %d", binds)
+		}
+	})
+
+	t.Run("close with a login going on", func(t *testing.T) {
+		a := &LDAPAuthenticator{URL: server.url(), BindDN: "uid=%s,ou=people,dc=example,dc=com"}
+		conn, _, err := a.get()
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		a.Close()
+		// the login finishes after the authenticator was replaced on reload
+		a.put(conn)
+		if len(a.idle) != 0 {
+			t.Fatalf("want no connection pooled after close but got %d", len(a.idle))
+		}
+		if _, err := conn.conn.Write([]byte{0}); err == nil {
+			t.Fatal("want the connection closed")
+		}
+	})
+
+	t.Run("directory unreachable", func(t *testing.T) {
+		a := &LDAPAuthenticator{URL: "ldap://127.0.0.1:1", BindDN: "uid=%s", Timeout: time.Second}
+		_, err := a.Authenticate("admin", "123456", nil)
+		if err == nil || err == ErrPasswordAuthFailure {
+			t.Fatalf("want connection error but got %v", err)
+		}
+	})
+}
+
+func TestEscapeLDAP(t *testing.T) {
+	if got := escapeLDAPFilterValue("a*(b)\\"); got != `a\2a\28b\29\5c` {
+		t.Errorf("filter escape: got %s", got)
+	}
+	if got := escapeLDAPDN(" a,b+c "); got != `\ a\,b\+c\ ` {
+		t.Errorf("dn escape: got %s", got)
+	}
+	f, err := compileLDAPFilter(`(&(uid=a\2ab)(mail=*@example.com)(cn=*))`)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if len(f.children) != 3 || f.child(0).child(1).str() != "a*b" || f.child(1).tag != 4 || f.child(2).tag != 7 {
+		t.Errorf("unexpected filter encoding %v", f)
+	}
+}
@@ -0,0 +1,117 @@
+package socks5
+
+import (
+	"crypto/sha256"
+	"net"
+	"sync"
+	"time"
+)
+
+// User is the identity of an authenticated client.
+// Groups and Attributes are filled in by authenticators that know about them
+// and are available to rules evaluated later in the request.
+type User struct {
+	Name       string
+	Groups     []string
+	Attributes map[string]string
+}
+
+// InGroup reports whether the user is a member of group.
+func (u *User) InGroup(group string) bool {
+	if u == nil {
+		return false
+	}
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordAuthenticator verifies the credentials sent in the
+// username/password subnegotiation (RFC 1929).
+//
+// Authenticate returns ErrPasswordAuthFailure when the credentials are wrong
+// and any other error when the backend could not decide.
+type PasswordAuthenticator interface {
+	Authenticate(username, password string, clientAddr net.Addr) (*User, error)
+}
+
+// PasswordCheckerFunc adapts a Config.PasswordChecker function to a PasswordAuthenticator.
+type PasswordCheckerFunc func(username, password string) bool
+
+func (f PasswordCheckerFunc) Authenticate(username, password string, clientAddr net.Addr) (*User, error) {
+	if !f(username, password) {
+		return nil, ErrPasswordAuthFailure
+	}
+	return &User{Name: username}, nil
+}
+
+// remoteAddr returns the peer address of conn if it has one.
+func remoteAddr(conn interface{}) net.Addr {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return c.RemoteAddr()
+	}
+	return nil
+}
+
+const maxCredentialCacheSize = 4096
+
+// credentialCache remembers authentication results keyed by a hash of the credentials.
+// A nil user records a rejection.
+type credentialCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]credentialCacheEntry
+}
+
+type credentialCacheEntry struct {
+	user    *User
+	expires time.Time
+}
+
+// credentialKey hashes the credentials and anything else a result depends on
+func credentialKey(username, password string, more ...string) [sha256.Size]byte {
+	s := username + "\x00" + password
+	for _, m := range more {
+		s += "\x00" + m
+	}
+	return sha256.Sum256([]byte(s))
+}
+
+func (c *credentialCache) lookup(key [sha256.Size]byte) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.user, true
+}
+
+func (c *credentialCache) store(key [sha256.Size]byte, user *User, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]credentialCacheEntry)
+	}
+	now := time.Now()
+	if len(c.entries) >= maxCredentialCacheSize {
+		for k, v := range c.entries {
+			if now.After(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= maxCredentialCacheSize {
+		return
+	}
+	c.entries[key] = credentialCacheEntry{user: user, expires: now.Add(ttl)}
+}
@@ -0,0 +1,191 @@
+package socks5
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/yongfrank/go-socks5/wire"
+)
+
+// Dialer connects to targets through a SOCKS5 server.
+type Dialer struct {
+	// ProxyNetwork defaults to tcp
+	ProxyNetwork string
+	ProxyAddress string
+
+	// Username and Password are offered with RFC 1929 when Username is set
+	Username string
+	Password string
+
+	// TLSConfig, if set, wraps the proxy connection in TLS,
+	// for servers with SOCKS5Server.TLSConfig.
+	// Set Certificates for servers requiring a client certificate.
+	TLSConfig *tls.Config
+
+	// WebSocketURL, if set, reaches the proxy through a WebSocket at a ws:// or wss:// URL,
+	// for servers with SOCKS5Server.WebSocketPath. ProxyAddress is not used then.
+	WebSocketURL string
+
+	// Timeout bounds connecting to the proxy and the SOCKS negotiation
+	Timeout time.Duration
+}
+
+var ErrNoAcceptableMethod = errors.New("socks5 server accepted none of the offered methods")
+
+// ReplyError is returned when the server rejects a request.
+type ReplyError struct {
+	Reply ReplyType
+}
+
+func (e *ReplyError) Error() string {
+	return "socks5 server replied: " + ErrorString(e.Reply)
+}
+
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address through the proxy.
+// Only the tcp networks are supported.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks5: network %s not supported", network)
+	}
+	dst, err := ParseAddr(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	conn, err := d.dialProxy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the negotiation honours the context through the connection deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	err = d.negotiate(conn, CmdConnect, dst)
+	// the watcher is stopped before the deadline is cleared, it must not
+	// touch the connection once it is handed to the caller
+	close(stop)
+	<-done
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (d *Dialer) dialProxy(ctx context.Context) (net.Conn, error) {
+	if d.WebSocketURL != "" {
+		return DialWebSocket(ctx, d.WebSocketURL, d.TLSConfig)
+	}
+	network := d.ProxyNetwork
+	if network == "" {
+		network = "tcp"
+	}
+	if d.TLSConfig == nil {
+		var nd net.Dialer
+		return nd.DialContext(ctx, network, d.ProxyAddress)
+	}
+	config := d.TLSConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(d.ProxyAddress); err == nil {
+			config.ServerName = host
+		}
+	}
+	td := &tls.Dialer{Config: config}
+	return td.DialContext(ctx, network, d.ProxyAddress)
+}
+
+func (d *Dialer) negotiate(conn net.Conn, cmd Command, dst Addr) error {
+	selection := wire.MethodSelection{Methods: []byte{MethodNoAuth}}
+	if d.Username != "" {
+		selection.Methods = append(selection.Methods, MethodPassword)
+	}
+	if _, err := selection.WriteTo(conn); err != nil {
+		return err
+	}
+
+	var method wire.MethodReply
+	if _, err := method.ReadFrom(conn); err != nil {
+		return err
+	}
+	switch method.Method {
+	case MethodNoAuth:
+	case MethodPassword:
+		if err := d.passwordAuth(conn); err != nil {
+			return err
+		}
+	default:
+		return ErrNoAcceptableMethod
+	}
+
+	if err := writeClientRequest(conn, cmd, dst); err != nil {
+		return err
+	}
+	_, err := readReply(conn)
+	return err
+}
+
+func (d *Dialer) passwordAuth(conn io.ReadWriter) error {
+	req := wire.PasswordRequest{Username: d.Username, Password: d.Password}
+	if _, err := req.WriteTo(conn); err != nil {
+		return err
+	}
+	var resp wire.PasswordResponse
+	if _, err := resp.ReadFrom(conn); err != nil {
+		return err
+	}
+	if resp.Status != PasswordAuthSuccess {
+		return ErrPasswordAuthFailure
+	}
+	return nil
+}
+
+// writeClientRequest sends a request in one Write
+func writeClientRequest(conn io.Writer, cmd Command, dst Addr) error {
+	req := wire.Request{Cmd: cmd, Dst: dst}
+	_, err := req.WriteTo(conn)
+	return err
+}
+
+// readReply reads a reply and returns the bound address
+func readReply(conn io.Reader) (Addr, error) {
+	var reply wire.Reply
+	if _, err := reply.ReadFrom(conn); err != nil {
+		return Addr{}, err
+	}
+	if reply.Rep != ReplySuccess {
+		return Addr{}, &ReplyError{Reply: reply.Rep}
+	}
+	return reply.Bound, nil
+}
@@ -0,0 +1,73 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDialer(t *testing.T) {
+	echo := startEchoServer(t)
+	config := &Config{
+		AuthMethod: MethodPassword,
+		PasswordChecker: func(username, password string) bool {
+			return username == "admin" && password == "123456"
+		},
+		Rules: &AccessRules{Rules: []AccessRule{{Deny: true, Ports: []uint16{1}}}},
+	}
+	proxyAddr := startTestServer(t, config)
+
+	t.Run("connect", func(t *testing.T) {
+		d := &Dialer{ProxyAddress: proxyAddr, Username: "admin", Password: "123456", Timeout: 5 * time.Second}
+		conn, err := d.Dial("tcp", echo.String())
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("ping"))
+		got := make([]byte, 4)
+		if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+			t.Fatalf("want echo ping but got %q, %v", got, err)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		d := &Dialer{ProxyAddress: proxyAddr, Username: "admin", Password: "wrong", Timeout: 5 * time.Second}
+		if _, err := d.Dial("tcp", echo.String()); err != ErrPasswordAuthFailure {
+			t.Fatalf("want error %s but got %v", ErrPasswordAuthFailure, err)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		d := &Dialer{ProxyAddress: proxyAddr, Timeout: 5 * time.Second}
+		if _, err := d.Dial("tcp", echo.String()); err != ErrNoAcceptableMethod {
+			t.Fatalf("want error %s but got %v", ErrNoAcceptableMethod, err)
+		}
+	})
+
+	t.Run("request rejected", func(t *testing.T) {
+		d := &Dialer{ProxyAddress: proxyAddr, Username: "admin", Password: "123456", Timeout: 5 * time.Second}
+		_, err := d.Dial("tcp", "localhost:1")
+		var replyErr *ReplyError
+		if !errors.As(err, &replyErr) || replyErr.Reply != ReplyConnectionNotAllowed {
+			t.Fatalf("want reply %s but got %v", ErrorString(ReplyConnectionNotAllowed), err)
+		}
+	})
+}
+
+func TestDialerConnOutlivesTimeout(t *testing.T) {
+	target := startEchoServer(t)
+	config := &Config{AuthMethod: MethodNoAuth}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 100 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		conn, err := d.Dial("tcp", target.String())
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		// the connection is the caller's once returned, the timeout no longer applies
+		time.Sleep(150 * time.Millisecond)
+		echo(t, conn, "after the timeout")
+		conn.Close()
+	}
+}
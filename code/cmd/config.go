@@ -0,0 +1,811 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yongfrank/go-socks5"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the configuration file, YAML, JSON or TOML by its extension.
+//
+//	listen:
+//	  - label: local
+//	    address: 127.0.0.1:1080
+//	    auth: none
+//	  - label: public
+//	    address: :1081
+//	auth: password
+//	users_file: /etc/socks5/users
+//	tcp_timeout: 5s
+//	rules:
+//	  - deny: true
+//	    destinations: [10.0.0.0/8]
+type fileConfig struct {
+	Listen []listenConfig `json:"listen" yaml:"listen" toml:"listen"`
+
+	// Auth is none, password, command or ldap
+	Auth string `json:"auth" yaml:"auth" toml:"auth"`
+	// Users and UsersFile are the accounts of password auth,
+	// the file has a username:password per line
+	Users     map[string]string `json:"users" yaml:"users" toml:"users"`
+	UsersFile string            `json:"users_file" yaml:"users_file" toml:"users_file"`
+	Command   commandConfig     `json:"command" yaml:"command" toml:"command"`
+	LDAP      ldapConfig        `json:"ldap" yaml:"ldap" toml:"ldap"`
+
+	TCPTimeout      duration `json:"tcp_timeout" yaml:"tcp_timeout" toml:"tcp_timeout"`
+	MaxConnections  int      `json:"max_connections" yaml:"max_connections" toml:"max_connections"`
+	SendProxyHeader byte     `json:"send_proxy_header" yaml:"send_proxy_header" toml:"send_proxy_header"`
+	// AddressPreference is interleave, ipv4 or ipv6, the order domain addresses are dialed in
+	AddressPreference      string   `json:"address_preference" yaml:"address_preference" toml:"address_preference"`
+	ConnectionAttemptDelay duration `json:"connection_attempt_delay" yaml:"connection_attempt_delay" toml:"connection_attempt_delay"`
+	// SocketOptions are set on direct connections, routes can set their own
+	SocketOptions *socketOptionsConfig `json:"socket_options" yaml:"socket_options" toml:"socket_options"`
+	// SourcePool chooses the local address of direct connections
+	SourcePool *sourcePoolConfig `json:"source_pool" yaml:"source_pool" toml:"source_pool"`
+
+	Rules       []ruleConfig `json:"rules" yaml:"rules" toml:"rules"`
+	DefaultDeny bool         `json:"default_deny" yaml:"default_deny" toml:"default_deny"`
+	// Rewrites replace destinations before dialing, such as api.internal:443 -> 10.2.3.4:8443
+	Rewrites []rewriteConfig `json:"rewrites" yaml:"rewrites" toml:"rewrites"`
+	// Routes choose the outbound path of requests, the first matching route wins
+	Routes       []routeConfig             `json:"routes" yaml:"routes" toml:"routes"`
+	DefaultRoute routeConfig               `json:"default_route" yaml:"default_route" toml:"default_route"`
+	Upstreams    map[string]upstreamConfig `json:"upstreams" yaml:"upstreams" toml:"upstreams"`
+	// UpstreamGroups balance routes over upstreams, routes name them like upstreams
+	UpstreamGroups map[string]upstreamGroupConfig `json:"upstream_groups" yaml:"upstream_groups" toml:"upstream_groups"`
+	// GeoIP are MaxMind DB files, such as a country and an ASN database,
+	// for the countries and asns of rules and routes and the access log
+	GeoIP []string `json:"geoip" yaml:"geoip" toml:"geoip"`
+
+	// LogLevel is info, or error to only report failures of the binary itself
+	LogLevel string `json:"log_level" yaml:"log_level" toml:"log_level"`
+
+	// Admin serves the admin HTTP API, it is not reloaded
+	Admin adminConfig `json:"admin" yaml:"admin" toml:"admin"`
+	// Process sets user, group, PID file, open files limit and chroot
+	Process processConfig `json:"process" yaml:"process" toml:"process"`
+}
+
+type adminConfig struct {
+	Address string `json:"address" yaml:"address" toml:"address"`
+	// Token is required from admin clients, without it only loopback clients are served
+	Token string `json:"token" yaml:"token" toml:"token"`
+}
+
+type listenConfig struct {
+	Label string `json:"label" yaml:"label" toml:"label"`
+	// Network is tcp, tcp4, tcp6 or unix
+	Network string `json:"network" yaml:"network" toml:"network"`
+	Address string `json:"address" yaml:"address" toml:"address"`
+	// Auth overrides the top level auth for this listener
+	Auth string `json:"auth" yaml:"auth" toml:"auth"`
+
+	TLS           *tlsConfig `json:"tls" yaml:"tls" toml:"tls"`
+	WebSocketPath string     `json:"websocket_path" yaml:"websocket_path" toml:"websocket_path"`
+
+	// UnixMode is an octal file mode such as 0660
+	UnixMode  string `json:"unix_mode" yaml:"unix_mode" toml:"unix_mode"`
+	UnixUser  string `json:"unix_user" yaml:"unix_user" toml:"unix_user"`
+	UnixGroup string `json:"unix_group" yaml:"unix_group" toml:"unix_group"`
+	// PeerCredUsers identifies Unix socket clients as their local account
+	PeerCredUsers bool `json:"peer_cred_users" yaml:"peer_cred_users" toml:"peer_cred_users"`
+
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+type tlsConfig struct {
+	Cert string `json:"cert" yaml:"cert" toml:"cert"`
+	Key  string `json:"key" yaml:"key" toml:"key"`
+	// ClientCA, if set, verifies client certificates against it
+	ClientCA string `json:"client_ca" yaml:"client_ca" toml:"client_ca"`
+	// ClientAuth is require, the default, or optional, letting clients without
+	// a certificate in to authenticate otherwise
+	ClientAuth string `json:"client_auth" yaml:"client_auth" toml:"client_auth"`
+	// CertUser is cn or email, naming the user after the client certificate
+	CertUser string `json:"cert_user" yaml:"cert_user" toml:"cert_user"`
+}
+
+type commandConfig struct {
+	Path            string   `json:"path" yaml:"path" toml:"path"`
+	Args            []string `json:"args" yaml:"args" toml:"args"`
+	UseEnv          bool     `json:"use_env" yaml:"use_env" toml:"use_env"`
+	Timeout         duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	MaxConcurrent   int      `json:"max_concurrent" yaml:"max_concurrent" toml:"max_concurrent"`
+	CacheTTL        duration `json:"cache_ttl" yaml:"cache_ttl" toml:"cache_ttl"`
+	FailureCacheTTL duration `json:"failure_cache_ttl" yaml:"failure_cache_ttl" toml:"failure_cache_ttl"`
+}
+
+type ldapConfig struct {
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	StartTLS bool   `json:"start_tls" yaml:"start_tls" toml:"start_tls"`
+	// CAFile verifies the directory's certificate instead of the system roots
+	CAFile          string   `json:"ca_file" yaml:"ca_file" toml:"ca_file"`
+	Timeout         duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	BindDN          string   `json:"bind_dn" yaml:"bind_dn" toml:"bind_dn"`
+	SearchBase      string   `json:"search_base" yaml:"search_base" toml:"search_base"`
+	SearchFilter    string   `json:"search_filter" yaml:"search_filter" toml:"search_filter"`
+	ServiceDN       string   `json:"service_dn" yaml:"service_dn" toml:"service_dn"`
+	ServicePassword string   `json:"service_password" yaml:"service_password" toml:"service_password"`
+	GroupAttribute  string   `json:"group_attribute" yaml:"group_attribute" toml:"group_attribute"`
+	RequiredGroups  []string `json:"required_groups" yaml:"required_groups" toml:"required_groups"`
+	Attributes      []string `json:"attributes" yaml:"attributes" toml:"attributes"`
+	PoolSize        int      `json:"pool_size" yaml:"pool_size" toml:"pool_size"`
+	CacheTTL        duration `json:"cache_ttl" yaml:"cache_ttl" toml:"cache_ttl"`
+}
+
+type rewriteConfig struct {
+	From string `json:"from" yaml:"from" toml:"from"`
+	To   string `json:"to" yaml:"to" toml:"to"`
+}
+
+type routeConfig struct {
+	Name         string   `json:"name" yaml:"name" toml:"name"`
+	Destinations []string `json:"destinations" yaml:"destinations" toml:"destinations"`
+	Ports        []uint16 `json:"ports" yaml:"ports" toml:"ports"`
+	Users        []string `json:"users" yaml:"users" toml:"users"`
+	Groups       []string `json:"groups" yaml:"groups" toml:"groups"`
+	// Countries and ASNs match the destination, ClientCountries and ClientASNs the client
+	Countries       []string `json:"countries" yaml:"countries" toml:"countries"`
+	ASNs            []uint32 `json:"asns" yaml:"asns" toml:"asns"`
+	ClientCountries []string `json:"client_countries" yaml:"client_countries" toml:"client_countries"`
+	ClientASNs      []uint32 `json:"client_asns" yaml:"client_asns" toml:"client_asns"`
+	// Action is direct, upstream, interface or reject, direct if empty
+	Action    string `json:"action" yaml:"action" toml:"action"`
+	Upstream  string `json:"upstream" yaml:"upstream" toml:"upstream"`
+	Interface string `json:"interface" yaml:"interface" toml:"interface"`
+
+	SocketOptions *socketOptionsConfig `json:"socket_options" yaml:"socket_options" toml:"socket_options"`
+}
+
+// socketOptionsConfig are Linux socket options of outbound connections
+type socketOptionsConfig struct {
+	Mark         int    `json:"mark" yaml:"mark" toml:"mark"`
+	BindToDevice string `json:"bind_to_device" yaml:"bind_to_device" toml:"bind_to_device"`
+	// TOS is the IPv4 TOS or IPv6 traffic class byte, the DSCP shifted left by 2
+	TOS               int      `json:"tos" yaml:"tos" toml:"tos"`
+	KeepAlive         duration `json:"keepalive" yaml:"keepalive" toml:"keepalive"`
+	KeepAliveInterval duration `json:"keepalive_interval" yaml:"keepalive_interval" toml:"keepalive_interval"`
+	KeepAliveCount    int      `json:"keepalive_count" yaml:"keepalive_count" toml:"keepalive_count"`
+	Nagle             bool     `json:"nagle" yaml:"nagle" toml:"nagle"`
+	UserTimeout       duration `json:"user_timeout" yaml:"user_timeout" toml:"user_timeout"`
+}
+
+// upstreamConfig is a SOCKS5 proxy routes can send requests through
+type upstreamConfig struct {
+	Address  string `json:"address" yaml:"address" toml:"address"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+}
+
+type sourcePoolConfig struct {
+	// Addresses are local IP addresses or interface names
+	Addresses []string `json:"addresses" yaml:"addresses" toml:"addresses"`
+	// Strategy is round-robin, random, per-user or sticky
+	Strategy string `json:"strategy" yaml:"strategy" toml:"strategy"`
+	// Users pins users to a source address with per-user
+	Users map[string]string `json:"users" yaml:"users" toml:"users"`
+}
+
+type upstreamGroupConfig struct {
+	Upstreams []string `json:"upstreams" yaml:"upstreams" toml:"upstreams"`
+	// Strategy is round-robin, random, least-connections, latency, hash-user or hash-destination
+	Strategy    string            `json:"strategy" yaml:"strategy" toml:"strategy"`
+	HealthCheck healthCheckConfig `json:"health_check" yaml:"health_check" toml:"health_check"`
+	// FailureThreshold failures eject an upstream for EjectDuration
+	FailureThreshold int      `json:"failure_threshold" yaml:"failure_threshold" toml:"failure_threshold"`
+	EjectDuration    duration `json:"eject_duration" yaml:"eject_duration" toml:"eject_duration"`
+}
+
+type healthCheckConfig struct {
+	Target   string   `json:"target" yaml:"target" toml:"target"`
+	Interval duration `json:"interval" yaml:"interval" toml:"interval"`
+	Timeout  duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+type ruleConfig struct {
+	Deny         bool     `json:"deny" yaml:"deny" toml:"deny"`
+	Users        []string `json:"users" yaml:"users" toml:"users"`
+	Groups       []string `json:"groups" yaml:"groups" toml:"groups"`
+	Destinations []string `json:"destinations" yaml:"destinations" toml:"destinations"`
+	Ports        []uint16 `json:"ports" yaml:"ports" toml:"ports"`
+	// Countries and ASNs match the destination, ClientCountries and ClientASNs the client
+	Countries       []string `json:"countries" yaml:"countries" toml:"countries"`
+	ASNs            []uint32 `json:"asns" yaml:"asns" toml:"asns"`
+	ClientCountries []string `json:"client_countries" yaml:"client_countries" toml:"client_countries"`
+	ClientASNs      []uint32 `json:"client_asns" yaml:"client_asns" toml:"client_asns"`
+	// Schedule is in the format of socks5.ParseSchedule
+	Schedule string `json:"schedule" yaml:"schedule" toml:"schedule"`
+	CutOff   bool   `json:"cut_off" yaml:"cut_off" toml:"cut_off"`
+}
+
+// duration is a time.Duration written as "5s" in every format
+type duration time.Duration
+
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func defaultConfig() *fileConfig {
+	return &fileConfig{
+		Listen:     []listenConfig{{Address: "localhost:1080"}},
+		Auth:       "none",
+		TCPTimeout: duration(5 * time.Second),
+		LogLevel:   "info",
+	}
+}
+
+// loadConfigFile reads path over the defaults in c, rejecting unknown keys.
+func loadConfigFile(c *fileConfig, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), c)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown key %s", md.Undecoded()[0])
+		}
+	default:
+		return fmt.Errorf("config file %s: unknown format %q, use .yaml, .json or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides c with the SOCKS5_* environment variables that are set.
+func applyEnv(c *fileConfig, getenv func(string) string) error {
+	if v := getenv("SOCKS5_LISTEN"); v != "" {
+		c.Listen = parseListen(strings.Split(v, ","))
+	}
+	if v := getenv("SOCKS5_AUTH"); v != "" {
+		c.Auth = v
+	}
+	if v := getenv("SOCKS5_USERS_FILE"); v != "" {
+		c.UsersFile = v
+	}
+	if v := getenv("SOCKS5_TCP_TIMEOUT"); v != "" {
+		if err := c.TCPTimeout.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("SOCKS5_TCP_TIMEOUT: %w", err)
+		}
+	}
+	if v := getenv("SOCKS5_MAX_CONNECTIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("SOCKS5_MAX_CONNECTIONS: %w", err)
+		}
+		c.MaxConnections = n
+	}
+	if v := getenv("SOCKS5_LOG_LEVEL"); v != "" {
+		c.LogLevel = v
+	}
+	if v := getenv("SOCKS5_ADMIN"); v != "" {
+		c.Admin.Address = v
+	}
+	if v := getenv("SOCKS5_ADMIN_TOKEN"); v != "" {
+		c.Admin.Token = v
+	}
+	return nil
+}
+
+// parseListen turns addresses into listeners, unix:PATH names a Unix socket
+func parseListen(addresses []string) []listenConfig {
+	var listen []listenConfig
+	for _, a := range addresses {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if path, ok := strings.CutPrefix(a, "unix:"); ok {
+			listen = append(listen, listenConfig{Network: "unix", Address: path})
+		} else {
+			listen = append(listen, listenConfig{Address: a})
+		}
+	}
+	return listen
+}
+
+func authMethod(auth string) (socks5.Method, error) {
+	switch auth {
+	case "none":
+		return socks5.MethodNoAuth, nil
+	case "password", "command", "ldap":
+		return socks5.MethodPassword, nil
+	}
+	return 0, fmt.Errorf("unknown auth %q, use none, password, command or ldap", auth)
+}
+
+// server builds the server described by c, reading every file it refers to,
+// so an error here is all --check has to report.
+func (c *fileConfig) server() (*socks5.SOCKS5Server, error) {
+	switch c.LogLevel {
+	case "info", "error":
+	default:
+		return nil, fmt.Errorf("unknown log_level %q, use info or error", c.LogLevel)
+	}
+	if c.SendProxyHeader > 2 {
+		return nil, fmt.Errorf("send_proxy_header %d, use 0, 1 or 2", c.SendProxyHeader)
+	}
+	if len(c.Listen) == 0 {
+		return nil, errors.New("no listen address")
+	}
+
+	base := socks5.Config{
+		TCPTimeout:             time.Duration(c.TCPTimeout),
+		SendProxyHeader:        c.SendProxyHeader,
+		ConnectionAttemptDelay: time.Duration(c.ConnectionAttemptDelay),
+	}
+	switch c.AddressPreference {
+	case "", "interleave":
+		base.AddressPreference = socks5.PreferInterleave
+	case "ipv4":
+		base.AddressPreference = socks5.PreferIPv4
+	case "ipv6":
+		base.AddressPreference = socks5.PreferIPv6
+	default:
+		return nil, fmt.Errorf("unknown address_preference %q, use interleave, ipv4 or ipv6", c.AddressPreference)
+	}
+	if c.SocketOptions != nil {
+		opts, err := c.SocketOptions.options()
+		if err != nil {
+			return nil, err
+		}
+		base.SocketOptions = opts
+	}
+	if c.SourcePool != nil {
+		pool, err := c.SourcePool.pool()
+		if err != nil {
+			return nil, err
+		}
+		base.SourcePool = pool
+	}
+	if len(c.GeoIP) > 0 {
+		geoIP, err := socks5.OpenGeoIP(c.GeoIP...)
+		if err != nil {
+			return nil, err
+		}
+		base.GeoIP = geoIP
+	}
+	for i, r := range c.Rewrites {
+		if r.From == "" || r.To == "" {
+			return nil, fmt.Errorf("rewrite %d: from and to are required", i)
+		}
+		base.Rewrites = append(base.Rewrites, socks5.Rewrite{From: r.From, To: r.To})
+	}
+	if len(c.Routes) > 0 || c.DefaultRoute.Action != "" {
+		router, err := c.router()
+		if err != nil {
+			return nil, err
+		}
+		base.Router = router
+	}
+	if len(c.Rules) > 0 || c.DefaultDeny {
+		rules, err := c.accessRules()
+		if err != nil {
+			return nil, err
+		}
+		base.Rules = rules
+	}
+
+	server := &socks5.SOCKS5Server{MaxConnections: c.MaxConnections}
+	authenticators := make(map[string]socks5.PasswordAuthenticator)
+	for i, l := range c.Listen {
+		auth := l.Auth
+		if auth == "" {
+			auth = c.Auth
+		}
+		method, err := authMethod(auth)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s: %w", l.Address, err)
+		}
+		// listeners with the same auth share one authenticator and its cache
+		if method == socks5.MethodPassword && authenticators[auth] == nil {
+			if authenticators[auth], err = c.authenticator(auth); err != nil {
+				return nil, err
+			}
+		}
+		config := base
+		config.AuthMethod = method
+		config.Authenticator = authenticators[auth]
+
+		listener, err := l.listener(&config)
+		if err != nil {
+			return nil, fmt.Errorf("listen %d %s: %w", i, l.Address, err)
+		}
+		server.Listeners = append(server.Listeners, listener)
+	}
+	return server, nil
+}
+
+func (c *fileConfig) accessRules() (*socks5.AccessRules, error) {
+	rules := &socks5.AccessRules{DefaultDeny: c.DefaultDeny}
+	for i, r := range c.Rules {
+		rule := socks5.AccessRule{
+			Deny:         r.Deny,
+			Users:        r.Users,
+			Groups:       r.Groups,
+			Destinations: r.Destinations,
+			Ports:        r.Ports,
+			CutOff:       r.CutOff,
+
+			Countries:       r.Countries,
+			ASNs:            r.ASNs,
+			ClientCountries: r.ClientCountries,
+			ClientASNs:      r.ClientASNs,
+		}
+		if len(c.GeoIP) == 0 && len(r.Countries)+len(r.ASNs)+len(r.ClientCountries)+len(r.ClientASNs) > 0 {
+			return nil, fmt.Errorf("rule %d: %w", i, errNoGeoIP)
+		}
+		if r.Schedule != "" {
+			schedule, err := socks5.ParseSchedule(r.Schedule)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			rule.Schedule = schedule
+		}
+		rules.Rules = append(rules.Rules, rule)
+	}
+	return rules, nil
+}
+
+var errNoGeoIP = errors.New("countries and asns need a geoip database")
+
+func (c *fileConfig) router() (*socks5.Router, error) {
+	router := &socks5.Router{Upstreams: make(map[string]socks5.ContextDialer)}
+	for name, u := range c.Upstreams {
+		if u.Address == "" {
+			return nil, fmt.Errorf("upstream %s: address is required", name)
+		}
+		router.Upstreams[name] = &socks5.Dialer{
+			ProxyAddress: u.Address,
+			Username:     u.Username,
+			Password:     u.Password,
+			Timeout:      time.Duration(c.TCPTimeout),
+		}
+	}
+	for name, g := range c.UpstreamGroups {
+		if router.Upstreams[name] != nil {
+			return nil, fmt.Errorf("upstream group %s: an upstream has the same name", name)
+		}
+		group, err := g.group(name, router.Upstreams)
+		if err != nil {
+			return nil, fmt.Errorf("upstream group %s: %w", name, err)
+		}
+		router.Upstreams[name] = group
+	}
+	for i, r := range c.Routes {
+		route, err := r.route(router, len(c.GeoIP) > 0)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		router.Routes = append(router.Routes, route)
+	}
+	route, err := c.DefaultRoute.route(router, len(c.GeoIP) > 0)
+	if err != nil {
+		return nil, fmt.Errorf("default route: %w", err)
+	}
+	router.Default = route
+	return router, nil
+}
+
+func (o *socketOptionsConfig) options() (*socks5.SocketOptions, error) {
+	if o.TOS < 0 || o.TOS > 255 {
+		return nil, fmt.Errorf("socket_options: tos %d, use 0 to 255", o.TOS)
+	}
+	if interval := time.Duration(o.KeepAliveInterval); interval != 0 && interval < time.Second {
+		return nil, fmt.Errorf("socket_options: keepalive_interval %s, use at least 1s", interval)
+	}
+	return &socks5.SocketOptions{
+		Mark:              o.Mark,
+		BindToDevice:      o.BindToDevice,
+		TOS:               o.TOS,
+		KeepAlive:         time.Duration(o.KeepAlive),
+		KeepAliveInterval: time.Duration(o.KeepAliveInterval),
+		KeepAliveCount:    o.KeepAliveCount,
+		Nagle:             o.Nagle,
+		UserTimeout:       time.Duration(o.UserTimeout),
+	}, nil
+}
+
+func (p *sourcePoolConfig) pool() (*socks5.SourcePool, error) {
+	pool := &socks5.SourcePool{Addresses: p.Addresses, Users: p.Users}
+	switch p.Strategy {
+	case "", "round-robin":
+		pool.Strategy = socks5.SourceRoundRobin
+	case "random":
+		pool.Strategy = socks5.SourceRandom
+	case "per-user":
+		pool.Strategy = socks5.SourcePerUser
+	case "sticky":
+		pool.Strategy = socks5.SourceSticky
+	default:
+		return nil, fmt.Errorf("source_pool: unknown strategy %q, use round-robin, random, per-user or sticky", p.Strategy)
+	}
+	if len(p.Addresses) == 0 {
+		return nil, errors.New("source_pool: no addresses")
+	}
+	for user, address := range p.Users {
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("source_pool: user %s: invalid address %q", user, address)
+		}
+	}
+	return pool, nil
+}
+
+func (g *upstreamGroupConfig) group(name string, upstreams map[string]socks5.ContextDialer) (*socks5.UpstreamGroup, error) {
+	group := &socks5.UpstreamGroup{
+		Name:                name,
+		HealthCheckTarget:   g.HealthCheck.Target,
+		HealthCheckInterval: time.Duration(g.HealthCheck.Interval),
+		HealthCheckTimeout:  time.Duration(g.HealthCheck.Timeout),
+		FailureThreshold:    g.FailureThreshold,
+		EjectDuration:       time.Duration(g.EjectDuration),
+	}
+	strategies := []socks5.BalanceStrategy{
+		socks5.BalanceRoundRobin, socks5.BalanceRandom, socks5.BalanceLeastConnections,
+		socks5.BalanceLatency, socks5.BalanceHashUser, socks5.BalanceHashDestination,
+	}
+	found := g.Strategy == ""
+	for _, strategy := range strategies {
+		if strategy.String() == g.Strategy {
+			group.Strategy, found = strategy, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown strategy %q", g.Strategy)
+	}
+	if len(g.Upstreams) == 0 {
+		return nil, errors.New("no upstreams")
+	}
+	for _, u := range g.Upstreams {
+		dialer, ok := upstreams[u].(*socks5.Dialer)
+		if !ok {
+			return nil, fmt.Errorf("unknown upstream %q", u)
+		}
+		group.Upstreams = append(group.Upstreams, &socks5.Upstream{Name: u, Dialer: dialer})
+	}
+	return group, nil
+}
+
+func (r *routeConfig) route(router *socks5.Router, geoIP bool) (socks5.Route, error) {
+	route := socks5.Route{
+		Name:         r.Name,
+		Destinations: r.Destinations,
+		Ports:        r.Ports,
+		Users:        r.Users,
+		Groups:       r.Groups,
+		Upstream:     r.Upstream,
+		Interface:    r.Interface,
+
+		Countries:       r.Countries,
+		ASNs:            r.ASNs,
+		ClientCountries: r.ClientCountries,
+		ClientASNs:      r.ClientASNs,
+	}
+	switch r.Action {
+	case "", "direct":
+		route.Action = socks5.RouteDirect
+	case "upstream":
+		route.Action = socks5.RouteUpstream
+		if router.Upstreams[r.Upstream] == nil {
+			return route, fmt.Errorf("unknown upstream %q", r.Upstream)
+		}
+	case "interface":
+		route.Action = socks5.RouteInterface
+		if r.Interface == "" {
+			return route, errors.New("interface action without interface")
+		}
+	case "reject":
+		route.Action = socks5.RouteReject
+	default:
+		return route, fmt.Errorf("unknown action %q, use direct, upstream, interface or reject", r.Action)
+	}
+	if r.SocketOptions != nil {
+		opts, err := r.SocketOptions.options()
+		if err != nil {
+			return route, err
+		}
+		route.SocketOptions = opts
+	}
+	if !geoIP && len(r.Countries)+len(r.ASNs)+len(r.ClientCountries)+len(r.ClientASNs) > 0 {
+		return route, errNoGeoIP
+	}
+	return route, nil
+}
+
+func (c *fileConfig) authenticator(auth string) (socks5.PasswordAuthenticator, error) {
+	switch auth {
+	case "password":
+		users := make(map[string]string, len(c.Users))
+		for name, password := range c.Users {
+			users[name] = password
+		}
+		if c.UsersFile != "" {
+			if err := readUsersFile(c.UsersFile, users); err != nil {
+				return nil, err
+			}
+		}
+		if len(users) == 0 {
+			return nil, errors.New("password auth without users or users_file")
+		}
+		return socks5.PasswordCheckerFunc(func(username, password string) bool {
+			pwd, ok := users[username]
+			return ok && pwd == password
+		}), nil
+	case "command":
+		if c.Command.Path == "" {
+			return nil, errors.New("command auth without command path")
+		}
+		return &socks5.CommandAuthenticator{
+			Path:            c.Command.Path,
+			Args:            c.Command.Args,
+			UseEnv:          c.Command.UseEnv,
+			Timeout:         time.Duration(c.Command.Timeout),
+			MaxConcurrent:   c.Command.MaxConcurrent,
+			CacheTTL:        time.Duration(c.Command.CacheTTL),
+			FailureCacheTTL: time.Duration(c.Command.FailureCacheTTL),
+		}, nil
+	case "ldap":
+		if c.LDAP.URL == "" {
+			return nil, errors.New("ldap auth without ldap url")
+		}
+		a := &socks5.LDAPAuthenticator{
+			URL:             c.LDAP.URL,
+			StartTLS:        c.LDAP.StartTLS,
+			Timeout:         time.Duration(c.LDAP.Timeout),
+			BindDN:          c.LDAP.BindDN,
+			SearchBase:      c.LDAP.SearchBase,
+			SearchFilter:    c.LDAP.SearchFilter,
+			ServiceDN:       c.LDAP.ServiceDN,
+			ServicePassword: c.LDAP.ServicePassword,
+			GroupAttribute:  c.LDAP.GroupAttribute,
+			RequiredGroups:  c.LDAP.RequiredGroups,
+			Attributes:      c.LDAP.Attributes,
+			PoolSize:        c.LDAP.PoolSize,
+			CacheTTL:        time.Duration(c.LDAP.CacheTTL),
+		}
+		if c.LDAP.CAFile != "" {
+			pool, err := readCertPool(c.LDAP.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			a.TLSConfig = &tls.Config{RootCAs: pool}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown auth %q", auth)
+}
+
+// readUsersFile adds the username:password lines of path to users.
+// Empty lines and lines starting with # are skipped.
+func readUsersFile(path string, users map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, password, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return fmt.Errorf("%s:%d: want username:password", path, n)
+		}
+		users[name] = password
+	}
+	return scanner.Err()
+}
+
+func readCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+func (l *listenConfig) listener(config *socks5.Config) (socks5.Listener, error) {
+	listener := socks5.Listener{
+		Label:          l.Label,
+		Network:        l.Network,
+		Address:        l.Address,
+		WebSocketPath:  l.WebSocketPath,
+		TrustedProxies: l.TrustedProxies,
+		Config:         config,
+		UnixSocket: socks5.UnixSocketOptions{
+			User:  l.UnixUser,
+			Group: l.UnixGroup,
+		},
+	}
+	switch l.Network {
+	case "", "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return listener, fmt.Errorf("unknown network %q", l.Network)
+	}
+	if l.UnixMode != "" {
+		mode, err := strconv.ParseUint(l.UnixMode, 8, 32)
+		if err != nil {
+			return listener, fmt.Errorf("unix_mode %q: %w", l.UnixMode, err)
+		}
+		listener.UnixSocket.Mode = os.FileMode(mode)
+	}
+	if l.PeerCredUsers {
+		config.PeerCredUser = socks5.LocalUser
+	}
+
+	if l.TLS != nil {
+		reloader, err := socks5.NewCertificateReloader(l.TLS.Cert, l.TLS.Key)
+		if err != nil {
+			return listener, err
+		}
+		listener.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+		if l.TLS.ClientCA != "" {
+			pool, err := readCertPool(l.TLS.ClientCA)
+			if err != nil {
+				return listener, err
+			}
+			listener.TLSConfig.ClientCAs = pool
+			switch l.TLS.ClientAuth {
+			case "", "require":
+				listener.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			case "optional":
+				listener.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			default:
+				return listener, fmt.Errorf("unknown client_auth %q, use require or optional", l.TLS.ClientAuth)
+			}
+		} else if l.TLS.ClientAuth != "" {
+			return listener, errors.New("client_auth needs a client_ca")
+		}
+		switch l.TLS.CertUser {
+		case "":
+		case "cn":
+			config.CertUser = socks5.CommonNameUser
+		case "email":
+			config.CertUser = socks5.EmailSANUser
+		default:
+			return listener, fmt.Errorf("unknown cert_user %q, use cn or email", l.TLS.CertUser)
+		}
+	}
+	return listener, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+listen:
+  - label: local
+    address: 127.0.0.1:1080
+    auth: none
+  - label: public
+    address: ":1081"
+auth: password
+users:
+  admin: "123456"
+tcp_timeout: 3s
+max_connections: 100
+rules:
+  - deny: true
+    destinations: [10.0.0.0/8]
+    ports: [22]
+    schedule: Mon-Fri 09:00-17:00
+rewrites:
+  - {from: "api.internal:443", to: "10.2.3.4:8443"}
+routes:
+  - {name: office, destinations: [10.0.0.0/8], action: upstream, upstream: office}
+  - {name: web, ports: [443], action: upstream, upstream: egress}
+  - {name: uplink2, users: [ops], socket_options: {mark: 2, tos: 184}}
+default_route: {action: reject}
+upstreams:
+  office: {address: "10.0.0.1:1080"}
+  egress1: {address: "10.0.1.1:1080"}
+  egress2: {address: "10.0.1.2:1080"}
+upstream_groups:
+  egress:
+    upstreams: [egress1, egress2]
+    strategy: hash-user
+    health_check: {target: "example.com:443", interval: 30s}
+process: {user: nobody, pid_file: /run/socks5.pid, max_open_files: 65536}
+`,
+		"config.json": `{
+  "listen": [
+    {"label": "local", "address": "127.0.0.1:1080", "auth": "none"},
+    {"label": "public", "address": ":1081"}
+  ],
+  "auth": "password",
+  "users": {"admin": "123456"},
+  "tcp_timeout": "3s",
+  "max_connections": 100,
+  "rules": [{"deny": true, "destinations": ["10.0.0.0/8"], "ports": [22], "schedule": "Mon-Fri 09:00-17:00"}],
+  "rewrites": [{"from": "api.internal:443", "to": "10.2.3.4:8443"}],
+  "routes": [
+    {"name": "office", "destinations": ["10.0.0.0/8"], "action": "upstream", "upstream": "office"},
+    {"name": "web", "ports": [443], "action": "upstream", "upstream": "egress"},
+    {"name": "uplink2", "users": ["ops"], "socket_options": {"mark": 2, "tos": 184}}
+  ],
+  "default_route": {"action": "reject"},
+  "upstreams": {
+    "office": {"address": "10.0.0.1:1080"},
+    "egress1": {"address": "10.0.1.1:1080"},
+    "egress2": {"address": "10.0.1.2:1080"}
+  },
+  "upstream_groups": {
+    "egress": {"upstreams": ["egress1", "egress2"], "strategy": "hash-user", "health_check": {"target": "example.com:443", "interval": "30s"}}
+  },
+  "process": {"user": "nobody", "pid_file": "/run/socks5.pid", "max_open_files": 65536}
+}`,
+		"config.toml": `
+auth = "password"
+tcp_timeout = "3s"
+max_connections = 100
+
+[users]
+admin = "123456"
+
+[[listen]]
+label = "local"
+address = "127.0.0.1:1080"
+auth = "none"
+
+[[listen]]
+label = "public"
+address = ":1081"
+
+[[rules]]
+deny = true
+destinations = ["10.0.0.0/8"]
+ports = [22]
+schedule = "Mon-Fri 09:00-17:00"
+
+[[rewrites]]
+from = "api.internal:443"
+to = "10.2.3.4:8443"
+
+[[routes]]
+name = "office"
+destinations = ["10.0.0.0/8"]
+action = "upstream"
+upstream = "office"
+
+[[routes]]
+name = "web"
+ports = [443]
+action = "upstream"
+upstream = "egress"
+
+[[routes]]
+name = "uplink2"
+users = ["ops"]
+socket_options = {mark = 2, tos = 184}
+
+[default_route]
+action = "reject"
+
+[upstreams.office]
+address = "10.0.0.1:1080"
+
+[upstreams.egress1]
+address = "10.0.1.1:1080"
+
+[upstreams.egress2]
+address = "10.0.1.2:1080"
+
+[upstream_groups.egress]
+upstreams = ["egress1", "egress2"]
+strategy = "hash-user"
+health_check = {target = "example.com:443", interval = "30s"}
+
+[process]
+user = "nobody"
+pid_file = "/run/socks5.pid"
+max_open_files = 65536
+`,
+	}
+
+	var first *fileConfig
+	for name, content := range files {
+		c := defaultConfig()
+		if err := loadConfigFile(c, writeFile(t, name, content)); err != nil {
+			t.Fatalf("%s: should get error nil but got %s", name, err)
+		}
+		if first == nil {
+			first = c
+		} else if !reflect.DeepEqual(first, c) {
+			t.Fatalf("%s: want %+v but got %+v", name, first, c)
+		}
+
+		server, err := c.server()
+		if err != nil {
+			t.Fatalf("%s: should get error nil but got %s", name, err)
+		}
+		if len(server.Listeners) != 2 || server.MaxConnections != 100 {
+			t.Fatalf("%s: want 2 listeners and 100 connections but got %+v", name, server)
+		}
+		local, public := server.Listeners[0].Config, server.Listeners[1].Config
+		if local.AuthMethod != socks5.MethodNoAuth || public.AuthMethod != socks5.MethodPassword {
+			t.Fatalf("%s: want no auth on local and password on public", name)
+		}
+		if public.TCPTimeout != 3*time.Second {
+			t.Fatalf("%s: want tcp timeout 3s but got %s", name, public.TCPTimeout)
+		}
+		if _, err := public.Authenticator.Authenticate("admin", "123456", nil); err != nil {
+			t.Fatalf("%s: should get error nil but got %s", name, err)
+		}
+		if len(public.Rules.(*socks5.AccessRules).Rules) != 1 {
+			t.Fatalf("%s: want 1 rule", name)
+		}
+		if to, _ := public.Rewrites.Rewrite("api.internal:443"); to != "10.2.3.4:8443" {
+			t.Fatalf("%s: want rewrite to 10.2.3.4:8443 but got %s", name, to)
+		}
+		dst, _ := socks5.ParseAddr("10.1.1.1:443")
+		req := &socks5.Request{Dst: dst}
+		if got := public.Router.Explain(req); got != `route 0 "office": upstream office` {
+			t.Fatalf("%s: want route office but got %s", name, got)
+		}
+		if opts := public.Router.Routes[2].SocketOptions; opts == nil || opts.Mark != 2 || opts.TOS != 184 {
+			t.Fatalf("%s: want mark 2 and tos 184 on route uplink2 but got %+v", name, opts)
+		}
+		group, ok := public.Router.Upstreams["egress"].(*socks5.UpstreamGroup)
+		if !ok || group.Strategy != socks5.BalanceHashUser || len(group.Upstreams) != 2 || group.HealthCheckInterval != 30*time.Second {
+			t.Fatalf("%s: want hash-user group of 2 upstreams checked every 30s but got %+v", name, group)
+		}
+		if c.Process.User != "nobody" || c.Process.PIDFile != "/run/socks5.pid" || c.Process.MaxOpenFiles != 65536 {
+			t.Fatalf("%s: want process settings but got %+v", name, c.Process)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", "auth: none\ntcp_timeout: 3s\nlog_level: info\n")
+	usersFile := writeFile(t, "users", "# comment\nadmin:123456\n")
+	env := map[string]string{
+		"SOCKS5_CONFIG":      path,
+		"SOCKS5_TCP_TIMEOUT": "4s",
+		"SOCKS5_AUTH":        "password",
+		"SOCKS5_LISTEN":      "127.0.0.1:1080,unix:/run/socks5.sock",
+		"SOCKS5_LOG_LEVEL":   "error",
+	}
+	opts, err := parseFlags([]string{"--tcp-timeout", "5s", "--users-file", usersFile})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	c, err := loadConfig(opts, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+
+	want := []listenConfig{{Address: "127.0.0.1:1080"}, {Network: "unix", Address: "/run/socks5.sock"}}
+	if !reflect.DeepEqual(c.Listen, want) {
+		t.Fatalf("want listen %+v but got %+v", want, c.Listen)
+	}
+	// flags win over the environment, which wins over the file
+	if time.Duration(c.TCPTimeout) != 5*time.Second || c.Auth != "password" || c.LogLevel != "error" {
+		t.Fatalf("want timeout 5s, auth password, log level error but got %+v", c)
+	}
+	server, err := c.server()
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if _, err := server.Listeners[0].Config.Authenticator.Authenticate("admin", "123456", nil); err != nil {
+		t.Fatalf("users file: should get error nil but got %s", err)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "unknown key", content: "listen_on: 1080\n", err: "field listen_on not found"},
+		{name: "unknown auth", content: "auth: kerberos\n", err: "unknown auth"},
+		{name: "password without users", content: "auth: password\n", err: "without users"},
+		{name: "bad schedule", content: "rules:\n  - schedule: someday\n", err: "rule 0"},
+		{name: "bad duration", content: "tcp_timeout: soon\n", err: "invalid duration"},
+		{name: "unknown upstream", content: "routes:\n  - action: upstream\n    upstream: nowhere\n", err: "unknown upstream"},
+		{name: "unknown strategy", content: "upstreams:\n  a: {address: \"10.0.0.1:1080\"}\nupstream_groups:\n  g: {upstreams: [a], strategy: fastest}\nroutes:\n  - {action: upstream, upstream: g}\n", err: "unknown strategy"},
+		{name: "group of unknown upstream", content: "upstream_groups:\n  g: {upstreams: [b]}\nroutes:\n  - {action: upstream, upstream: g}\n", err: "unknown upstream \"b\""},
+		{name: "unknown address preference", content: "address_preference: ipv5\n", err: "unknown address_preference"},
+		{name: "pinned source not an address", content: "source_pool:\n  addresses: [eth0]\n  strategy: per-user\n  users: {alice: eth1}\n", err: "invalid address"},
+		{name: "tos out of range", content: "socket_options: {tos: 256}\n", err: "tos 256"},
+		{name: "sub-second keepalive interval", content: "socket_options: {keepalive_interval: 500ms}\n", err: "keepalive_interval 500ms"},
+		{name: "unknown route action", content: "default_route: {action: tunnel}\n", err: "unknown action"},
+		{name: "countries without geoip", content: "rules:\n  - deny: true\n    countries: [KP]\n", err: "need a geoip database"},
+		{name: "missing geoip database", content: "geoip: [/nonexistent.mmdb]\n", err: "nonexistent"},
+		{name: "missing certificate", content: "listen:\n  - address: :1080\n    tls: {cert: /nonexistent.pem, key: /nonexistent.key}\n", err: "nonexistent"},
+	}
+	for _, test := range tests {
+		c := defaultConfig()
+		err := loadConfigFile(c, writeFile(t, "config.yaml", test.content))
+		if err == nil {
+			_, err = c.server()
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("%s: want error containing %q but got %v", test.name, test.err, err)
+		}
+	}
+}
+
+// writeCertificate writes a self-signed certificate and its key as PEM files
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "socks5 test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := writeFile(t, "cert.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	return cert, writeFile(t, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	cert, key := writeCertificate(t)
+	tests := []struct {
+		clientCA   string
+		clientAuth string
+		want       tls.ClientAuthType
+		err        string
+	}{
+		{"", "", tls.NoClientCert, ""},
+		{cert, "", tls.RequireAndVerifyClientCert, ""},
+		{cert, "require", tls.RequireAndVerifyClientCert, ""},
+		{cert, "optional", tls.VerifyClientCertIfGiven, ""},
+		{cert, "sometimes", 0, "unknown client_auth"},
+		{"", "require", 0, "needs a client_ca"},
+	}
+	for _, test := range tests {
+		l := &listenConfig{Address: "127.0.0.1:0", TLS: &tlsConfig{Cert: cert, Key: key, ClientCA: test.clientCA, ClientAuth: test.clientAuth}}
+		listener, err := l.listener(&socks5.Config{})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("%q: want error containing %q but got %v", test.clientAuth, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: should get error nil but got %s", test.clientAuth, err)
+		}
+		if got := listener.TLSConfig.ClientAuth; got != test.want {
+			t.Fatalf("%q: want client auth %s but got %s", test.clientAuth, test.want, got)
+		}
+	}
+}
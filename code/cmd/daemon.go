@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processConfig sets up the server process at start, it is not reloaded.
+// Files read on reload, such as the users file, must be reachable for the
+// user switched to and, with chroot, be inside the new root.
+type processConfig struct {
+	// User and Group, names or ids, are switched to once the listeners are open,
+	// so privileged ports can be bound as root. Group defaults to the user's.
+	User  string `json:"user" yaml:"user" toml:"user"`
+	Group string `json:"group" yaml:"group" toml:"group"`
+	// PIDFile is written at start and removed at exit. With user its directory
+	// must be owned by the user, who could not remove the file otherwise.
+	PIDFile string `json:"pid_file" yaml:"pid_file" toml:"pid_file"`
+	// MaxOpenFiles raises the RLIMIT_NOFILE of the process
+	MaxOpenFiles uint64 `json:"max_open_files" yaml:"max_open_files" toml:"max_open_files"`
+	// Chroot confines the process to a directory once the listeners are open.
+	// The binary is then out of reach, upgrades on SIGUSR2 are refused.
+	Chroot string `json:"chroot" yaml:"chroot" toml:"chroot"`
+
+	// pidDir reaches the directory of the PID file from inside the chroot
+	pidDir *os.File
+}
+
+// setUp applies the process settings around opening the listeners with listen:
+// the file limit and PID file come first, chroot and switching user last.
+func (p *processConfig) setUp(listen func() error) error {
+	if p.MaxOpenFiles > 0 {
+		if err := setMaxOpenFiles(p.MaxOpenFiles); err != nil {
+			return fmt.Errorf("max_open_files %d: %w", p.MaxOpenFiles, err)
+		}
+	}
+	// users and groups are looked up before /etc is out of reach
+	var creds *credentials
+	if p.User != "" || p.Group != "" {
+		var err error
+		if creds, err = lookupCredentials(p.User, p.Group); err != nil {
+			return err
+		}
+	}
+	if err := listen(); err != nil {
+		return err
+	}
+	if p.PIDFile != "" {
+		if err := p.writePIDFile(creds); err != nil {
+			p.tearDown()
+			return err
+		}
+	}
+	if err := p.confine(creds); err != nil {
+		p.tearDown()
+		return err
+	}
+	return nil
+}
+
+// confine enters the chroot and switches user
+func (p *processConfig) confine(creds *credentials) error {
+	if p.Chroot != "" {
+		if err := enterChroot(p.Chroot); err != nil {
+			return fmt.Errorf("chroot %s: %w", p.Chroot, err)
+		}
+	}
+	if creds != nil {
+		if err := creds.drop(); err != nil {
+			return fmt.Errorf("switching to user %q group %q: %w", p.User, p.Group, err)
+		}
+	}
+	return nil
+}
+
+// writePIDFile writes the PID file where the process can still remove it
+// once it switched to creds and entered the chroot
+func (p *processConfig) writePIDFile(creds *credentials) error {
+	dir := filepath.Dir(p.PIDFile)
+	if creds != nil {
+		if err := creds.owns(dir); err != nil {
+			return fmt.Errorf("pid_file %s: %w", p.PIDFile, err)
+		}
+	}
+	if err := os.WriteFile(p.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return err
+	}
+	// so the PID file can still be replaced by an upgrade
+	if creds != nil {
+		if err := creds.chown(p.PIDFile); err != nil {
+			return err
+		}
+	}
+	if p.Chroot != "" {
+		d, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		p.pidDir = d
+	}
+	return nil
+}
+
+// upgrade hands the listeners over to a new process started from the binary,
+// which a chrooted process no longer reaches
+func (p *processConfig) upgrade(listeners []namedListener) error {
+	if p.Chroot != "" {
+		return errors.New("not possible with chroot, the binary is outside the new root")
+	}
+	return handOver(listeners)
+}
+
+// tearDown removes the PID file at exit, after chroot from within its directory
+func (p *processConfig) tearDown() {
+	if p.PIDFile == "" {
+		return
+	}
+	if p.pidDir == nil {
+		removePIDFile(p.PIDFile)
+		return
+	}
+	defer p.pidDir.Close()
+	if cwd, err := os.Open("."); err == nil {
+		defer cwd.Close()
+		defer cwd.Chdir()
+	}
+	if err := p.pidDir.Chdir(); err != nil {
+		removePIDFile(p.PIDFile)
+		return
+	}
+	removePIDFile(filepath.Base(p.PIDFile))
+}
+
+// removePIDFile removes the PID file unless the process upgraded to wrote its own
+func removePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("removing pid file: %s", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socks5.pid")
+	p := &processConfig{PIDFile: path}
+	if err := p.setUp(func() error { return nil }); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Fatalf("want our pid in the pid file but got %q, %v", data, err)
+	}
+	p.tearDown()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("want the pid file removed but got %v", err)
+	}
+
+	// the process upgraded to wrote its own
+	if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p.tearDown()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("want the pid file of another process kept but got %v", err)
+	}
+}
+
+func TestProcessListenError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socks5.pid")
+	p := &processConfig{PIDFile: path}
+	if err := p.setUp(func() error { return os.ErrPermission }); err != os.ErrPermission {
+		t.Fatalf("want error %s but got %v", os.ErrPermission, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("want no pid file when the listeners failed")
+	}
+}
+
+func TestProcessSetUpError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socks5.pid")
+	p := &processConfig{PIDFile: path, Chroot: filepath.Join(t.TempDir(), "missing")}
+	if err := p.setUp(func() error { return nil }); err == nil {
+		t.Fatal("want an error entering a missing chroot")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("want the pid file removed after the error but got %v", err)
+	}
+}
+
+func TestUpgradeWithChroot(t *testing.T) {
+	p := &processConfig{Chroot: t.TempDir()}
+	if err := p.upgrade(nil); err == nil || !strings.Contains(err.Error(), "chroot") {
+		t.Fatalf("want the upgrade refused with chroot but got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// credentials are the ids the process switches to
+type credentials struct {
+	uid, gid int
+	groups   []int
+}
+
+// lookupCredentials resolves user and group, names or ids. With a user and no group
+// the process takes the user's primary and supplementary groups.
+func lookupCredentials(userName, groupName string) (*credentials, error) {
+	c := &credentials{uid: os.Getuid(), gid: os.Getgid()}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return nil, fmt.Errorf("user %s: %w", userName, err)
+			}
+		}
+		c.uid, _ = strconv.Atoi(u.Uid)
+		c.gid, _ = strconv.Atoi(u.Gid)
+		if groupName == "" {
+			ids, _ := u.GroupIds()
+			for _, id := range ids {
+				if gid, err := strconv.Atoi(id); err == nil {
+					c.groups = append(c.groups, gid)
+				}
+			}
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("group %s: %w", groupName, err)
+			}
+		}
+		c.gid, _ = strconv.Atoi(g.Gid)
+	}
+	if len(c.groups) == 0 {
+		c.groups = []int{c.gid}
+	}
+	return c, nil
+}
+
+// drop switches the process to the credentials, the group first while still allowed.
+// A process upgraded to already runs as them.
+func (c *credentials) drop() error {
+	if os.Getuid() == c.uid && os.Getgid() == c.gid {
+		return nil
+	}
+	if err := syscall.Setgroups(c.groups); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(c.gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(c.uid)
+}
+
+// chown gives path to the credentials, unless the process already runs as them
+func (c *credentials) chown(path string) error {
+	if os.Getuid() == c.uid && os.Getgid() == c.gid {
+		return nil
+	}
+	return os.Chown(path, c.uid, c.gid)
+}
+
+// owns checks that dir is owned by the credentials, unless the process already runs as them
+func (c *credentials) owns(dir string) error {
+	if os.Getuid() == c.uid {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != c.uid {
+		return fmt.Errorf("directory %s is not owned by uid %d", dir, c.uid)
+	}
+	return nil
+}
+
+// enterChroot confines the process to dir, paths opened later are inside it
+func enterChroot(dir string) error {
+	if err := syscall.Chroot(dir); err != nil {
+		return err
+	}
+	return os.Chdir("/")
+}
+
+// setMaxOpenFiles sets the soft limit of open files, raising the hard limit if needed
+func setMaxOpenFiles(n uint64) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return err
+	}
+	raiseLimit(&limit.Cur, &limit.Max, n)
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+}
+
+// raiseLimit sets cur to n and max to at least n, Rlimit fields are int64 on some BSDs
+func raiseLimit[T ~int64 | ~uint64](cur, max *T, n uint64) {
+	*cur = T(n)
+	if *max < T(n) {
+		*max = T(n)
+	}
+}
@@ -0,0 +1,118 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLookupCredentials(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, name := range []string{u.Username, u.Uid} {
+		c, err := lookupCredentials(name, "")
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		if strconv.Itoa(c.uid) != u.Uid || strconv.Itoa(c.gid) != u.Gid || len(c.groups) == 0 {
+			t.Fatalf("want uid %s gid %s but got %+v", u.Uid, u.Gid, c)
+		}
+		// already running as them
+		if err := c.drop(); err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+	}
+	c, err := lookupCredentials("", strconv.Itoa(os.Getgid()))
+	if err != nil || c.uid != os.Getuid() || c.gid != os.Getgid() || len(c.groups) != 1 {
+		t.Fatalf("want only the group changed but got %+v, %v", c, err)
+	}
+	if _, err := lookupCredentials("no-such-user-socks5", ""); err == nil {
+		t.Fatal("want an error for an unknown user")
+	}
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+	want := uint64(limit.Cur) - 1
+	p := &processConfig{MaxOpenFiles: want}
+	if err := p.setUp(func() error { return nil }); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	var got syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &got)
+	if uint64(got.Cur) != want {
+		t.Fatalf("want open files limit %d but got %d", want, got.Cur)
+	}
+}
+
+// TestPIDFileConfined switches user and enters a chroot in a child process,
+// which the test process could not undo
+func TestPIDFileConfined(t *testing.T) {
+	if path := os.Getenv("SOCKS5_TEST_PID_FILE"); path != "" {
+		p := &processConfig{User: "nobody", PIDFile: path, Chroot: os.Getenv("SOCKS5_TEST_CHROOT")}
+		if err := p.setUp(func() error { return nil }); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if os.Getuid() == 0 {
+			fmt.Fprintln(os.Stderr, "still root")
+			os.Exit(1)
+		}
+		p.tearDown()
+		os.Exit(0)
+	}
+	if os.Getuid() != 0 {
+		t.Skip("needs root to switch user and chroot")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	uid, _ := strconv.Atoi(nobody.Uid)
+
+	// reachable for nobody, unlike the parents of t.TempDir
+	dir, err := os.MkdirTemp("", "socks5-pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "socks5.pid")
+
+	// a directory nobody can't write in is refused
+	p := &processConfig{User: "nobody", PIDFile: path}
+	if err := p.setUp(func() error { return nil }); err == nil || !strings.Contains(err.Error(), "not owned") {
+		t.Fatalf("want the pid file directory refused but got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("want no pid file but got %v", err)
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(dir, uid, -1); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPIDFileConfined$")
+	cmd.Env = append(os.Environ(), "SOCKS5_TEST_PID_FILE="+path, "SOCKS5_TEST_CHROOT="+t.TempDir())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("should get error nil but got %s: %s", err, out)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("want the pid file removed after chroot and switching user but got %v", err)
+	}
+}
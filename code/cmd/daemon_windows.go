@@ -0,0 +1,31 @@
+package main
+
+import "errors"
+
+var errNotOnWindows = errors.New("not supported on windows")
+
+type credentials struct{}
+
+func lookupCredentials(userName, groupName string) (*credentials, error) {
+	return nil, errNotOnWindows
+}
+
+func (c *credentials) drop() error {
+	return errNotOnWindows
+}
+
+func (c *credentials) chown(path string) error {
+	return errNotOnWindows
+}
+
+func (c *credentials) owns(dir string) error {
+	return errNotOnWindows
+}
+
+func enterChroot(dir string) error {
+	return errNotOnWindows
+}
+
+func setMaxOpenFiles(n uint64) error {
+	return errNotOnWindows
+}
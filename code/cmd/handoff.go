@@ -0,0 +1,170 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listening sockets are passed on as file descriptors from 3 on, named in
+// LISTEN_FDS and LISTEN_FDNAMES the way systemd socket activation does,
+// so the server takes them from systemd and from an older process alike.
+const listenFDsStart = 3
+
+// readyFDEnv names the descriptor a new process writes to once it serves the listeners
+const readyFDEnv = "SOCKS5_READY_FD"
+
+// the new process has this long to get ready before the upgrade is given up
+const upgradeReadyTimeout = 30 * time.Second
+
+// listenFDs reads the number and names of the inherited listening sockets.
+// Sockets meant for another process, with LISTEN_PID not ours, are ignored.
+func listenFDs(getenv func(string) string, pid int) (int, []string, error) {
+	count := getenv("LISTEN_FDS")
+	if count == "" {
+		return 0, nil, nil
+	}
+	if p := getenv("LISTEN_PID"); p != "" && p != strconv.Itoa(pid) {
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf("LISTEN_FDS %q is not a number of descriptors", count)
+	}
+	names := make([]string, n)
+	if v := getenv("LISTEN_FDNAMES"); v != "" {
+		copy(names, strings.Split(v, ":"))
+	}
+	return n, names, nil
+}
+
+// inheritedListeners returns the listeners passed on by systemd or the process upgraded from
+func inheritedListeners() ([]namedListener, error) {
+	n, names, err := listenFDs(os.Getenv, os.Getpid())
+	// the variables are not for the commands the server runs
+	for _, v := range []string{"LISTEN_FDS", "LISTEN_FDNAMES", "LISTEN_PID"} {
+		os.Unsetenv(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inherited := make([]namedListener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(listenFDsStart+i), names[i])
+		listener, err := net.FileListener(f)
+		// the listener has its own copy of the descriptor
+		f.Close()
+		if err != nil {
+			for _, l := range inherited {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited descriptor %d: %w", listenFDsStart+i, err)
+		}
+		inherited = append(inherited, namedListener{name: names[i], Listener: listener})
+	}
+	return inherited, nil
+}
+
+// notifyReady tells the process upgraded from that this one serves, so it can drain
+func notifyReady() {
+	v := os.Getenv(readyFDEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("%s %q is not a descriptor", readyFDEnv, v)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// handOver starts the binary again with the listeners and returns once it serves them.
+// Unix sockets are then no longer removed when this process closes them.
+func handOver(listeners []namedListener) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		fl, ok := l.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s cannot be handed over", l.name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", l.name, err)
+		}
+		files = append(files, f)
+		names = append(names, l.name)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	env := make([]string, 0, len(os.Environ())+3)
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "LISTEN_") && !strings.HasPrefix(v, readyFDEnv+"=") {
+			env = append(env, v)
+		}
+	}
+	env = append(env,
+		fmt.Sprintf("LISTEN_FDS=%d", len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		fmt.Sprintf("%s=%d", readyFDEnv, listenFDsStart+len(files)))
+	attr := &os.ProcAttr{
+		Env:   env,
+		Files: append(append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...), readyW),
+	}
+	proc, err := os.StartProcess(exe, os.Args, attr)
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("upgrade: started %s as pid %d", exe, proc.Pid)
+
+	// the new process writes a byte when ready, EOF without it means it exited
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			go proc.Wait()
+			return errors.New("new process exited before serving")
+		}
+	case <-time.After(upgradeReadyTimeout):
+		proc.Kill()
+		go proc.Wait()
+		return errors.New("new process not ready in time")
+	}
+	proc.Release()
+
+	for _, l := range listeners {
+		if ul, ok := l.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListenFDs(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	tests := []struct {
+		name  string
+		vars  map[string]string
+		n     int
+		names []string
+		err   bool
+	}{
+		{"none", map[string]string{}, 0, nil, false},
+		{"systemd", map[string]string{"LISTEN_FDS": "2", "LISTEN_PID": "42", "LISTEN_FDNAMES": "public:admin"}, 2, []string{"public", "admin"}, false},
+		{"other process", map[string]string{"LISTEN_FDS": "2", "LISTEN_PID": "7"}, 0, nil, false},
+		{"upgrade without pid", map[string]string{"LISTEN_FDS": "1"}, 1, []string{""}, false},
+		{"fewer names", map[string]string{"LISTEN_FDS": "2", "LISTEN_FDNAMES": "public"}, 2, []string{"public", ""}, false},
+		{"bad count", map[string]string{"LISTEN_FDS": "two"}, 0, nil, true},
+	}
+	for _, test := range tests {
+		n, names, err := listenFDs(env(test.vars), 42)
+		if (err != nil) != test.err {
+			t.Fatalf("%s: want error %v but got %v", test.name, test.err, err)
+		}
+		if n != test.n || !reflect.DeepEqual(names, test.names) {
+			t.Fatalf("%s: want %d %q but got %d %q", test.name, test.n, test.names, n, names)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "errors"
+
+// listening sockets cannot be passed on to another process on Windows
+func inheritedListeners() ([]namedListener, error) {
+	return nil, nil
+}
+
+func notifyReady() {}
+
+func handOver(listeners []namedListener) error {
+	return errors.New("upgrade is not supported on windows")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+// watchSignals handles the process signals until the server is shut down, then closes done.
+// SIGTERM drains the sessions for up to drainTimeout, SIGUSR2 hands the listeners over
+// to a new process with upgrade first, SIGINT kills the sessions, also of a drain going on,
+// and SIGUSR1 logs the stats. The admin listener, if any, is closed once handed over.
+func watchSignals(server *socks5.SOCKS5Server, upgrade func() error, admin net.Listener, drainTimeout time.Duration, done chan<- struct{}) {
+	notify := func(signals []os.Signal) <-chan os.Signal {
+		c := make(chan os.Signal, 1)
+		if len(signals) > 0 {
+			signal.Notify(c, signals...)
+		}
+		return c
+	}
+	stop, quit, stats, upgradeSignal := notify(stopSignals), notify(quitSignals), notify(statsSignals), notify(upgradeSignals)
+
+	ctx, kill := context.WithCancel(context.Background())
+	defer kill()
+	drained := make(chan struct{})
+	draining := false
+	drain := func() {
+		draining = true
+		go func() {
+			ctx := ctx
+			if drainTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, drainTimeout)
+				defer cancel()
+			}
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("shutdown: %s", err)
+			}
+			close(drained)
+		}()
+	}
+
+	for {
+		select {
+		case <-stats:
+			logStats(server)
+		case <-upgradeSignal:
+			if draining || upgrade == nil {
+				continue
+			}
+			log.Printf("received signal, upgrading")
+			if err := upgrade(); err != nil {
+				// reported whatever the log level
+				log.New(os.Stderr, "", log.LstdFlags).Printf("upgrade failed, keeping on serving: %s", err)
+				continue
+			}
+			if admin != nil {
+				admin.Close()
+			}
+			log.Printf("upgrade: draining sessions")
+			drain()
+		case <-stop:
+			if !draining {
+				log.Printf("received signal, draining sessions")
+				drain()
+			}
+		case <-quit:
+			log.Printf("received signal, stopping")
+			kill()
+			if !draining {
+				drain()
+			}
+		case <-drained:
+			close(done)
+			return
+		}
+	}
+}
+
+// logStats writes the connection counts of the server and its listeners,
+// whatever the log level as they were asked for
+func logStats(server *socks5.SOCKS5Server) {
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	total := server.Stats()
+	l.Printf("stats: accepted=%d active=%d refused=%d panics=%d sessions=%d",
+		total.Accepted, total.Active, total.Refused, total.Panics, len(server.Sessions()))
+	byListener := server.StatsByListener()
+	labels := make([]string, 0, len(byListener))
+	for label := range byListener {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		st := byListener[label]
+		l.Printf("stats %s: accepted=%d active=%d refused=%d panics=%d", label, st.Accepted, st.Active, st.Refused, st.Panics)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+func TestStopSignal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &socks5.SOCKS5Server{Listeners: []socks5.Listener{{
+		Address:   ln.Addr().String(),
+		Inherited: ln,
+		Config:    &socks5.Config{AuthMethod: socks5.MethodNoAuth},
+	}}}
+	done := make(chan struct{})
+	go watchSignals(server, nil, nil, time.Second, done)
+	ran := make(chan error, 1)
+	go func() { ran <- server.Run() }()
+	time.Sleep(100 * time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-ran:
+		if err != socks5.ErrServerClosed {
+			t.Fatalf("want error %s but got %v", socks5.ErrServerClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server still running after SIGTERM")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown not done after SIGTERM")
+	}
+}
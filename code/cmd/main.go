@@ -11,40 +11,197 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"sync"
+	"net"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/yongfrank/go-socks5"
 )
 
+// listFlag collects a flag given several times or as a comma separated list
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	*f = append(*f, strings.Split(v, ",")...)
+	return nil
+}
+
+// options are the command line flags
+type options struct {
+	configFile     string
+	listen         listFlag
+	auth           string
+	usersFile      string
+	tcpTimeout     time.Duration
+	maxConnections int
+	logLevel       string
+	admin          string
+	check          bool
+	drainTimeout   time.Duration
+
+	set map[string]bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	opts := &options{set: make(map[string]bool)}
+	fs := flag.NewFlagSet("socks5", flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "", "config `file`, .yaml, .json or .toml (env SOCKS5_CONFIG)")
+	fs.Var(&opts.listen, "listen", "listen `address`, unix:PATH for a Unix socket, repeatable (env SOCKS5_LISTEN)")
+	fs.StringVar(&opts.auth, "auth", "", "auth `mode`: none, password, command or ldap (env SOCKS5_AUTH)")
+	fs.StringVar(&opts.usersFile, "users-file", "", "`file` of username:password lines for password auth (env SOCKS5_USERS_FILE)")
+	fs.DurationVar(&opts.tcpTimeout, "tcp-timeout", 0, "timeout connecting to targets (env SOCKS5_TCP_TIMEOUT)")
+	fs.IntVar(&opts.maxConnections, "max-connections", 0, "connections open at once, 0 for no limit (env SOCKS5_MAX_CONNECTIONS)")
+	fs.StringVar(&opts.logLevel, "log-level", "", "`level` info or error (env SOCKS5_LOG_LEVEL)")
+	fs.StringVar(&opts.admin, "admin", "", "admin API listen `address` (env SOCKS5_ADMIN, token in SOCKS5_ADMIN_TOKEN)")
+	fs.BoolVar(&opts.check, "check", false, "validate the configuration and exit")
+	fs.DurationVar(&opts.drainTimeout, "drain-timeout", 0, "time sessions get to end on SIGTERM and after an upgrade on SIGUSR2, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	fs.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+	return opts, nil
+}
+
+// loadConfig merges defaults, config file, environment and flags, later ones winning.
+func loadConfig(opts *options, getenv func(string) string) (*fileConfig, error) {
+	c := defaultConfig()
+	path := opts.configFile
+	if path == "" {
+		path = getenv("SOCKS5_CONFIG")
+	}
+	if path != "" {
+		if err := loadConfigFile(c, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(c, getenv); err != nil {
+		return nil, err
+	}
+
+	if opts.set["listen"] {
+		c.Listen = parseListen(opts.listen)
+	}
+	if opts.set["auth"] {
+		c.Auth = opts.auth
+	}
+	if opts.set["users-file"] {
+		c.UsersFile = opts.usersFile
+	}
+	if opts.set["tcp-timeout"] {
+		c.TCPTimeout = duration(opts.tcpTimeout)
+	}
+	if opts.set["max-connections"] {
+		c.MaxConnections = opts.maxConnections
+	}
+	if opts.set["log-level"] {
+		c.LogLevel = opts.logLevel
+	}
+	if opts.set["admin"] {
+		c.Admin.Address = opts.admin
+	}
+	return c, nil
+}
+
 func main() {
-	users := map[string]string{
-		"admin":    "123456",
-		"zhangsan": "1234",
-		"lisi":     "abde",
-	}
-
-	var mutex sync.Mutex
-
-	server := socks5.SOCKS5Server{
-		IP:   "localhost",
-		Port: 1080,
-		Config: &socks5.Config{
-			AuthMethod: socks5.MethodPassword,
-			PasswordChecker: func(username, password string) bool {
-				mutex.Lock()
-				defer mutex.Unlock()
-				if pwd, findInDict := users[username]; findInDict {
-					return pwd == password
-				}
-				return false
-			},
-			TCPTimeout: 5 * time.Second,
-		},
-	}
-
-	if err := server.Run(); err != nil {
-		log.Fatal(err)
+	// errors of the binary itself are reported whatever the log level
+	errLog := log.New(os.Stderr, "", log.LstdFlags)
+
+	opts, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		errLog.Print(err)
+		os.Exit(2)
+	}
+	if err := run(opts); err != nil {
+		errLog.Fatal(err)
+	}
+}
+
+// run serves until the server is shut down, its deferred clean up done before main exits
+func run(opts *options) error {
+	config, err := loadConfig(opts, os.Getenv)
+	if err != nil {
+		return err
+	}
+	server, err := config.server()
+	if err != nil {
+		return err
+	}
+	if opts.check {
+		fmt.Println("configuration ok")
+		return nil
+	}
+
+	setLogLevel(config.LogLevel)
+	startUpstreamGroups(server)
+	reloader := newReloader(opts, os.Getenv, config, server)
+
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	inherited, adminListener := takeListener(inherited, adminListenerName)
+	var listeners []namedListener
+	err = config.Process.setUp(func() error {
+		var err error
+		if listeners, err = openListeners(server, inherited); err != nil {
+			return err
+		}
+		if config.Admin.Address == "" {
+			if adminListener != nil {
+				adminListener.Close()
+				adminListener = nil
+			}
+			return nil
+		}
+		if adminListener == nil {
+			if adminListener, err = net.Listen("tcp", config.Admin.Address); err != nil {
+				return err
+			}
+		}
+		listeners = append(listeners, namedListener{adminListenerName, adminListener})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer config.Process.tearDown()
+
+	errs := make(chan error, 2)
+	go reloader.watch()
+	if adminListener != nil {
+		admin := socks5.NewAdminHandler(server, config.Admin.Token, reloader.reload)
+		go func() {
+			// closed once handed over to a new process
+			if err := http.Serve(adminListener, admin); !errors.Is(err, net.ErrClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	drained := make(chan struct{})
+	upgrade := func() error { return config.Process.upgrade(listeners) }
+	go watchSignals(server, upgrade, adminListener, opts.drainTimeout, drained)
+	notifyReady()
+	go func() { errs <- server.Run() }()
+	if err := <-errs; err != socks5.ErrServerClosed {
+		return err
 	}
+	<-drained
+	return nil
 }
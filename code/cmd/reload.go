@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+// config files are checked for changes this often
+const configCheckInterval = 2 * time.Second
+
+// reloader applies the configuration again to a running server,
+// on SIGHUP or when one of its files changes.
+type reloader struct {
+	opts   *options
+	getenv func(string) string
+	server *socks5.SOCKS5Server
+
+	mu sync.Mutex
+	// current is the last configuration applied, whose authenticators are closed when replaced
+	current *socks5.SOCKS5Server
+	// modTimes of the files the configuration was read from
+	modTimes map[string]time.Time
+}
+
+func newReloader(opts *options, getenv func(string) string, config *fileConfig, server *socks5.SOCKS5Server) *reloader {
+	return &reloader{
+		opts:     opts,
+		getenv:   getenv,
+		server:   server,
+		current:  server,
+		modTimes: modTimes(config.files(opts, getenv)),
+	}
+}
+
+// reload reads the configuration again and applies it to new connections.
+// On error the running configuration is kept.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config, err := loadConfig(r.opts, r.getenv)
+	if err != nil {
+		return err
+	}
+	// watch the files of the new configuration even if it is broken,
+	// so fixing it triggers the next reload
+	r.modTimes = modTimes(config.files(r.opts, r.getenv))
+	next, err := config.server()
+	if err != nil {
+		return err
+	}
+	if err := r.server.Reload(next); err != nil {
+		return err
+	}
+	setLogLevel(config.LogLevel)
+	closeAuthenticators(r.current)
+	closeUpstreamGroups(r.current)
+	startUpstreamGroups(next)
+	r.current = next
+	return nil
+}
+
+// changed reports whether a watched file was modified, created or removed
+func (r *reloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for path, t := range r.modTimes {
+		info, err := os.Stat(path)
+		if err != nil {
+			if !t.IsZero() {
+				return true
+			}
+			continue
+		}
+		if !info.ModTime().Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads on SIGHUP and file changes until the process exits
+func (r *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(hup, reloadSignals...)
+	}
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			log.Printf("received signal, reloading configuration")
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			log.Printf("configuration files changed, reloading")
+		}
+		if err := r.reload(); err != nil {
+			// reported whatever the log level
+			log.New(os.Stderr, "", log.LstdFlags).Printf("reload failed, keeping the running configuration: %s", err)
+		}
+	}
+}
+
+// files returns the files the configuration is read from
+func (c *fileConfig) files(opts *options, getenv func(string) string) []string {
+	var files []string
+	if opts.configFile != "" {
+		files = append(files, opts.configFile)
+	} else if path := getenv("SOCKS5_CONFIG"); path != "" {
+		files = append(files, path)
+	}
+	if c.UsersFile != "" {
+		files = append(files, c.UsersFile)
+	}
+	if c.LDAP.CAFile != "" {
+		files = append(files, c.LDAP.CAFile)
+	}
+	return files
+}
+
+// modTimes returns the modification time of each file, zero if it does not exist
+func modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, path := range files {
+		var t time.Time
+		if info, err := os.Stat(path); err == nil {
+			t = info.ModTime()
+		}
+		times[path] = t
+	}
+	return times
+}
+
+func setLogLevel(level string) {
+	if level == "error" {
+		log.SetOutput(io.Discard)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// closeAuthenticators releases the connection pools of a replaced configuration
+func closeAuthenticators(server *socks5.SOCKS5Server) {
+	for _, l := range server.Listeners {
+		if l.Config == nil {
+			continue
+		}
+		if c, ok := l.Config.Authenticator.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// startUpstreamGroups starts the health checks of a configuration's upstream groups
+func startUpstreamGroups(server *socks5.SOCKS5Server) {
+	for _, g := range upstreamGroups(server) {
+		g.Start()
+	}
+}
+
+// closeUpstreamGroups stops the health checks of a replaced configuration
+func closeUpstreamGroups(server *socks5.SOCKS5Server) {
+	for _, g := range upstreamGroups(server) {
+		g.Close()
+	}
+}
+
+func upstreamGroups(server *socks5.SOCKS5Server) []*socks5.UpstreamGroup {
+	var groups []*socks5.UpstreamGroup
+	for _, l := range server.Listeners {
+		if l.Config == nil || l.Config.Router == nil {
+			continue
+		}
+		for _, u := range l.Config.Router.Upstreams {
+			if g, ok := u.(*socks5.UpstreamGroup); ok {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+func TestReloader(t *testing.T) {
+	usersFile := writeFile(t, "users", "admin:123456\n")
+	path := writeFile(t, "config.yaml", "listen: [{label: public, address: \"127.0.0.1:1080\"}]\nauth: password\nusers_file: "+usersFile+"\n")
+	opts := &options{configFile: path, set: map[string]bool{}}
+	getenv := func(string) string { return "" }
+
+	config, err := loadConfig(opts, getenv)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	server, err := config.server()
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	r := newReloader(opts, getenv, config, server)
+	if r.changed() {
+		t.Fatal("should not see changes before files are written")
+	}
+
+	authenticate := func(username, password string) error {
+		_, err := r.current.Listeners[0].Config.Authenticator.Authenticate(username, password, nil)
+		return err
+	}
+
+	// a user is added
+	if err := os.WriteFile(usersFile, []byte("admin:123456\nbob:secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(usersFile, time.Now(), time.Now().Add(time.Second))
+	if !r.changed() {
+		t.Fatal("should see the users file change")
+	}
+	if err := r.reload(); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if err := authenticate("bob", "secret"); err != nil {
+		t.Fatalf("added user: should get error nil but got %s", err)
+	}
+
+	// a broken file keeps the running configuration
+	if err := os.WriteFile(path, []byte("auth: kerberos\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("should fail to reload a broken configuration")
+	}
+	if err := authenticate("bob", "secret"); err != nil {
+		t.Fatalf("kept config: should get error nil but got %s", err)
+	}
+
+	// changing the listeners needs a restart
+	if err := os.WriteFile(path, []byte("listen: [{label: other, address: \"127.0.0.1:1081\"}]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); !errors.Is(err, socks5.ErrListenersChanged) {
+		t.Fatalf("should get error %s but got %v", socks5.ErrListenersChanged, err)
+	}
+}
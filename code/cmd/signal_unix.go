@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals make the server reload its configuration
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// upgradeSignals start the new binary on the listeners and drain this process
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// stopSignals drain the sessions before exiting, quitSignals kill them
+var (
+	stopSignals = []os.Signal{syscall.SIGTERM}
+	quitSignals = []os.Signal{os.Interrupt}
+)
+
+// statsSignals log the connection counts
+var statsSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// there is no SIGHUP, configuration files are still watched
+var reloadSignals []os.Signal
+
+// the listeners cannot be handed over on Windows
+var upgradeSignals []os.Signal
+
+// closing the console or shutting down arrives as SIGTERM, Ctrl-C as os.Interrupt
+var (
+	stopSignals = []os.Signal{syscall.SIGTERM}
+	quitSignals = []os.Signal{os.Interrupt}
+)
+
+// there is no SIGUSR1, stats are served by the admin API
+var statsSignals []os.Signal
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+// namedListener is an open listener with the label or address it serves
+type namedListener struct {
+	name string
+	net.Listener
+}
+
+// adminListenerName is the admin API listener among inherited descriptors
+const adminListenerName = "socks5-admin"
+
+// takeListener removes the listener with name from inherited
+func takeListener(inherited []namedListener, name string) ([]namedListener, net.Listener) {
+	for i, l := range inherited {
+		if l.name == name {
+			return append(inherited[:i:i], inherited[i+1:]...), l.Listener
+		}
+	}
+	return inherited, nil
+}
+
+// listenerName is how a listener is known among inherited descriptors, its label or
+// address with colons, which separate the names in LISTEN_FDNAMES, made underscores
+func listenerName(l *socks5.Listener) string {
+	name := l.Label
+	if name == "" {
+		name = l.Address
+	}
+	return strings.ReplaceAll(name, ":", "_")
+}
+
+// unnamed reports whether an inherited listener came without a name, as systemd
+// passes sockets of units without FileDescriptorName
+func unnamed(l namedListener) bool {
+	return l.name == "" || l.name == "unknown"
+}
+
+// listensOn reports whether ln is bound where l is configured to listen,
+// a port or IP left open in the configuration matching any
+func listensOn(ln net.Listener, l *socks5.Listener) bool {
+	if l.Network == "unix" {
+		return ln.Addr().Network() == "unix" && ln.Addr().String() == l.Address
+	}
+	addr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host, portStr, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return false
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil || port != 0 && port != addr.Port {
+		return false
+	}
+	if host == "" {
+		return addr.IP.IsUnspecified()
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if ip.Equal(addr.IP) || ip.IsUnspecified() && addr.IP.IsUnspecified() {
+			return true
+		}
+	}
+	return false
+}
+
+// openListeners opens the listeners of server or gives them the inherited ones, matched
+// by name and address, unnamed ones in order. They are returned to be handed over at an upgrade.
+// An inherited listener of a name now configured elsewhere is closed and a new one opened.
+func openListeners(server *socks5.SOCKS5Server, inherited []namedListener) ([]namedListener, error) {
+	opened := make([]namedListener, len(server.Listeners))
+	used := make([]bool, len(inherited))
+	for i := range server.Listeners {
+		for j, l := range inherited {
+			if used[j] || l.name != listenerName(&server.Listeners[i]) {
+				continue
+			}
+			if !listensOn(l.Listener, &server.Listeners[i]) {
+				log.Printf("inherited listener %q is bound to %s, not %s", l.name, l.Addr(), server.Listeners[i].Address)
+				continue
+			}
+			opened[i], used[j] = l, true
+			break
+		}
+	}
+	for i := range server.Listeners {
+		if opened[i].Listener != nil {
+			continue
+		}
+		for j, l := range inherited {
+			if !used[j] && unnamed(l) {
+				opened[i], used[j] = l, true
+				break
+			}
+		}
+	}
+	for j, l := range inherited {
+		if !used[j] {
+			log.Printf("closing inherited listener %q no listener is configured for", l.name)
+			l.Close()
+		}
+	}
+
+	for i := range server.Listeners {
+		l := &server.Listeners[i]
+		name := listenerName(l)
+		if opened[i].Listener == nil {
+			listener, err := l.Listen()
+			if err != nil {
+				for _, o := range opened {
+					if o.Listener != nil {
+						o.Close()
+					}
+				}
+				return nil, err
+			}
+			opened[i].Listener = listener
+		}
+		opened[i].name = name
+		l.Inherited = opened[i].Listener
+	}
+	return opened, nil
+}
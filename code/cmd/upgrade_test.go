@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/yongfrank/go-socks5"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestOpenListeners(t *testing.T) {
+	public, admin, extra := listen(t), listen(t), listen(t)
+	server := &socks5.SOCKS5Server{Listeners: []socks5.Listener{
+		{Label: "public", Address: "127.0.0.1:0"},
+		{Address: "127.0.0.1:0"},
+		{Label: "fresh", Address: "127.0.0.1:0"},
+	}}
+	// admin came without a name, it is taken in order by the listener without a match
+	inherited := []namedListener{{"unknown", admin}, {"public", public}}
+	opened, err := openListeners(server, inherited)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer opened[2].Close()
+	if server.Listeners[0].Inherited != public || server.Listeners[1].Inherited != admin {
+		t.Fatal("want the inherited listeners matched by name, then in order")
+	}
+	if server.Listeners[2].Inherited == nil || server.Listeners[2].Inherited == extra {
+		t.Fatal("want a new listener opened for fresh")
+	}
+	want := []string{"public", "127.0.0.1_0", "fresh"}
+	for i, l := range opened {
+		if l.name != want[i] || l.Listener != server.Listeners[i].Inherited {
+			t.Fatalf("want listener %d handed over as %q but got %q", i, want[i], l.name)
+		}
+	}
+
+	// inherited listeners no longer configured are closed
+	server = &socks5.SOCKS5Server{Listeners: []socks5.Listener{{Label: "public", Address: "127.0.0.1:0"}}}
+	if _, err := openListeners(server, []namedListener{{"public", public}, {"gone", extra}}); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if _, err := extra.Accept(); err == nil {
+		t.Fatal("want the unused inherited listener closed")
+	}
+}
+
+func TestOpenListenersAddressChanged(t *testing.T) {
+	old, renamed := listen(t), listen(t)
+	moved := listen(t)
+	address := moved.Addr().String()
+	moved.Close()
+	server := &socks5.SOCKS5Server{Listeners: []socks5.Listener{
+		{Label: "public", Address: address},
+		{Label: "other", Address: "127.0.0.1:0"},
+	}}
+	// public moved to another port and a named listener is never taken in order
+	opened, err := openListeners(server, []namedListener{{"public", old}, {"admin", renamed}})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer opened[0].Close()
+	defer opened[1].Close()
+	if got := opened[0].Addr().String(); got != address {
+		t.Fatalf("want public opened anew on %s but got %s", address, got)
+	}
+	if opened[1].Listener == renamed {
+		t.Fatal("want no named listener taken by a listener of another name")
+	}
+	for _, l := range []net.Listener{old, renamed} {
+		if _, err := l.Accept(); err == nil {
+			t.Fatalf("want the mismatched inherited listener %s closed", l.Addr())
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package socks5
 
+import "github.com/yongfrank/go-socks5/wire"
+
 const (
-	SOCKS5Version    = 0x05
-	ReqReservedField = 0x00 // also for reply reserved
+	SOCKS5Version    = wire.Version
+	ReqReservedField = wire.Reserved // also for reply reserved
 )
 
 const (
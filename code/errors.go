@@ -10,14 +10,19 @@
  */
 package socks5
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/yongfrank/go-socks5/wire"
+)
 
 var (
 	ErrPasswordCheckerNotSet       = errors.New("password checker not set")
-	ErrVersionNotSupported         = errors.New("protocol version is not supported, see more on https://www.rfc-editor.org/rfc/rfc1928")
-	ErrMethodVersionNotSupported   = errors.New("username password authentication version is not supported")
+	ErrVersionNotSupported         = wire.ErrVersionNotSupported
+	ErrMethodVersionNotSupported   = wire.ErrMethodVersionNotSupported
 	ErrPasswordAuthFailure         = errors.New("current in password mode: username / password wrong")
-	ErrRequestCommandNotSupported  = errors.New("request command not supported")
-	ErrRequestReservedFieldNotZero = errors.New("request reserved field is not zero")
-	ErrAddressTypeNotSupported     = errors.New("request address type not supported")
+	ErrRequestCommandNotSupported  = wire.ErrRequestCommandNotSupported
+	ErrRequestReservedFieldNotZero = wire.ErrReservedFieldNotZero
+	ErrAddressTypeNotSupported     = wire.ErrAddressTypeNotSupported
+	ErrConnectionNotAllowed        = errors.New("connection not allowed by ruleset")
 )
@@ -0,0 +1,498 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+GeoIP databases are MaxMind DB files (.mmdb), such as GeoLite2-Country and GeoLite2-ASN.
+https://maxmind.github.io/MaxMind-DB/
+
+	+-------------+----------+--------------+-------------------------------+----------+
+	| search tree | 16 x 00  | data section | \xAB\xCD\xEF MaxMind.com      | metadata |
+	+-------------+----------+--------------+-------------------------------+----------+
+
+The search tree is a binary trie over the bits of an address. Each node holds a
+left (0) and right (1) record of 24, 28 or 32 bits. A record below node_count is
+the next node, node_count means not found and anything above points to the data
+section at record - node_count - 16. IPv4 addresses are stored as ::a.b.c.d in
+IPv6 databases.
+
+Data fields start with a control byte, the type in its top 3 bits and a size
+in the lower 5. Type 0 is extended, the next byte is the type - 7. Sizes 29, 30
+and 31 are followed by 1, 2 or 3 bytes of size. Pointers (type 1) refer to
+another field in the data section.
+*/
+
+var (
+	ErrInvalidGeoIPDatabase = errors.New("invalid geoip database")
+
+	mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+)
+
+const defaultGeoIPCheckInterval = 10 * time.Second
+
+// GeoInfo is what GeoIP databases know about an IP address.
+type GeoInfo struct {
+	// Country is the ISO 3166-1 alpha-2 code, such as "DE"
+	Country string
+	// ASN is the autonomous system number, zero if unknown
+	ASN          uint32
+	Organization string
+}
+
+// GeoLookup looks up IP addresses, GeoIP implements it.
+type GeoLookup interface {
+	Lookup(ip net.IP) GeoInfo
+}
+
+// GeoIP looks up addresses in local MaxMind DB files, reloading a file when it changes.
+type GeoIP struct {
+	// CheckInterval is how often the files are checked for changes, 10 seconds if zero
+	CheckInterval time.Duration
+
+	paths   []string
+	checked atomic.Int64
+
+	mu       sync.RWMutex
+	dbs      []*mmdb
+	modTimes []time.Time
+}
+
+// OpenGeoIP opens the databases at paths, the first one knowing a field answers it,
+// for example a country database and an ASN database.
+func OpenGeoIP(paths ...string) (*GeoIP, error) {
+	g := &GeoIP{
+		paths:    paths,
+		dbs:      make([]*mmdb, len(paths)),
+		modTimes: make([]time.Time, len(paths)),
+	}
+	for i, path := range paths {
+		db, modTime, err := openMMDB(path)
+		if err != nil {
+			return nil, err
+		}
+		g.dbs[i], g.modTimes[i] = db, modTime
+	}
+	g.checked.Store(time.Now().UnixNano())
+	return g, nil
+}
+
+// Lookup returns the country and autonomous system of ip.
+func (g *GeoIP) Lookup(ip net.IP) GeoInfo {
+	g.check()
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var info GeoInfo
+	for _, db := range g.dbs {
+		record, _ := db.lookup(ip).(map[string]interface{})
+		if info.Country == "" {
+			info.Country = mmdbCountry(record)
+		}
+		if info.ASN == 0 {
+			if asn, ok := record["autonomous_system_number"].(uint64); ok {
+				info.ASN = uint32(asn)
+			}
+			info.Organization, _ = record["autonomous_system_organization"].(string)
+		}
+	}
+	return info
+}
+
+// Reload reopens the databases whose files changed, keeping the old one of a file that fails to open.
+func (g *GeoIP) Reload() error {
+	var errs []error
+	for i, path := range g.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		g.mu.RLock()
+		changed := !fi.ModTime().Equal(g.modTimes[i])
+		g.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		db, modTime, err := openMMDB(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		g.mu.Lock()
+		g.dbs[i], g.modTimes[i] = db, modTime
+		g.mu.Unlock()
+		log.Printf("geoip database %s reloaded", path)
+	}
+	return errors.Join(errs...)
+}
+
+// check reloads changed files at most once per CheckInterval
+func (g *GeoIP) check() {
+	interval := g.CheckInterval
+	if interval == 0 {
+		interval = defaultGeoIPCheckInterval
+	}
+	now := time.Now().UnixNano()
+	last := g.checked.Load()
+	if now-last < int64(interval) || !g.checked.CompareAndSwap(last, now) {
+		return
+	}
+	if err := g.Reload(); err != nil {
+		log.Printf("geoip database not reloaded: %s", err)
+	}
+}
+
+// mmdbCountry returns the country of a City or Country database record
+func mmdbCountry(record map[string]interface{}) string {
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := record[key].(map[string]interface{})
+		if code, ok := country["iso_code"].(string); ok {
+			return code
+		}
+	}
+	return ""
+}
+
+// locate looks up the client and destination of req in config.GeoIP,
+// a domain destination by its first resolved address
+func (s *SOCKS5Server) locate(config *Config, req *Request) {
+	if config.GeoIP == nil {
+		return
+	}
+	if ip, _ := tcpAddr(req.ClientAddr); ip != nil {
+		req.ClientGeo = config.GeoIP.Lookup(ip)
+	}
+	if req.Dst.FQDN == "" {
+		req.DstGeo = config.GeoIP.Lookup(req.Dst.IP.AsSlice())
+	} else if len(req.DstIPs) > 0 {
+		req.DstGeo = config.GeoIP.Lookup(req.DstIPs[0])
+	}
+}
+
+// resolve looks up the addresses of a domain destination once when config.GeoIP is set,
+// the rules match addresses or a PROXY header names the address connected to through
+// an upstream. The rules are then checked against every address and only the allowed
+// ones are dialed.
+func (s *SOCKS5Server) resolve(config *Config, req *Request) error {
+	if req.Dst.FQDN == "" || (config.GeoIP == nil && !matchesAddresses(config.Rules) && config.SendProxyHeader == 0) {
+		return nil
+	}
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx := context.Background()
+	if config.TCPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.TCPTimeout)
+		defer cancel()
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, req.Dst.FQDN)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no addresses for %s", req.Dst.FQDN)
+	}
+	req.DstIPs = make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		req.DstIPs[i] = a.IP
+	}
+	return nil
+}
+
+// matchGeo matches a country and ASN against the lists of a rule or route, empty lists match everything
+func matchGeo(info GeoInfo, countries []string, asns []uint32) bool {
+	if len(countries) > 0 {
+		matched := false
+		for _, c := range countries {
+			if info.Country != "" && strings.EqualFold(c, info.Country) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(asns) > 0 {
+		matched := false
+		for _, asn := range asns {
+			if asn == info.ASN && asn != 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// mmdb is a MaxMind DB file read into memory
+type mmdb struct {
+	nodeCount  uint32
+	recordSize int
+	ipVersion  int
+	tree       []byte
+	data       mmdbDecoder
+	// ipv4Start is the node of ::/96 in IPv6 databases
+	ipv4Start uint32
+}
+
+func openMMDB(path string) (*mmdb, time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	db, err := parseMMDB(buf)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, fi.ModTime(), nil
+}
+
+func parseMMDB(buf []byte) (*mmdb, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrInvalidGeoIPDatabase)
+	}
+	value, _, err := mmdbDecoder(buf[i+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := value.(map[string]interface{})
+	nodeCount, _ := metadata["node_count"].(uint64)
+	recordSize, _ := metadata["record_size"].(uint64)
+	ipVersion, _ := metadata["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidGeoIPDatabase, recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("%w: ip version %d", ErrInvalidGeoIPDatabase, ipVersion)
+	}
+	treeSize := nodeCount * recordSize / 4
+	if nodeCount >= math.MaxUint32 || treeSize+16 > uint64(i) {
+		return nil, fmt.Errorf("%w: node count %d", ErrInvalidGeoIPDatabase, nodeCount)
+	}
+
+	db := &mmdb{
+		nodeCount:  uint32(nodeCount),
+		recordSize: int(recordSize),
+		ipVersion:  int(ipVersion),
+		tree:       buf[:treeSize],
+		data:       mmdbDecoder(buf[treeSize+16 : i]),
+	}
+	if db.ipVersion == 6 {
+		node := uint32(0)
+		for bit := 0; bit < 96 && node < db.nodeCount; bit++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// lookup returns the data record of ip, nil if there is none
+func (db *mmdb) lookup(ip net.IP) interface{} {
+	node := uint32(0)
+	addr := ip.To4()
+	if addr != nil && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if addr == nil {
+		if addr = ip.To16(); addr == nil || db.ipVersion == 4 {
+			return nil
+		}
+	}
+	for bit := 0; bit < len(addr)*8 && node < db.nodeCount; bit++ {
+		node = db.record(node, uint32(addr[bit/8]>>(7-bit%8))&1)
+	}
+	if node <= db.nodeCount {
+		return nil
+	}
+	value, _, err := db.data.decode(int(node-db.nodeCount-16), 0)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// record returns the left (0) or right (1) record of node
+func (db *mmdb) record(node, side uint32) uint32 {
+	b := db.tree
+	switch db.recordSize {
+	case 24:
+		off := node*6 + side*3
+		return uint32(b[off])<<16 | uint32(b[off+1])<<8 | uint32(b[off+2])
+	case 28:
+		off := node * 7
+		if side == 0 {
+			return uint32(b[off+3]&0xF0)<<20 | uint32(b[off])<<16 | uint32(b[off+1])<<8 | uint32(b[off+2])
+		}
+		return uint32(b[off+3]&0x0F)<<24 | uint32(b[off+4])<<16 | uint32(b[off+5])<<8 | uint32(b[off+6])
+	}
+	return binary.BigEndian.Uint32(b[node*8+side*4:])
+}
+
+// mmdbDecoder decodes the fields of a data or metadata section
+type mmdbDecoder []byte
+
+const mmdbMaxDepth = 32
+
+// decode returns the value at offset and the offset after it.
+// Maps are map[string]interface{}, arrays []interface{} and unsigned integers uint64.
+func (d mmdbDecoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("%w: data nested too deep", ErrInvalidGeoIPDatabase)
+	}
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	typ := int(ctrl >> 5)
+
+	if typ == 1 {
+		n := int(ctrl>>3&3) + 1
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		ptr := 0
+		if n < 4 {
+			ptr = int(ctrl & 7)
+		}
+		for _, c := range b {
+			ptr = ptr<<8 | int(c)
+		}
+		ptr += []int{0, 2048, 526336, 0}[n-1]
+		value, _, err := d.decode(ptr, depth+1)
+		return value, offset + n, err
+	}
+
+	if typ == 0 {
+		if b, err = d.bytes(offset, 1); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(b[0])
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, err = d.bytes(offset, n); err != nil {
+			return nil, 0, err
+		}
+		size = 0
+		for _, c := range b {
+			size = size<<8 | int(c)
+		}
+		size += []int{29, 285, 65821}[n-1]
+		offset += n
+	}
+
+	switch typ {
+	case 7, 11:
+		return d.decodeContainer(typ, size, offset, depth)
+	case 14:
+		return size != 0, offset, nil
+	}
+	if b, err = d.bytes(offset, size); err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of %d bytes", ErrInvalidGeoIPDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4:
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 8, 9, 10:
+		if size > 8 {
+			// uint128 values do not fit, they are not used by country or ASN databases
+			b = b[size-8:]
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if typ == 8 {
+			return int64(int32(uint32(v))), offset, nil
+		}
+		return v, offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of %d bytes", ErrInvalidGeoIPDatabase, size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: data type %d", ErrInvalidGeoIPDatabase, typ)
+}
+
+func (d mmdbDecoder) decodeContainer(typ, size, offset, depth int) (interface{}, int, error) {
+	// sizes come from the file, do not allocate for more than a few entries up front
+	capacity := size
+	if capacity > 64 {
+		capacity = 64
+	}
+	if typ == 11 {
+		array := make([]interface{}, 0, capacity)
+		for i := 0; i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			array = append(array, value)
+			offset = next
+		}
+		return array, offset, nil
+	}
+	m := make(map[string]interface{}, capacity)
+	for i := 0; i < size; i++ {
+		key, next, err := d.decode(offset, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: map key is not a string", ErrInvalidGeoIPDatabase)
+		}
+		value, next, err := d.decode(next, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		m[k] = value
+		offset = next
+	}
+	return m, offset, nil
+}
+
+func (d mmdbDecoder) bytes(offset, n int) ([]byte, error) {
+	if offset < 0 || n < 0 || offset+n > len(d) {
+		return nil, fmt.Errorf("%w: data out of range", ErrInvalidGeoIPDatabase)
+	}
+	return d[offset : offset+n], nil
+}
@@ -0,0 +1,282 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mmdbWriter encodes data section fields, repeated strings are written as pointers
+type mmdbWriter struct {
+	buf     bytes.Buffer
+	strings map[string]int
+}
+
+func (w *mmdbWriter) encode(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		if off, ok := w.strings[v]; ok && off < 2048 {
+			w.buf.Write([]byte{1<<5 | byte(off>>8), byte(off)})
+			return
+		}
+		w.strings[v] = w.buf.Len()
+		if len(v) < 29 {
+			w.buf.WriteByte(2<<5 | byte(len(v)))
+		} else {
+			w.buf.Write([]byte{2<<5 | 29, byte(len(v) - 29)})
+		}
+		w.buf.WriteString(v)
+	case uint16:
+		w.buf.WriteByte(5<<5 | 2)
+		binary.Write(&w.buf, binary.BigEndian, v)
+	case uint32:
+		w.buf.WriteByte(6<<5 | 4)
+		binary.Write(&w.buf, binary.BigEndian, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.buf.WriteByte(7<<5 | byte(len(v)))
+		for _, k := range keys {
+			w.encode(k)
+			w.encode(v[k])
+		}
+	}
+}
+
+// writeMMDB writes an IPv6 database of networks to their records
+func writeMMDB(t *testing.T, recordSize int, networks map[string]map[string]interface{}) string {
+	t.Helper()
+	// nodes hold records as node index, or data offset + 1 negated, 0 being empty
+	nodes := [][2]int{{0, 0}}
+	data := &mmdbWriter{strings: make(map[string]int)}
+	for cidr, record := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, bits := network.Mask.Size()
+		ip := network.IP.To16()
+		if bits == 32 {
+			// IPv4 networks are stored under ::/96
+			ip = append(make(net.IP, 12), network.IP.To4()...)
+			ones += 96
+		}
+		offset := data.buf.Len()
+		data.encode(record)
+		node := 0
+		for i := 0; i < ones; i++ {
+			side := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[node][side] = -offset - 1
+				break
+			}
+			if nodes[node][side] <= 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][side] = len(nodes) - 1
+			}
+			node = nodes[node][side]
+		}
+	}
+
+	var tree bytes.Buffer
+	nodeCount := len(nodes)
+	value := func(r int) uint32 {
+		switch {
+		case r > 0:
+			return uint32(r)
+		case r < 0:
+			return uint32(nodeCount + 16 - r - 1)
+		}
+		return uint32(nodeCount)
+	}
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(left>>20)&0xF0 | byte(right>>24)&0x0F, byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			binary.Write(&tree, binary.BigEndian, [2]uint32{left, right})
+		}
+	}
+	metadata := &mmdbWriter{strings: make(map[string]int)}
+	metadata.encode(map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(recordSize),
+		"ip_version":    uint16(6),
+		"database_type": "Test",
+	})
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, 16))
+	file.Write(data.buf.Bytes())
+	file.Write(mmdbMetadataMarker)
+	file.Write(metadata.buf.Bytes())
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func country(code string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": code}}
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	asnPath := writeMMDB(t, 24, map[string]map[string]interface{}{
+		"203.0.113.0/24": {"autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Net"},
+	})
+	for _, recordSize := range []int{24, 28, 32} {
+		countryPath := writeMMDB(t, recordSize, map[string]map[string]interface{}{
+			"203.0.113.0/25":   country("DE"),
+			"203.0.113.128/25": country("FR"),
+			"198.51.100.0/24":  {"registered_country": map[string]interface{}{"iso_code": "KP"}},
+			"2001:db8::/32":    country("IR"),
+		})
+		g, err := OpenGeoIP(countryPath, asnPath)
+		if err != nil {
+			t.Fatalf("record size %d: should get error nil but got %s", recordSize, err)
+		}
+		tests := []struct {
+			ip   string
+			want GeoInfo
+		}{
+			{"203.0.113.7", GeoInfo{Country: "DE", ASN: 64500, Organization: "Example Net"}},
+			{"203.0.113.200", GeoInfo{Country: "FR", ASN: 64500, Organization: "Example Net"}},
+			{"198.51.100.1", GeoInfo{Country: "KP"}},
+			{"2001:db8::1", GeoInfo{Country: "IR"}},
+			{"192.0.2.1", GeoInfo{}},
+			{"2001:db9::1", GeoInfo{}},
+		}
+		for _, test := range tests {
+			if got := g.Lookup(net.ParseIP(test.ip)); got != test.want {
+				t.Fatalf("record size %d, %s: want %+v but got %+v", recordSize, test.ip, test.want, got)
+			}
+		}
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	path := writeMMDB(t, 24, map[string]map[string]interface{}{"203.0.113.0/24": country("DE")})
+	g, err := OpenGeoIP(path)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	g.CheckInterval = time.Nanosecond
+
+	// a broken file keeps the loaded database
+	os.WriteFile(path, []byte("not a database"), 0600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if err := g.Reload(); !errors.Is(err, ErrInvalidGeoIPDatabase) {
+		t.Fatalf("want error %s but got %v", ErrInvalidGeoIPDatabase, err)
+	}
+	if got := g.Lookup(net.ParseIP("203.0.113.1")).Country; got != "DE" {
+		t.Fatalf("want country DE but got %q", got)
+	}
+
+	next, _ := os.ReadFile(writeMMDB(t, 24, map[string]map[string]interface{}{"203.0.113.0/24": country("FR")}))
+	os.WriteFile(path, next, 0600)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	time.Sleep(time.Millisecond)
+	if got := g.Lookup(net.ParseIP("203.0.113.1")).Country; got != "FR" {
+		t.Fatalf("want country FR after the file changed but got %q", got)
+	}
+}
+
+func TestGeoIPRules(t *testing.T) {
+	echo := startEchoServer(t)
+	g, err := OpenGeoIP(writeMMDB(t, 28, map[string]map[string]interface{}{
+		"127.0.0.0/8": {"country": map[string]interface{}{"iso_code": "KP"}, "autonomous_system_number": uint32(64501)},
+	}))
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	config := &Config{AuthMethod: MethodNoAuth, GeoIP: g, Rules: &AccessRules{Rules: []AccessRule{
+		{Deny: true, Countries: []string{"KP", "IR"}, Ports: []uint16{1}},
+		{Deny: true, ClientASNs: []uint32{64501}, Ports: []uint16{2}},
+	}}}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+
+	for _, address := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		_, err := d.Dial("tcp", address)
+		var replyErr *ReplyError
+		if !errors.As(err, &replyErr) || replyErr.Reply != ReplyConnectionNotAllowed {
+			t.Fatalf("%s: want reply %s but got %v", address, ErrorString(ReplyConnectionNotAllowed), err)
+		}
+	}
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+}
+
+// noDNS fails every lookup, domains are then only reached at addresses already resolved
+var noDNS = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, errors.New("no dns in tests")
+}}
+
+func TestGeoIPResolvedAddresses(t *testing.T) {
+	echo := startEchoServer(t)
+	g, err := OpenGeoIP(writeMMDB(t, 24, map[string]map[string]interface{}{
+		"127.0.0.0/24": country("DE"),
+		"127.0.1.0/24": country("KP"),
+	}))
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	config := &Config{GeoIP: g, Rules: &AccessRules{Rules: []AccessRule{{Deny: true, Countries: []string{"KP"}}}}}
+	s := &SOCKS5Server{Resolver: noDNS}
+	dst := Addr{FQDN: "mixed.example", Port: uint16(echo.Port)}
+
+	// every address is checked, not only the first, and only the allowed ones are dialed
+	req := &Request{Dst: dst, DstIPs: []net.IP{net.ParseIP("127.0.1.1"), net.ParseIP("127.0.0.1")}}
+	if allowed, _ := config.allow(req); !allowed {
+		t.Fatal("want the request allowed to its address in DE")
+	}
+	if len(req.DstIPs) != 1 || !req.DstIPs[0].Equal(net.ParseIP("127.0.0.1")) || req.DstGeo.Country != "DE" {
+		t.Fatalf("want only 127.0.0.1 in DE left but got %v %+v", req.DstIPs, req.DstGeo)
+	}
+	conn, err := s.dial(config, req)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+
+	req = &Request{Dst: dst, DstIPs: []net.IP{net.ParseIP("127.0.1.1"), net.ParseIP("127.0.1.2")}}
+	if allowed, _ := config.allow(req); allowed {
+		t.Fatal("want the request denied when every address is in KP")
+	}
+
+	// a domain that cannot be located is denied
+	if allowed, _ := s.allow(nil, config, &Request{Dst: dst}); allowed {
+		t.Fatal("want the request denied when the destination does not resolve")
+	}
+}
+
+func TestParseMMDBInvalid(t *testing.T) {
+	for _, buf := range [][]byte{
+		nil,
+		[]byte("no metadata here"),
+		append(append([]byte{}, mmdbMetadataMarker...), 7<<5|1, 2<<5|4),
+		append(append([]byte{}, mmdbMetadataMarker...), 1<<5|3<<3, 0xff),
+	} {
+		if _, err := parseMMDB(buf); !errors.Is(err, ErrInvalidGeoIPDatabase) || !strings.Contains(err.Error(), "geoip") {
+			t.Fatalf("%q: want error %s but got %v", buf, ErrInvalidGeoIPDatabase, err)
+		}
+	}
+}
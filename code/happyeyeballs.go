@@ -0,0 +1,218 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+Happy Eyeballs Version 2: Better Connectivity Using Concurrency
+https://www.rfc-editor.org/rfc/rfc8305
+
+Domain destinations are resolved to their A and AAAA records, ordered by
+Config.AddressPreference, and dialed in a race:
+
+	t=0      connect to address 1
+	t=250ms  connect to address 2, unless address 1 failed earlier
+	t=500ms  connect to address 3, ...
+
+A failed attempt starts the next one at once, the first connection wins and
+the others are cancelled. Every address is tried before the request fails,
+all within Config.TCPTimeout.
+*/
+
+// AddressPreference orders the resolved addresses of a domain destination.
+type AddressPreference int
+
+const (
+	// PreferInterleave alternates IPv6 and IPv4 addresses, starting with IPv6 as RFC 8305 recommends
+	PreferInterleave AddressPreference = iota
+	// PreferIPv4 tries every IPv4 address before the IPv6 ones
+	PreferIPv4
+	// PreferIPv6 tries every IPv6 address before the IPv4 ones
+	PreferIPv6
+)
+
+func (p AddressPreference) String() string {
+	switch p {
+	case PreferInterleave:
+		return "interleave"
+	case PreferIPv4:
+		return "ipv4"
+	case PreferIPv6:
+		return "ipv6"
+	}
+	return "AddressPreference(" + strconv.Itoa(int(p)) + ")"
+}
+
+// defaultConnectionAttemptDelay is the delay between connection attempts RFC 8305 recommends
+const defaultConnectionAttemptDelay = 250 * time.Millisecond
+
+// dialDirect connects to address from this host, racing the addresses of a domain,
+// from local or else a source address of config.SourcePool and with socket options opts.
+// A domain already resolved for the rules is dialed at the addresses they allowed.
+// config.Dial, if set, replaces it unless local is.
+func (s *SOCKS5Server) dialDirect(ctx context.Context, config *Config, req *Request, address string, local *net.TCPAddr, opts *SocketOptions) (net.Conn, error) {
+	if config.Dial != nil && local == nil {
+		return config.Dial(ctx, "tcp", address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	dial := func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		d := net.Dialer{LocalAddr: local}
+		if local == nil && config.SourcePool != nil {
+			source, err := config.SourcePool.Pick(req, ip)
+			if err != nil {
+				return nil, err
+			}
+			d.LocalAddr = &net.TCPAddr{IP: source}
+		}
+		conn, err := opts.dial(ctx, &d, net.JoinHostPort(ip.String(), port))
+		if err == nil && local == nil && config.SourcePool != nil {
+			log.Printf("connected to %s from source %s (%s)", conn.RemoteAddr(), conn.LocalAddr(), config.SourcePool.Strategy)
+		}
+		return conn, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return dial(ctx, ip)
+	}
+
+	ips := req.resolved(host)
+	if ips == nil {
+		resolver := s.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		addrs, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		ips = make([]net.IP, len(addrs))
+		for i, a := range addrs {
+			ips[i] = a.IP
+		}
+	}
+	conn, _, err := config.raceAddresses(ctx, ips, dial)
+	return conn, err
+}
+
+// raceAddresses dials ips in the order of config.AddressPreference, starting
+// an attempt every config.ConnectionAttemptDelay
+func (c *Config) raceAddresses(ctx context.Context, ips []net.IP, dial func(ctx context.Context, ip net.IP) (net.Conn, error)) (net.Conn, net.IP, error) {
+	delay := c.ConnectionAttemptDelay
+	if delay == 0 {
+		delay = defaultConnectionAttemptDelay
+	}
+	return dialAddresses(ctx, dial, sortAddresses(ips, c.AddressPreference), delay)
+}
+
+// resolved returns the addresses allowed for host when it is the domain
+// destination resolved for the rules, nil otherwise
+func (r *Request) resolved(host string) []net.IP {
+	if len(r.DstIPs) == 0 || host != r.Dst.FQDN {
+		return nil
+	}
+	return r.DstIPs
+}
+
+// sortAddresses orders ips for dialing by preference
+func sortAddresses(ips []net.IP, preference AddressPreference) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch preference {
+	case PreferIPv4:
+		return append(v4, v6...)
+	case PreferIPv6:
+		return append(v6, v4...)
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return sorted
+}
+
+// dialAddresses races connections to ips, starting the next attempt every delay
+// or when an attempt fails, and returns the first connection and its address
+func dialAddresses(ctx context.Context, dial func(ctx context.Context, ip net.IP) (net.Conn, error), ips []net.IP, delay time.Duration) (net.Conn, net.IP, error) {
+	if len(ips) == 0 {
+		return nil, nil, errors.New("no addresses to dial")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		ip   net.IP
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		ip := ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, ip)
+			results <- result{conn, ip, err}
+		}()
+	}
+
+	var errs []error
+	start()
+	for pending > 0 {
+		var stagger <-chan time.Time
+		var timer *time.Timer
+		if next < len(ips) && ctx.Err() == nil {
+			timer = time.NewTimer(delay)
+			stagger = timer.C
+		}
+		select {
+		case r := <-results:
+			pending--
+			if timer != nil {
+				timer.Stop()
+			}
+			if r.err == nil {
+				// close the connections of attempts finishing after the winner
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.ip, nil
+			}
+			errs = append(errs, r.err)
+			if next < len(ips) && ctx.Err() == nil {
+				log.Printf("%s, trying the next address", r.err)
+				start()
+			}
+		case <-stagger:
+			start()
+		}
+	}
+	if len(errs) == 1 {
+		return nil, nil, errs[0]
+	}
+	return nil, nil, fmt.Errorf("all %d addresses failed: %w", len(errs), errors.Join(errs...))
+}
@@ -0,0 +1,66 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortAddresses(t *testing.T) {
+	var ips []net.IP
+	for _, s := range []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "192.0.2.3", "2001:db8::2"} {
+		ips = append(ips, net.ParseIP(s))
+	}
+	tests := []struct {
+		preference AddressPreference
+		want       string
+	}{
+		{PreferInterleave, "2001:db8::1 192.0.2.1 2001:db8::2 192.0.2.2 192.0.2.3"},
+		{PreferIPv4, "192.0.2.1 192.0.2.2 192.0.2.3 2001:db8::1 2001:db8::2"},
+		{PreferIPv6, "2001:db8::1 2001:db8::2 192.0.2.1 192.0.2.2 192.0.2.3"},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, ip := range sortAddresses(ips, test.preference) {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Fatalf("%s: want %s but got %s", test.preference, test.want, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestDialAddresses(t *testing.T) {
+	echo := startEchoServer(t)
+	port := strconv.Itoa(echo.Port)
+	dial := func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+	}
+	// nothing listens on 127.0.0.2, its failure starts the next attempt without waiting
+	ips := []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}
+	start := time.Now()
+	conn, ip, err := dialAddresses(context.Background(), dial, ips, time.Minute)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if !ip.Equal(ips[1]) {
+		t.Fatalf("want connected to %s but got %s", ips[1], ip)
+	}
+	conn.Close()
+	if time.Since(start) > 30*time.Second {
+		t.Fatalf("want the second address tried after the first failed but took %s", time.Since(start))
+	}
+
+	_, _, err = dialAddresses(context.Background(), dial, ips[:1], time.Minute)
+	if err == nil {
+		t.Fatalf("want error but got nil")
+	}
+	_, _, err = dialAddresses(context.Background(), dial, []net.IP{ips[0], ips[0]}, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "all 2 addresses failed") {
+		t.Fatalf("want all 2 addresses failed but got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package socks5
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+HTTP proxy requests are served on the SOCKS port too, told apart from
+SOCKS by the first byte being a letter of the request method.
+
+	o  CONNECT host:port HTTP/1.1, a tunnel like a SOCKS5 CONNECT
+	o  GET http://host/path HTTP/1.1, forwarded to the origin server
+
+With MethodPassword the credentials are taken from a Proxy-Authorization
+Basic header and checked like the RFC 1929 subnegotiation.
+*/
+
+var ErrHTTPBadRequest = errors.New("malformed http proxy request")
+
+// hopHeaders apply to a single connection and are not forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Upgrade",
+}
+
+func isHTTPMethodByte(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+func (s *SOCKS5Server) handleHTTP(conn *bufferedConn, config *Config, identity *User) error {
+	for {
+		req, err := http.ReadRequest(conn.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			writeHTTPError(conn, http.StatusBadRequest, nil)
+			return err
+		}
+
+		user := identity
+		if user == nil {
+			user, err = httpProxyAuth(req, config, conn.RemoteAddr())
+		}
+		if err != nil {
+			writeHTTPError(conn, http.StatusProxyAuthRequired, http.Header{
+				"Proxy-Authenticate": {`Basic realm="socks5"`},
+			})
+			return err
+		}
+
+		if req.Method == http.MethodConnect {
+			return s.handleHTTPConnect(conn, config, req, user)
+		}
+		keepAlive, err := s.handleHTTPForward(conn, config, req, user)
+		if err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+// httpProxyAuth checks the Proxy-Authorization header when password authentication is configured
+func httpProxyAuth(req *http.Request, config *Config, clientAddr net.Addr) (*User, error) {
+	if config.AuthMethod != MethodPassword {
+		return nil, nil
+	}
+	username, password, ok := parseProxyBasicAuth(req.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return nil, ErrPasswordAuthFailure
+	}
+	return config.authenticator().Authenticate(username, password, clientAddr)
+}
+
+func parseProxyBasicAuth(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func writeHTTPError(conn io.Writer, code int, header http.Header) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	header.Write(&b)
+	b.WriteString("Content-Length: 0\r\nConnection: close\r\n\r\n")
+	io.WriteString(conn, b.String())
+}
+
+// httpTarget returns the address of the request target, port 80 by default
+func httpTarget(hostport string) (Addr, error) {
+	if !strings.Contains(hostport, ":") || strings.HasSuffix(hostport, "]") {
+		hostport += ":80"
+	}
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return Addr{}, err
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil || host == "" {
+		return Addr{}, ErrHTTPBadRequest
+	}
+	return ParseAddr(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+func (s *SOCKS5Server) handleHTTPConnect(conn *bufferedConn, config *Config, req *http.Request, user *User) error {
+	dst, err := httpTarget(req.RequestURI)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadRequest, nil)
+		return err
+	}
+	log.Printf("http connect to %s", req.RequestURI)
+
+	r := &Request{
+		User:       user,
+		ClientAddr: conn.RemoteAddr(),
+		Cmd:        CmdConnect,
+		Dst:        dst,
+	}
+	allowed, deadline := s.allow(conn, config, r)
+	if !allowed {
+		writeHTTPError(conn, http.StatusForbidden, nil)
+		return ErrConnectionNotAllowed
+	}
+
+	targetConn, err := s.dial(config, r)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadGateway, nil)
+		return err
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		targetConn.Close()
+		return err
+	}
+	return relay(conn, targetConn, deadline)
+}
+
+// handleHTTPForward sends a single absolute-URI request to the origin server
+// and copies the response back. It reports whether the client connection can be reused.
+func (s *SOCKS5Server) handleHTTPForward(conn *bufferedConn, config *Config, req *http.Request, user *User) (bool, error) {
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		writeHTTPError(conn, http.StatusBadRequest, nil)
+		return false, ErrHTTPBadRequest
+	}
+	dst, err := httpTarget(req.URL.Host)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadRequest, nil)
+		return false, err
+	}
+	log.Printf("http %s %s", req.Method, req.URL)
+
+	r := &Request{
+		User:       user,
+		ClientAddr: conn.RemoteAddr(),
+		Cmd:        CmdConnect,
+		Dst:        dst,
+	}
+	allowed, deadline := s.allow(conn, config, r)
+	if !allowed {
+		writeHTTPError(conn, http.StatusForbidden, nil)
+		return false, ErrConnectionNotAllowed
+	}
+
+	targetConn, err := s.dial(config, r)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadGateway, nil)
+		return false, err
+	}
+	defer targetConn.Close()
+	defer closeAt(conn, targetConn, deadline)()
+
+	keepAlive := !req.Close
+	removeHopHeaders(req.Header)
+	// one origin connection per request
+	req.Close = true
+	if err := req.Write(targetConn); err != nil {
+		writeHTTPError(conn, http.StatusBadGateway, nil)
+		return false, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(targetConn), req)
+	if err != nil {
+		writeHTTPError(conn, http.StatusBadGateway, nil)
+		return false, err
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	// without a length the body ends when the connection closes
+	if resp.ContentLength < 0 && !(len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked") {
+		keepAlive = false
+	}
+	resp.Close = !keepAlive
+	if err := resp.Write(conn); err != nil {
+		return false, err
+	}
+	return keepAlive, nil
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, field := range header.Values("Connection") {
+		for _, name := range strings.Split(field, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
@@ -0,0 +1,132 @@
+package socks5
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	config := &Config{
+		AuthMethod: MethodPassword,
+		PasswordChecker: func(username, password string) bool {
+			return username == "admin" && password == "123456"
+		},
+	}
+	proxyAddr := startTestServer(t, config)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Errorf("proxy credentials forwarded to origin")
+		}
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer origin.Close()
+
+	t.Run("forward with credentials", func(t *testing.T) {
+		proxyURL := &url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("admin", "123456")}
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+		for _, path := range []string{"/a", "/b"} {
+			resp, err := client.Get(origin.URL + path)
+			if err != nil {
+				t.Fatalf("should get error nil but got %s", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "hello "+path {
+				t.Fatalf("want body %q but got %q", "hello "+path, body)
+			}
+		}
+	})
+
+	t.Run("forward without credentials", func(t *testing.T) {
+		proxyURL := &url.URL{Scheme: "http", Host: proxyAddr}
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusProxyAuthRequired {
+			t.Fatalf("want status %d but got %d", http.StatusProxyAuthRequired, resp.StatusCode)
+		}
+	})
+
+	t.Run("connect tunnel", func(t *testing.T) {
+		echo := startEchoServer(t)
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// "admin:123456"
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: Basic YWRtaW46MTIzNDU2\r\n\r\n", echo, echo)
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("want status 200 but got %d", resp.StatusCode)
+		}
+		conn.Write([]byte("ping"))
+		got := make([]byte, 4)
+		if _, err := io.ReadFull(r, got); err != nil || string(got) != "ping" {
+			t.Fatalf("want echo ping but got %q, %v", got, err)
+		}
+	})
+}
+
+func TestParseProxyBasicAuth(t *testing.T) {
+	username, password, ok := parseProxyBasicAuth("basic YWRtaW46MTI6MzQ=")
+	if !ok || username != "admin" || password != "12:34" {
+		t.Fatalf("want admin 12:34 but got %q %q %v", username, password, ok)
+	}
+	if _, _, ok := parseProxyBasicAuth("Bearer token"); ok {
+		t.Fatalf("should not accept bearer tokens")
+	}
+}
+
+func TestHTTPForwardDeadline(t *testing.T) {
+	config := &Config{
+		Rules: ruleFunc(func(req *Request) (bool, time.Time) {
+			return true, time.Now().Add(200 * time.Millisecond)
+		}),
+	}
+	proxyAddr := startTestServer(t, config)
+
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer origin.Close()
+	defer close(release)
+
+	proxyURL := &url.URL{Scheme: "http", Host: proxyAddr}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+	start := time.Now()
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("want the response cut off at the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("want the session closed at the deadline but it took %s", elapsed)
+	}
+}
@@ -0,0 +1,586 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal LDAPv3 client (RFC 4511), just enough for simple bind,
+// search and StartTLS. Messages are BER encoded.
+
+const (
+	berClassUniversal   = 0x00
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+	berConstructed      = 0x20
+
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+	berTagSet         = 0x11
+
+	maxBERLength = 1 << 20
+)
+
+type berPacket struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*berPacket
+}
+
+func berPrimitive(class, tag byte, value []byte) *berPacket {
+	return &berPacket{class: class, tag: tag, value: value}
+}
+
+func berConstruct(class, tag byte, children ...*berPacket) *berPacket {
+	return &berPacket{class: class, constructed: true, tag: tag, children: children}
+}
+
+func berSequence(children ...*berPacket) *berPacket {
+	return berConstruct(berClassUniversal, berTagSequence, children...)
+}
+
+func berString(s string) *berPacket {
+	return berPrimitive(berClassUniversal, berTagOctetString, []byte(s))
+}
+
+func berBool(b bool) *berPacket {
+	if b {
+		return berPrimitive(berClassUniversal, berTagBoolean, []byte{0xff})
+	}
+	return berPrimitive(berClassUniversal, berTagBoolean, []byte{0x00})
+}
+
+func berIntBytes(v int64) []byte {
+	buf := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		buf = append([]byte{byte(v)}, buf...)
+	}
+	return buf
+}
+
+func berInt(v int64) *berPacket {
+	return berPrimitive(berClassUniversal, berTagInteger, berIntBytes(v))
+}
+
+func berEnum(v int64) *berPacket {
+	return berPrimitive(berClassUniversal, berTagEnumerated, berIntBytes(v))
+}
+
+func (p *berPacket) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+	header := []byte{p.class | p.tag}
+	if p.constructed {
+		header[0] |= berConstructed
+	}
+	if n := len(content); n < 0x80 {
+		header = append(header, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		header = append(header, 0x80|byte(len(length)))
+		header = append(header, length...)
+	}
+	return append(header, content...)
+}
+
+func (p *berPacket) int() int64 {
+	var v int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (p *berPacket) str() string {
+	return string(p.value)
+}
+
+func (p *berPacket) child(i int) *berPacket {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return &berPacket{}
+}
+
+func readBER(r *bufio.Reader) (*berPacket, error) {
+	buf, err := readBERBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	p, _, err := parseBER(buf)
+	return p, err
+}
+
+// readBERBytes reads exactly one encoded element from r.
+func readBERBytes(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("ldap: unsupported BER length")
+		}
+		lenBuf := make([]byte, n)
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
+			return nil, err
+		}
+		header = append(header, lenBuf...)
+		length = 0
+		for _, b := range lenBuf {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxBERLength {
+		return nil, errors.New("ldap: BER element too large")
+	}
+	buf := make([]byte, len(header)+length)
+	copy(buf, header)
+	if _, err := io.ReadFull(r, buf[len(header):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func parseBER(buf []byte) (*berPacket, []byte, error) {
+	if len(buf) < 2 {
+		return nil, nil, errors.New("ldap: short BER element")
+	}
+	p := &berPacket{
+		class:       buf[0] & 0xc0,
+		constructed: buf[0]&berConstructed != 0,
+		tag:         buf[0] & 0x1f,
+	}
+	length, offset := int(buf[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(buf) < 2+n {
+			return nil, nil, errors.New("ldap: invalid BER length")
+		}
+		length = 0
+		for _, b := range buf[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length < 0 || len(buf)-offset < length {
+		return nil, nil, errors.New("ldap: truncated BER element")
+	}
+	content, rest := buf[offset:offset+length], buf[offset+length:]
+	if !p.constructed {
+		p.value = content
+		return p, rest, nil
+	}
+	for len(content) > 0 {
+		child, more, err := parseBER(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.children = append(p.children, child)
+		content = more
+	}
+	return p, rest, nil
+}
+
+// LDAP protocol operations, application class tags
+const (
+	ldapOpBindRequest       = 0
+	ldapOpBindResponse      = 1
+	ldapOpUnbindRequest     = 2
+	ldapOpSearchRequest     = 3
+	ldapOpSearchResultEntry = 4
+	ldapOpSearchResultDone  = 5
+	ldapOpSearchResultRef   = 19
+	ldapOpExtendedRequest   = 23
+	ldapOpExtendedResponse  = 24
+
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+var errLDAPInvalidCredentials = errors.New("ldap: invalid credentials")
+
+type ldapResultError struct {
+	code    int64
+	message string
+}
+
+func (e *ldapResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.code, e.message)
+}
+
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+type ldapConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	msgID   int64
+	timeout time.Duration
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// URL.
+func dialLDAP(rawURL string, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	port := u.Port()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		d := &tls.Dialer{Config: ldapTLSConfig(tlsConfig, host)}
+		conn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(ldapTLSConfig(tlsConfig, host)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func ldapTLSConfig(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// roundTrip sends op and collects responses until one with doneTag arrives.
+func (c *ldapConn) roundTrip(op *berPacket, doneTag byte) ([]*berPacket, error) {
+	c.msgID++
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if _, err := c.conn.Write(berSequence(berInt(c.msgID), op).bytes()); err != nil {
+		return nil, err
+	}
+	var ops []*berPacket
+	for {
+		msg, err := readBER(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.children) < 2 || msg.child(0).int() != c.msgID {
+			return nil, errors.New("ldap: unexpected message")
+		}
+		resp := msg.child(1)
+		ops = append(ops, resp)
+		if resp.tag == doneTag {
+			return ops, nil
+		}
+	}
+}
+
+func ldapResult(op *berPacket) error {
+	code := op.child(0).int()
+	switch code {
+	case ldapResultSuccess:
+		return nil
+	case ldapResultInvalidCredentials:
+		return errLDAPInvalidCredentials
+	}
+	return &ldapResultError{code: code, message: op.child(2).str()}
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	op := berConstruct(berClassApplication, ldapOpBindRequest,
+		berInt(3),
+		berString(dn),
+		berPrimitive(berClassContext, 0, []byte(password)),
+	)
+	ops, err := c.roundTrip(op, ldapOpBindResponse)
+	if err != nil {
+		return err
+	}
+	return ldapResult(ops[len(ops)-1])
+}
+
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	op := berConstruct(berClassApplication, ldapOpExtendedRequest,
+		berPrimitive(berClassContext, 0, []byte(ldapStartTLSOID)),
+	)
+	ops, err := c.roundTrip(op, ldapOpExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := ldapResult(ops[len(ops)-1]); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if c.timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+func (c *ldapConn) search(base string, scope int64, filter string, attributes []string) ([]ldapEntry, error) {
+	f, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := berSequence()
+	for _, a := range attributes {
+		attrs.children = append(attrs.children, berString(a))
+	}
+	op := berConstruct(berClassApplication, ldapOpSearchRequest,
+		berString(base),
+		berEnum(scope),
+		berEnum(0), // never deref aliases
+		berInt(0),
+		berInt(int64(c.timeout/time.Second)),
+		berBool(false),
+		f,
+		attrs,
+	)
+	ops, err := c.roundTrip(op, ldapOpSearchResultDone)
+	if err != nil {
+		return nil, err
+	}
+	if err := ldapResult(ops[len(ops)-1]); err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for _, op := range ops {
+		if op.tag != ldapOpSearchResultEntry {
+			continue
+		}
+		entry := ldapEntry{DN: op.child(0).str(), Attributes: map[string][]string{}}
+		for _, attr := range op.child(1).children {
+			name := strings.ToLower(attr.child(0).str())
+			for _, v := range attr.child(1).children {
+				entry.Attributes[name] = append(entry.Attributes[name], v.str())
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *ldapConn) close() {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.msgID++
+	c.conn.Write(berSequence(berInt(c.msgID), berPrimitive(berClassApplication, ldapOpUnbindRequest, nil)).bytes())
+	c.conn.Close()
+}
+
+/*
+compileLDAPFilter encodes the string form of a search filter (RFC 4515).
+Supported are and, or, not, equality, presence and substring filters:
+
+	(&(objectClass=person)(|(uid=alice)(mail=alice@*)))
+*/
+func compileLDAPFilter(filter string) (*berPacket, error) {
+	p, rest, err := parseLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: trailing data in filter %q", filter)
+	}
+	return p, nil
+}
+
+func parseLDAPFilter(s string) (*berPacket, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: invalid filter %q", s)
+	}
+	s = s[1:]
+	switch s[0] {
+	case '&', '|':
+		tag := byte(0)
+		if s[0] == '|' {
+			tag = 1
+		}
+		set := berConstruct(berClassContext, tag)
+		s = s[1:]
+		for len(s) > 0 && s[0] == '(' {
+			child, rest, err := parseLDAPFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			set.children = append(set.children, child)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		return set, s[1:], nil
+	case '!':
+		child, rest, err := parseLDAPFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		return berConstruct(berClassContext, 2, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.New("ldap: unterminated filter")
+	}
+	item, rest := s[:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, "", fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if value == "*" {
+		return berPrimitive(berClassContext, 7, []byte(attr)), rest, nil
+	}
+	if !strings.Contains(value, "*") {
+		v, err := unescapeLDAPFilterValue(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return berConstruct(berClassContext, 3, berString(attr), berString(v)), rest, nil
+	}
+
+	parts := strings.Split(value, "*")
+	subs := berSequence()
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := unescapeLDAPFilterValue(part)
+		if err != nil {
+			return nil, "", err
+		}
+		tag := byte(1) // any
+		if i == 0 {
+			tag = 0 // initial
+		} else if i == len(parts)-1 {
+			tag = 2 // final
+		}
+		subs.children = append(subs.children, berPrimitive(berClassContext, tag, []byte(v)))
+	}
+	return berConstruct(berClassContext, 4, berString(attr), subs), rest, nil
+}
+
+func unescapeLDAPFilterValue(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		b.WriteByte(byte(v))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// escapeLDAPFilterValue escapes a value to be inserted in a search filter (RFC 4515).
+func escapeLDAPFilterValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// escapeLDAPDN escapes a value to be used as an attribute value in a DN (RFC 4514).
+func escapeLDAPDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(s)-1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
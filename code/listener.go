@@ -0,0 +1,175 @@
+package socks5
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Listener is one address a server accepts connections on, with its own
+// configuration. Listeners of a server share its resolver, stats and MaxConnections.
+//
+// A typical setup offers no authentication on loopback and passwords elsewhere:
+//
+//	server := &socks5.SOCKS5Server{Listeners: []socks5.Listener{
+//		{Label: "local", Address: "127.0.0.1:1080", Config: &socks5.Config{AuthMethod: socks5.MethodNoAuth}},
+//		{Label: "public", Address: ":1081", Config: passwordConfig},
+//	}}
+type Listener struct {
+	// Label names the listener in logs and stats
+	Label string
+	// Network is tcp by default, tcp4, tcp6 or unix
+	Network string
+	// Address is host:port, or the socket path for unix
+	Address    string
+	UnixSocket UnixSocketOptions
+	// TrustedProxies are the CIDRs, IPs or "unix" whose connections start with a PROXY protocol header
+	TrustedProxies []string
+	// TLSConfig, if set, serves SOCKS over TLS
+	TLSConfig *tls.Config
+	// WebSocketPath, if set, serves SOCKS inside WebSocket connections on this HTTP path
+	WebSocketPath string
+	// Config is the authentication and rules of this listener, the server's Config if nil
+	Config *Config
+
+	// Inherited, if set, is an open listener Run serves instead of listening on
+	// Network and Address, such as one passed on by a parent process or systemd
+	Inherited net.Listener
+}
+
+// listeners returns the Listeners, or the default listener if there are none
+func (s *SOCKS5Server) listeners() []Listener {
+	if len(s.Listeners) == 0 {
+		return []Listener{s.defaultListener()}
+	}
+	return s.Listeners
+}
+
+// defaultListener is the single listener described by the server's own fields
+func (s *SOCKS5Server) defaultListener() Listener {
+	address := s.Address
+	if address == "" {
+		address = fmt.Sprintf("%s:%d", s.IP, s.Port)
+	}
+	return Listener{
+		Network:        s.Network,
+		Address:        address,
+		UnixSocket:     s.UnixSocket,
+		TrustedProxies: s.TrustedProxies,
+		TLSConfig:      s.TLSConfig,
+		WebSocketPath:  s.WebSocketPath,
+		Config:         s.Config,
+	}
+}
+
+func (l *Listener) config(s *SOCKS5Server) *Config {
+	if l.Config != nil {
+		return l.Config
+	}
+	return s.Config
+}
+
+func (l *Listener) name() string {
+	if l.Label != "" {
+		return l.Label
+	}
+	return l.Address
+}
+
+// Listen opens the listener's Network and Address.
+func (l *Listener) Listen() (net.Listener, error) {
+	network := l.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if network == "unix" {
+		return ListenUnix(l.Address, l.UnixSocket)
+	}
+	return net.Listen(network, l.Address)
+}
+
+// Stats counts the connections of a server or one of its listeners.
+type Stats struct {
+	// Accepted connections since the server started
+	Accepted uint64 `json:"accepted"`
+	// Active connections being served now
+	Active int64 `json:"active"`
+	// Refused connections closed at once because MaxConnections were open
+	Refused uint64 `json:"refused"`
+	// Panics recovered while serving connections
+	Panics uint64 `json:"panics"`
+}
+
+type serverStats struct {
+	mu        sync.Mutex
+	total     Stats
+	listeners map[string]*Stats
+}
+
+// open counts a new connection unless max connections are already open
+func (s *serverStats) open(label string, max int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[string]*Stats)
+	}
+	ls := s.listeners[label]
+	if ls == nil {
+		ls = &Stats{}
+		s.listeners[label] = ls
+	}
+	if max > 0 && s.total.Active >= int64(max) {
+		s.total.Refused++
+		ls.Refused++
+		return false
+	}
+	s.total.Accepted++
+	s.total.Active++
+	ls.Accepted++
+	ls.Active++
+	return true
+}
+
+func (s *serverStats) close(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total.Active--
+	s.listeners[label].Active--
+}
+
+// panicked counts a panic recovered on an open connection
+func (s *serverStats) panicked(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total.Panics++
+	s.listeners[label].Panics++
+}
+
+// Stats returns the connection counts of all listeners together.
+func (s *SOCKS5Server) Stats() Stats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	return s.stats.total
+}
+
+// StatsByListener returns the connection counts of every listener by label.
+func (s *SOCKS5Server) StatsByListener() map[string]Stats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	stats := make(map[string]Stats, len(s.stats.listeners))
+	for label, ls := range s.stats.listeners {
+		stats[label] = *ls
+	}
+	return stats
+}
+
+// ListenerStats returns the connection counts of the listener with label.
+func (s *SOCKS5Server) ListenerStats(label string) Stats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	if ls := s.stats.listeners[label]; ls != nil {
+		return *ls
+	}
+	return Stats{}
+}
@@ -0,0 +1,93 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startListener serves l of s on a loopback port with serveConn and returns its address.
+func startListener(t *testing.T, s *SOCKS5Server, l *Listener) string {
+	t.Helper()
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nl.Close() })
+	go func() {
+		for {
+			conn, err := nl.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, l)
+		}
+	}()
+	return nl.Addr().String()
+}
+
+func TestListenerConfig(t *testing.T) {
+	echo := startEchoServer(t)
+	s := &SOCKS5Server{Config: &Config{AuthMethod: MethodNoAuth}}
+	local := startListener(t, s, &Listener{Label: "local"})
+	public := startListener(t, s, &Listener{Label: "public", Config: &Config{
+		AuthMethod:      MethodPassword,
+		PasswordChecker: func(u, p string) bool { return u == "admin" && p == "123456" },
+	}})
+
+	d := &Dialer{ProxyAddress: local, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+
+	d = &Dialer{ProxyAddress: public, Timeout: 5 * time.Second}
+	if _, err := d.Dial("tcp", echo.String()); err != ErrNoAcceptableMethod {
+		t.Fatalf("should get error %s but got %v", ErrNoAcceptableMethod, err)
+	}
+	d.Username, d.Password = "admin", "123456"
+	conn, err = d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+
+	if got := s.ListenerStats("local").Accepted; got != 1 {
+		t.Fatalf("want 1 connection accepted on local but got %d", got)
+	}
+	if got := s.ListenerStats("public").Accepted; got != 2 {
+		t.Fatalf("want 2 connections accepted on public but got %d", got)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	echo := startEchoServer(t)
+	s := &SOCKS5Server{Config: &Config{AuthMethod: MethodNoAuth}, MaxConnections: 1}
+	a := startListener(t, s, &Listener{Label: "a"})
+	b := startListener(t, s, &Listener{Label: "b"})
+
+	d := &Dialer{ProxyAddress: a, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+
+	// the limit is shared, the second listener refuses
+	refused, err := net.Dial("tcp", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	refused.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := refused.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("should get error EOF but got %v", err)
+	}
+
+	stats := s.Stats()
+	if stats.Active != 1 || stats.Refused != 1 || s.ListenerStats("b").Refused != 1 {
+		t.Fatalf("want 1 active and 1 refused but got %+v", stats)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package socks5
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCred reads the credentials of the peer process with SO_PEERCRED
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var ucred *syscall.Ucred
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, sockErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCred{}, err
+	}
+	if sockErr != nil {
+		return PeerCred{}, sockErr
+	}
+	return PeerCred{UID: ucred.Uid, GID: ucred.Gid, PID: ucred.Pid}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package socks5
+
+import "net"
+
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, ErrPeerCredNotSupported
+}
@@ -0,0 +1,463 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+HAProxy PROXY protocol, https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+A load balancer sends the real client and destination addresses in a header
+before the proxied stream.
+
+Version 1 is a line of text:
+
+	PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+	PROXY UNKNOWN\r\n
+
+Version 2 is binary:
+
+	+---------------+---------+--------+--------+-----------+------+
+	|   SIGNATURE   | VER_CMD |  FAM   |  LEN   | ADDRESSES | TLVs |
+	+---------------+---------+--------+--------+-----------+------+
+	|      12       |    1    |   1    |   2    | 12/36/216 | Var. |
+	+---------------+---------+--------+--------+-----------+------+
+
+	o  VER_CMD  X'20' LOCAL, X'21' PROXY
+	o  FAM      X'11' TCP over IPv4, X'21' TCP over IPv6, X'31' Unix stream
+	o  LEN      length of ADDRESSES and TLVs in network octet order
+	o  TLVs     1 octet type, 2 octets length, value
+*/
+
+const (
+	ProxyTLVALPN      = 0x01
+	ProxyTLVAuthority = 0x02
+	ProxyTLVCRC32C    = 0x03
+	ProxyTLVNoop      = 0x04
+	ProxyTLVUniqueID  = 0x05
+	ProxyTLVSSL       = 0x20
+	ProxyTLVNetNS     = 0x30
+)
+
+const (
+	proxyV2CmdLocal = 0x20
+	proxyV2CmdProxy = 0x21
+
+	proxyV2FamUnspec = 0x00
+	proxyV2FamTCP4   = 0x11
+	proxyV2FamUDP4   = 0x12
+	proxyV2FamTCP6   = 0x21
+	proxyV2FamUDP6   = 0x22
+	proxyV2FamUnix   = 0x31
+	proxyV2FamUnixDG = 0x32
+
+	proxyV1MaxLength   = 107
+	proxyV2HeaderLen   = 16
+	proxyV2UnixAddrLen = 108
+
+	proxyHeaderTimeout = 5 * time.Second
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	ErrNoProxyHeader      = errors.New("proxy protocol header missing")
+	ErrInvalidProxyHeader = errors.New("proxy protocol header malformed")
+)
+
+// ProxyHeader is a PROXY protocol header.
+type ProxyHeader struct {
+	// Version is 1 or 2
+	Version byte
+	// Local headers come from the load balancer itself, such as health checks,
+	// and carry no addresses
+	Local bool
+	// Source is the client address, Destination the address it connected to
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are the version 2 extensions in the order received
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is a version 2 type-length-value extension.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first TLV of type typ.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (h *ProxyHeader) String() string {
+	if h.Local {
+		return fmt.Sprintf("PROXY v%d LOCAL", h.Version)
+	}
+	return fmt.Sprintf("PROXY v%d %s -> %s", h.Version, h.Source, h.Destination)
+}
+
+// ReadProxyHeader reads a version 1 or 2 header from the start of r.
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		return readProxyV2(r)
+	}
+	return nil, ErrNoProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrInvalidProxyHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, ErrNoProxyHeader
+	}
+
+	h := &ProxyHeader{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		// the rest of the line is ignored
+		h.Local = true
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, ErrInvalidProxyHeader
+	}
+	src, err := parseProxyV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseProxyV1Addr(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrInvalidProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	// ports are written without leading zeros
+	if err != nil || strconv.FormatUint(p, 10) != port {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed, err := r.Peek(proxyV2HeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, ErrNoProxyHeader
+	}
+	header := make([]byte, proxyV2HeaderLen+int(binary.BigEndian.Uint16(fixed[14:16])))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, fam, payload := header[12], header[13], header[proxyV2HeaderLen:]
+
+	h := &ProxyHeader{Version: 2}
+	switch verCmd {
+	case proxyV2CmdLocal:
+		h.Local = true
+	case proxyV2CmdProxy:
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	var addrLen int
+	switch fam {
+	case proxyV2FamUnspec:
+	case proxyV2FamTCP4, proxyV2FamUDP4:
+		addrLen = 2*net.IPv4len + 4
+	case proxyV2FamTCP6, proxyV2FamUDP6:
+		addrLen = 2*net.IPv6len + 4
+	case proxyV2FamUnix, proxyV2FamUnixDG:
+		addrLen = 2 * proxyV2UnixAddrLen
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+	if len(payload) < addrLen {
+		return nil, ErrInvalidProxyHeader
+	}
+	// a LOCAL header may still carry addresses, they are skipped
+	if !h.Local {
+		h.Source, h.Destination = parseProxyV2Addrs(fam, payload[:addrLen])
+	}
+
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, ErrInvalidProxyHeader
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, ErrInvalidProxyHeader
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		if tlvs[0] == ProxyTLVCRC32C {
+			if n != 4 || !proxyV2ChecksumValid(header, len(header)-len(tlvs)+3) {
+				return nil, ErrInvalidProxyHeader
+			}
+		}
+		tlvs = tlvs[3+n:]
+	}
+	return h, nil
+}
+
+// proxyV2ChecksumValid checks the CRC32c of header, computed with its checksum field at offset zeroed
+func proxyV2ChecksumValid(header []byte, offset int) bool {
+	want := binary.BigEndian.Uint32(header[offset:])
+	zeroed := append([]byte(nil), header...)
+	copy(zeroed[offset:offset+4], []byte{0, 0, 0, 0})
+	return crc32.Checksum(zeroed, crc32.MakeTable(crc32.Castagnoli)) == want
+}
+
+func parseProxyV2Addrs(fam byte, b []byte) (net.Addr, net.Addr) {
+	switch fam {
+	case proxyV2FamTCP4, proxyV2FamTCP6, proxyV2FamUDP4, proxyV2FamUDP6:
+		n := (len(b) - 4) / 2
+		srcIP, dstIP := net.IP(b[:n]), net.IP(b[n:2*n])
+		srcPort := int(binary.BigEndian.Uint16(b[2*n:]))
+		dstPort := int(binary.BigEndian.Uint16(b[2*n+2:]))
+		if fam == proxyV2FamUDP4 || fam == proxyV2FamUDP6 {
+			return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		}
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+	case proxyV2FamUnix, proxyV2FamUnixDG:
+		network := "unix"
+		if fam == proxyV2FamUnixDG {
+			network = "unixgram"
+		}
+		path := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				return string(b[:i])
+			}
+			return string(b)
+		}
+		return &net.UnixAddr{Name: path(b[:proxyV2UnixAddrLen]), Net: network},
+			&net.UnixAddr{Name: path(b[proxyV2UnixAddrLen:]), Net: network}
+	}
+	return nil, nil
+}
+
+// WriteTo writes the header in its Version, as a single Write.
+// Addresses that cannot be expressed, such as Unix sockets in version 1,
+// are sent as a local header.
+func (h *ProxyHeader) WriteTo(w io.Writer) (int64, error) {
+	var buf []byte
+	switch h.Version {
+	case 1:
+		buf = h.appendV1(nil)
+	case 2:
+		buf = h.appendV2(nil)
+	default:
+		return 0, fmt.Errorf("proxy protocol version %d not supported", h.Version)
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// proxyTCPAddrs returns the addresses as TCP addresses of the same family, ok false if they are not TCP
+func (h *ProxyHeader) proxyTCPAddrs() (src, dst *net.TCPAddr, ipv4 bool, ok bool) {
+	src, srcOK := h.Source.(*net.TCPAddr)
+	dst, dstOK := h.Destination.(*net.TCPAddr)
+	if h.Local || !srcOK || !dstOK {
+		return nil, nil, false, false
+	}
+	return src, dst, src.IP.To4() != nil && dst.IP.To4() != nil, true
+}
+
+func (h *ProxyHeader) appendV1(buf []byte) []byte {
+	src, dst, ipv4, ok := h.proxyTCPAddrs()
+	if !ok {
+		return append(buf, "PROXY UNKNOWN\r\n"...)
+	}
+	if ipv4 {
+		return fmt.Appendf(buf, "PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port)
+	}
+	// mixed families are both sent as IPv6
+	return fmt.Appendf(buf, "PROXY TCP6 %s %s %d %d\r\n", src.IP.To16(), dst.IP.To16(), src.Port, dst.Port)
+}
+
+func (h *ProxyHeader) appendV2(buf []byte) []byte {
+	buf = append(buf, proxyV2Signature...)
+	src, dst, ipv4, ok := h.proxyTCPAddrs()
+	var addrs []byte
+	switch {
+	case !ok:
+		buf = append(buf, proxyV2CmdLocal, proxyV2FamUnspec)
+	case ipv4:
+		buf = append(buf, proxyV2CmdProxy, proxyV2FamTCP4)
+		addrs = append(append(addrs, src.IP.To4()...), dst.IP.To4()...)
+	default:
+		buf = append(buf, proxyV2CmdProxy, proxyV2FamTCP6)
+		addrs = append(append(addrs, src.IP.To16()...), dst.IP.To16()...)
+	}
+	if ok {
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(src.Port))
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(dst.Port))
+	}
+	for _, tlv := range h.TLVs {
+		addrs = append(addrs, tlv.Type)
+		addrs = binary.BigEndian.AppendUint16(addrs, uint16(len(tlv.Value)))
+		addrs = append(addrs, tlv.Value...)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addrs)))
+	return append(buf, addrs...)
+}
+
+// proxyListener reads PROXY headers on connections from trusted addresses.
+// Connections from anywhere else are served with their own addresses.
+type proxyListener struct {
+	net.Listener
+	trusted   []*net.IPNet
+	trustUnix bool
+}
+
+// newProxyListener trusts the CIDRs and IPs in trusted, and Unix socket peers for "unix"
+func newProxyListener(l net.Listener, trusted []string) (*proxyListener, error) {
+	pl := &proxyListener{Listener: l}
+	for _, t := range trusted {
+		if t == "unix" {
+			pl.trustUnix = true
+			continue
+		}
+		if !strings.Contains(t, "/") {
+			if ip := net.ParseIP(t); ip != nil && ip.To4() != nil {
+				t += "/32"
+			} else {
+				t += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", t, err)
+		}
+		pl.trusted = append(pl.trusted, ipNet)
+	}
+	return pl, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	// the header is read on first use, so a slow client does not hold up Accept
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *proxyListener) trusts(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		for _, ipNet := range l.trusted {
+			if ipNet.Contains(a.IP) {
+				return true
+			}
+		}
+	case *net.UnixAddr:
+		return l.trustUnix
+	}
+	return false
+}
+
+// proxyConn is a connection from a trusted proxy, with the addresses of its PROXY header.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	header *ProxyHeader
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.header, c.err = ReadProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// sendProxyHeader tells the target the real client address when configured,
+// dst is the destination connected to, not known for a domain left to an upstream
+func sendProxyHeader(config *Config, targetConn net.Conn, clientAddr, dst net.Addr) error {
+	if config.SendProxyHeader == 0 {
+		return nil
+	}
+	h := &ProxyHeader{
+		Version:     config.SendProxyHeader,
+		Source:      clientAddr,
+		Destination: dst,
+	}
+	_, err := h.WriteTo(targetConn)
+	return err
+}
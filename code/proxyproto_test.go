@@ -0,0 +1,310 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyV2(verCmd, fam byte, payload ...byte) []byte {
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, verCmd, fam)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// withCRC32C appends a CRC32c TLV covering the whole header
+func withCRC32C(header []byte, corrupt bool) []byte {
+	header = append(header, ProxyTLVCRC32C, 0, 4, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], binary.BigEndian.Uint16(header[14:])+7)
+	sum := crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli))
+	if corrupt {
+		sum++
+	}
+	binary.BigEndian.PutUint32(header[len(header)-4:], sum)
+	return header
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tcp4 := []byte{192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x01, 0xbb}
+	unixAddrs := make([]byte, 2*proxyV2UnixAddrLen)
+	copy(unixAddrs, "/run/client.sock")
+	copy(unixAddrs[proxyV2UnixAddrLen:], "/run/socks.sock")
+
+	tests := []struct {
+		name   string
+		input  []byte
+		header *ProxyHeader
+		err    error
+	}{
+		{
+			name:  "v1 tcp4",
+			input: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			header: &ProxyHeader{Version: 1,
+				Source:      &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324},
+				Destination: &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}},
+		},
+		{
+			name:  "v1 tcp6",
+			input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			header: &ProxyHeader{Version: 1,
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+		},
+		{
+			name:   "v1 unknown",
+			input:  []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"),
+			header: &ProxyHeader{Version: 1, Local: true},
+		},
+		{
+			name:  "v1 family mismatch",
+			input: []byte("PROXY TCP4 2001:db8::1 192.168.0.11 56324 443\r\n"),
+			err:   ErrInvalidProxyHeader,
+		},
+		{
+			name:  "v1 leading zero port",
+			input: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 056324 443\r\n"),
+			err:   ErrInvalidProxyHeader,
+		},
+		{
+			name:  "v1 too long",
+			input: []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"),
+			err:   ErrInvalidProxyHeader,
+		},
+		{
+			name:  "v2 tcp4",
+			input: proxyV2(proxyV2CmdProxy, proxyV2FamTCP4, tcp4...),
+			header: &ProxyHeader{Version: 2,
+				Source:      &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 56324},
+				Destination: &net.TCPAddr{IP: net.IP{192, 168, 0, 11}, Port: 443}},
+		},
+		{
+			name:  "v2 tcp4 with tlvs",
+			input: proxyV2(proxyV2CmdProxy, proxyV2FamTCP4, append(tcp4, ProxyTLVAuthority, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', ProxyTLVNoop, 0, 0)...),
+			header: &ProxyHeader{Version: 2,
+				Source:      &net.TCPAddr{IP: net.IP{192, 168, 0, 1}, Port: 56324},
+				Destination: &net.TCPAddr{IP: net.IP{192, 168, 0, 11}, Port: 443},
+				TLVs:        []ProxyTLV{{Type: ProxyTLVAuthority, Value: []byte("example.com")}, {Type: ProxyTLVNoop, Value: []byte{}}}},
+		},
+		{
+			name:   "v2 local",
+			input:  proxyV2(proxyV2CmdLocal, proxyV2FamUnspec),
+			header: &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name:  "v2 unix",
+			input: proxyV2(proxyV2CmdProxy, proxyV2FamUnix, unixAddrs...),
+			header: &ProxyHeader{Version: 2,
+				Source:      &net.UnixAddr{Name: "/run/client.sock", Net: "unix"},
+				Destination: &net.UnixAddr{Name: "/run/socks.sock", Net: "unix"}},
+		},
+		{
+			name:  "v2 truncated addresses",
+			input: proxyV2(proxyV2CmdProxy, proxyV2FamTCP4, tcp4[:8]...),
+			err:   ErrInvalidProxyHeader,
+		},
+		{
+			name:  "v2 truncated tlv",
+			input: proxyV2(proxyV2CmdProxy, proxyV2FamTCP4, append(tcp4, ProxyTLVAuthority, 0, 11, 'e')...),
+			err:   ErrInvalidProxyHeader,
+		},
+		{
+			name:  "v2 bad checksum",
+			input: withCRC32C(proxyV2(proxyV2CmdProxy, proxyV2FamTCP4, tcp4...), true),
+			err:   ErrInvalidProxyHeader,
+		},
+		{
+			name:  "no header",
+			input: []byte{SOCKS5Version, 1, MethodNoAuth},
+			err:   ErrNoProxyHeader,
+		},
+	}
+
+	for _, test := range tests {
+		h, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(test.input)))
+		if err != test.err {
+			t.Fatalf("%s: want error %v but got %v", test.name, test.err, err)
+		}
+		if err == nil && !reflect.DeepEqual(h, test.header) {
+			t.Fatalf("%s: want %+v but got %+v", test.name, test.header, h)
+		}
+	}
+
+	h, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(withCRC32C(proxyV2(proxyV2CmdProxy, proxyV2FamTCP4, tcp4...), false))))
+	if err != nil {
+		t.Fatalf("valid checksum: should get error nil but got %s", err)
+	}
+	if _, ok := h.TLV(ProxyTLVCRC32C); !ok {
+		t.Fatal("valid checksum: should keep the crc32c tlv")
+	}
+}
+
+func TestProxyHeaderWriteTo(t *testing.T) {
+	tests := []*ProxyHeader{
+		{Version: 1, Source: &net.TCPAddr{IP: net.IP{10, 0, 0, 1}, Port: 1234}, Destination: &net.TCPAddr{IP: net.IP{10, 0, 0, 2}, Port: 80}},
+		{Version: 1, Source: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}},
+		{Version: 2, Source: &net.TCPAddr{IP: net.IP{10, 0, 0, 1}, Port: 1234}, Destination: &net.TCPAddr{IP: net.IP{10, 0, 0, 2}, Port: 80},
+			TLVs: []ProxyTLV{{Type: ProxyTLVUniqueID, Value: []byte("abc")}}},
+		{Version: 2, Source: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}},
+		{Version: 2, Local: true},
+	}
+	for _, want := range tests {
+		var buf bytes.Buffer
+		if _, err := want.WriteTo(&buf); err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		got, err := ReadProxyHeader(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		if got.String() != want.String() || !reflect.DeepEqual(got.TLVs, want.TLVs) {
+			t.Fatalf("want %s but got %s", want, got)
+		}
+	}
+
+	// a unix client cannot be expressed in version 1
+	var buf bytes.Buffer
+	h := &ProxyHeader{Version: 1, Source: &net.UnixAddr{Name: "/run/a.sock", Net: "unix"}, Destination: &net.TCPAddr{IP: net.IP{10, 0, 0, 2}, Port: 80}}
+	h.WriteTo(&buf)
+	if buf.String() != "PROXY UNKNOWN\r\n" {
+		t.Fatalf("want unknown header but got %q", buf.String())
+	}
+}
+
+func TestProxyProtocolInbound(t *testing.T) {
+	echo := startEchoServer(t)
+	// deny the address announced by the load balancer only
+	clients := make(chan net.Addr, 1)
+	config := &Config{AuthMethod: MethodNoAuth}
+	config.Rules = ruleFunc(func(req *Request) (bool, time.Time) {
+		clients <- req.ClientAddr
+		return !strings.HasPrefix(req.ClientAddr.String(), "203.0.113.7:"), time.Time{}
+	})
+	s := &SOCKS5Server{Config: config}
+
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nl.Close() })
+	pl, err := newProxyListener(nl, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	l := &Listener{}
+	go func() {
+		for {
+			conn, err := pl.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, l)
+		}
+	}()
+
+	connect := func(header string) error {
+		conn, err := net.Dial("tcp", nl.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, header)
+		d := &Dialer{}
+		return d.negotiate(conn, CmdConnect, AddrFromIP(echo.IP, uint16(echo.Port)))
+	}
+
+	if err := connect("PROXY TCP4 198.51.100.1 127.0.0.1 40000 1080\r\n"); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if got := (<-clients).String(); got != "198.51.100.1:40000" {
+		t.Fatalf("want client 198.51.100.1:40000 but got %s", got)
+	}
+	err = connect("PROXY TCP4 203.0.113.7 127.0.0.1 40000 1080\r\n")
+	if e, ok := err.(*ReplyError); !ok || e.Reply != ReplyConnectionNotAllowed {
+		t.Fatalf("should get connection not allowed but got %v", err)
+	}
+	<-clients
+}
+
+type ruleFunc func(req *Request) (bool, time.Time)
+
+func (f ruleFunc) Allow(req *Request) (bool, time.Time) {
+	return f(req)
+}
+
+func TestSendProxyHeader(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	headers := make(chan *ProxyHeader, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		h, _ := ReadProxyHeader(bufio.NewReader(conn))
+		headers <- h
+	}()
+
+	s := &SOCKS5Server{}
+	client := &net.TCPAddr{IP: net.IP{198, 51, 100, 1}, Port: 40000}
+	targetAddr := target.Addr().(*net.TCPAddr)
+	conn, err := s.dial(&Config{SendProxyHeader: 2}, &Request{ClientAddr: client, Dst: AddrFromIP(targetAddr.IP, uint16(targetAddr.Port))})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	h := <-headers
+	if h == nil || h.Source.String() != client.String() || h.Destination.String() != target.Addr().String() {
+		t.Fatalf("want header from %s to %s but got %+v", client, target.Addr(), h)
+	}
+}
+
+func TestSendProxyHeaderUpstream(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	headers := make(chan *ProxyHeader, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		h, _ := ReadProxyHeader(bufio.NewReader(conn))
+		headers <- h
+	}()
+
+	upstream := &Dialer{ProxyAddress: startTestServer(t, &Config{AuthMethod: MethodNoAuth}), Timeout: 5 * time.Second}
+	config := &Config{SendProxyHeader: 2, Router: &Router{
+		Routes:    []Route{{Name: "upstream", Action: RouteUpstream, Upstream: "upstream"}},
+		Upstreams: map[string]ContextDialer{"upstream": upstream},
+	}}
+	s := &SOCKS5Server{Resolver: noDNS}
+	client := &net.TCPAddr{IP: net.IP{198, 51, 100, 1}, Port: 40000}
+	targetAddr := target.Addr().(*net.TCPAddr)
+	// the destination is the target's address, not the upstream's
+	req := &Request{ClientAddr: client, Dst: Addr{FQDN: "target.example", Port: uint16(targetAddr.Port)}, DstIPs: []net.IP{targetAddr.IP}}
+	conn, err := s.dial(config, req)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	h := <-headers
+	if h == nil || h.Source.String() != client.String() || h.Destination.String() != target.Addr().String() {
+		t.Fatalf("want header from %s to %s but got %+v", client, target.Addr(), h)
+	}
+}
@@ -0,0 +1,57 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// a failing Accept is retried after a delay doubling from acceptDelayMin up to acceptDelayMax
+const (
+	acceptDelayMin = 5 * time.Millisecond
+	acceptDelayMax = time.Second
+)
+
+// PanicError is a panic recovered while serving a connection, given to Config.ErrorHandler.
+type PanicError struct {
+	// Listener is the label or address of the listener the connection came in on
+	Listener   string
+	RemoteAddr net.Addr
+	// Value is what panic was called with and Stack the goroutine's stack trace
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: panic serving %s: %v", e.Listener, e.RemoteAddr, e.Value)
+}
+
+// acceptDelay returns the delay after a failing Accept following one of delay
+func acceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return acceptDelayMin
+	}
+	if delay *= 2; delay > acceptDelayMax {
+		return acceptDelayMax
+	}
+	return delay
+}
+
+// retryAccept reports whether a failing Accept is worth retrying, such as when out of
+// file descriptors or when a client reset its connection before it was accepted
+func retryAccept(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ECONNABORTED)
+}
+
+// handleError logs err and passes it on to the ErrorHandler, c may be nil
+func (c *Config) handleError(err error) {
+	if pe, ok := err.(*PanicError); ok {
+		log.Printf("%s\n%s", pe, pe.Stack)
+	}
+	if c != nil && c.ErrorHandler != nil {
+		c.ErrorHandler(err)
+	}
+}
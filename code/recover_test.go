@@ -0,0 +1,160 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyListener fails the first accepts as if out of file descriptors, then fails with err
+type flakyListener struct {
+	net.Listener
+	mu       sync.Mutex
+	emfile   int
+	accepted int
+	err      error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.emfile > 0 {
+		l.emfile--
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	if l.err != nil && l.accepted > 0 {
+		return nil, l.err
+	}
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted++
+	}
+	return conn, err
+}
+
+func TestAcceptDelay(t *testing.T) {
+	var delay time.Duration
+	for _, want := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		if delay = acceptDelay(delay); delay != want {
+			t.Fatalf("want delay %s but got %s", want, delay)
+		}
+	}
+	if got := acceptDelay(800 * time.Millisecond); got != time.Second {
+		t.Fatalf("want delay capped at 1s but got %s", got)
+	}
+}
+
+func TestRetryAccept(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}, true},
+		{&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.ENFILE)}, true},
+		{&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.ECONNABORTED)}, true},
+		{&net.OpError{Op: "accept", Net: "tcp", Err: os.ErrDeadlineExceeded}, false},
+		{errors.New("listener broken"), false},
+	} {
+		if got := retryAccept(test.err); got != test.want {
+			t.Errorf("%v: want retry %v but got %v", test.err, test.want, got)
+		}
+	}
+}
+
+func TestAcceptErrors(t *testing.T) {
+	target := startEchoServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := errors.New("listener broken")
+	flaky := &flakyListener{Listener: ln, emfile: 3, err: broken}
+	var handled []error
+	var mu sync.Mutex
+	config := &Config{AuthMethod: MethodNoAuth, ErrorHandler: func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, err)
+	}}
+	s := &SOCKS5Server{Config: config}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(flaky) }()
+
+	// served after backing off from the failures
+	d := &Dialer{ProxyAddress: ln.Addr().String(), Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+
+	// other errors end serving, without a connection to log
+	select {
+	case err := <-done:
+		if err != broken {
+			t.Fatalf("want error %s but got %v", broken, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serving did not end on a permanent accept error")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 4 || !errors.Is(handled[0], syscall.EMFILE) || !errors.Is(handled[3], broken) {
+		t.Fatalf("want 3 EMFILE errors and the permanent one handled but got %v", handled)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	target := startEchoServer(t)
+	var handled []error
+	var mu sync.Mutex
+	config := &Config{
+		AuthMethod: MethodNoAuth,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if address == "panic.test:80" {
+				panic("dial bug")
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+		ErrorHandler: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, err)
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SOCKS5Server{Config: config}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	d := &Dialer{ProxyAddress: ln.Addr().String(), Timeout: 5 * time.Second}
+	if conn, err := d.Dial("tcp", "panic.test:80"); err == nil {
+		conn.Close()
+		t.Fatal("want the panicking request to fail")
+	}
+	// the server goes on serving
+	conn, err := d.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+
+	if got := s.Stats().Panics; got != 1 {
+		t.Fatalf("want 1 panic counted but got %d", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var pe *PanicError
+	if len(handled) != 1 || !errors.As(handled[0], &pe) || pe.Value != "dial bug" || len(pe.Stack) == 0 {
+		t.Fatalf("want the panic with its stack handled but got %v", handled)
+	}
+}
@@ -0,0 +1,59 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+var ErrListenersChanged = errors.New("listeners changed, restart the server to apply")
+
+// reloadedState is what Reload swaps in for new connections
+type reloadedState struct {
+	// configs by listener name
+	configs        map[string]*Config
+	maxConnections int
+}
+
+// Reload applies the Config of next and of each of its listeners, and its
+// MaxConnections, to new connections. Sessions already established keep
+// the config they started with.
+//
+// Listeners are matched by Label, or Address if unlabelled. Addresses, TLS and
+// other listener settings are not reloaded, a next server with different
+// listeners returns ErrListenersChanged. On any error the running config is kept.
+func (s *SOCKS5Server) Reload(next *SOCKS5Server) error {
+	running, listeners := s.listeners(), next.listeners()
+	if len(running) != len(listeners) {
+		return fmt.Errorf("%w: %d listeners instead of %d", ErrListenersChanged, len(listeners), len(running))
+	}
+	state := &reloadedState{
+		configs:        make(map[string]*Config, len(listeners)),
+		maxConnections: next.MaxConnections,
+	}
+	for i := range listeners {
+		config := listeners[i].config(next)
+		if err := initConfig(config); err != nil {
+			return fmt.Errorf("%s: %w", listeners[i].name(), err)
+		}
+		state.configs[listeners[i].name()] = config
+	}
+	for i := range running {
+		if state.configs[running[i].name()] == nil {
+			return fmt.Errorf("%w: %s not found", ErrListenersChanged, running[i].name())
+		}
+	}
+	s.reloaded.Store(state)
+	log.Printf("configuration reloaded")
+	return nil
+}
+
+// current returns the config and connection limit for a new connection on l
+func (s *SOCKS5Server) current(l *Listener) (*Config, int) {
+	if state := s.reloaded.Load(); state != nil {
+		if config := state.configs[l.name()]; config != nil {
+			return config, state.maxConnections
+		}
+	}
+	return l.config(s), s.MaxConnections
+}
@@ -0,0 +1,63 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	echo := startEchoServer(t)
+	users := func(name, password string) *Config {
+		return &Config{AuthMethod: MethodPassword, PasswordChecker: func(u, p string) bool {
+			return u == name && p == password
+		}}
+	}
+	s := &SOCKS5Server{Listeners: []Listener{{Label: "public", Config: users("admin", "123456")}}}
+	addr := startListener(t, s, &s.Listeners[0])
+
+	d := &Dialer{ProxyAddress: addr, Username: "admin", Password: "123456", Timeout: 5 * time.Second}
+	tunnel, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer tunnel.Close()
+
+	// a failed reload keeps the running config
+	broken := &SOCKS5Server{Listeners: []Listener{{Label: "public", Config: &Config{AuthMethod: MethodPassword}}}}
+	if err := s.Reload(broken); !errors.Is(err, ErrPasswordCheckerNotSet) {
+		t.Fatalf("should get error %s but got %v", ErrPasswordCheckerNotSet, err)
+	}
+	renamed := &SOCKS5Server{Listeners: []Listener{{Label: "other", Config: users("bob", "secret")}}}
+	if err := s.Reload(renamed); !errors.Is(err, ErrListenersChanged) {
+		t.Fatalf("should get error %s but got %v", ErrListenersChanged, err)
+	}
+	if conn, err := d.Dial("tcp", echo.String()); err != nil {
+		t.Fatalf("old config: should get error nil but got %s", err)
+	} else {
+		conn.Close()
+	}
+
+	next := &SOCKS5Server{Listeners: []Listener{{Label: "public", Config: users("bob", "secret")}}}
+	if err := s.Reload(next); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if _, err := d.Dial("tcp", echo.String()); err != ErrPasswordAuthFailure {
+		t.Fatalf("removed user: should get error %s but got %v", ErrPasswordAuthFailure, err)
+	}
+	d.Username, d.Password = "bob", "secret"
+	if conn, err := d.Dial("tcp", echo.String()); err != nil {
+		t.Fatalf("added user: should get error nil but got %s", err)
+	} else {
+		conn.Close()
+	}
+
+	// the tunnel established before the reload keeps running
+	tunnel.SetDeadline(time.Now().Add(5 * time.Second))
+	tunnel.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(tunnel, got); err != nil || string(got) != "ping" {
+		t.Fatalf("want echo ping but got %q, %v", got, err)
+	}
+}
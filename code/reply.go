@@ -4,6 +4,8 @@ import (
 	"errors"
 	"io"
 	"net"
+
+	"github.com/yongfrank/go-socks5/wire"
 )
 
 type ReplyType = byte
@@ -51,28 +53,14 @@ const (
 )
 
 func WriteRequestSuccessMessage(conn io.Writer, ip net.IP, port uint16) error {
-	addressType := TypeIPv4
-	if len(ip) == IPv6Length {
-		addressType = TypeIPv6
-	}
-	// 该函数首先使用 conn.Write 方法向客户端发送了一个 5 字节的消息，其中包括 SOCKS5 协议的版本号、回复成功的响应码、保留字段、地址类型。然后，该函数使用 conn.Write 方法向客户端发送绑定到代理服务器的 IP 地址。这个 IP 地址的具体格式取决于 addressType 参数的值，如果 addressType 是 IPv4Address，则这个 IP 地址应该是 4 个字节的 IPv4 地址；如果 addressType 是 IPv6Address，则这个 IP 地址应该是 16 个字节的 IPv6 地址。
-	// send message to client
-	// Write version, reply success, reserved, address type
-	_, err := conn.Write([]byte{SOCKS5Version, ReplySuccess, ReqReservedField, addressType})
-	if err != nil {
-		return nil
-	}
-
-	// Write bind IP(IPv4, IPv6)
-	if _, err := conn.Write(ip); err != nil {
-		return err
-	}
+	// the bound address is IPv4 or IPv6 as the IP is, 0.0.0.0 when not known
+	return writeReply(conn, ReplySuccess, AddrFromIP(ip, port))
+}
 
-	// Write bind port
-	buf := make([]byte, 2)
-	buf[0] = byte(port >> 8) // 0x1f90 -> 1f
-	buf[1] = byte(port)      // 0x1f90 -> 90
-	_, err = conn.Write(buf)
+// writeReply sends version, reply, reserved field and bound address in one Write
+func writeReply(conn io.Writer, rep ReplyType, bound Addr) error {
+	reply := wire.Reply{Rep: rep, Bound: bound}
+	_, err := reply.WriteTo(conn)
 	return err
 }
 
@@ -102,7 +90,7 @@ func ErrorString(r ReplyType) string {
 }
 
 func WriteRequestFailureMessage(conn io.Writer, replyType ReplyType) error {
-	conn.Write([]byte{SOCKS5Version, replyType, ReqReservedField, TypeIPv4, 0, 0, 0, 0, 0, 0})
+	writeReply(conn, replyType, Addr{})
 	// return ErrAddressTypeNotSupported
 	return errors.New(ErrorString(replyType))
 }
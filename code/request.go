@@ -3,7 +3,8 @@ package socks5
 import (
 	"io"
 	"log"
-	"net"
+
+	"github.com/yongfrank/go-socks5/wire"
 )
 
 /*
@@ -38,9 +39,8 @@ type ClientRequestMessage struct {
 	Cmd Command
 	// Reserved byte
 
-	ATYP    byte // address type of following address, IPv4, IPv6, Domain
-	DstAddr string
-	DstPort uint16
+	// Dst is the destination, its type is the ATYP of the request
+	Dst Addr
 }
 
 /*
@@ -60,9 +60,9 @@ o  CMD
 	o  UDP ASSOCIATE X'03'
 */
 const (
-	CmdConnect Command = 0x01
-	CmdBind    Command = 0x02
-	CmdUDP     Command = 0x03
+	CmdConnect = wire.CmdConnect
+	CmdBind    = wire.CmdBind
+	CmdUDP     = wire.CmdUDPAssociate
 )
 
 // IPv4, IPv6, Domain
@@ -87,74 +87,23 @@ follow, there is no terminating NUL octet.
 the address is a version-6 IP address, with a length of 16 octets.
 */
 const (
-	TypeIPv4   AddressType = 0x01
-	TypeDomain AddressType = 0x03
-	TypeIPv6   AddressType = 0x04 // see ATYP 0x04
+	TypeIPv4   = wire.TypeIPv4
+	TypeDomain = wire.TypeDomain
+	TypeIPv6   = wire.TypeIPv6 // see ATYP 0x04
 )
 
 func NewClientRequestMessage(conn io.Reader) (*ClientRequestMessage, error) {
-	buf := make([]byte, 4)
-	_, err := io.ReadFull(conn, buf)
-	if err != nil {
-		return nil, err
-	}
-
-	// check fields in request
+	// check fields in request, the address type is checked reading the address
 	log.Printf("start fields check")
-	version, command, reserved, addType := buf[0], buf[1], buf[2], buf[3]
-	if version != SOCKS5Version {
-		return nil, ErrVersionNotSupported
-	}
-	if command != CmdBind && command != CmdConnect && command != CmdUDP {
-		return nil, ErrRequestCommandNotSupported
-	}
-	if reserved != ReqReservedField {
-		return nil, ErrRequestReservedFieldNotZero
-	}
-	if addType != TypeIPv4 && addType != TypeDomain && addType != TypeIPv6 {
-		return nil, ErrAddressTypeNotSupported
+	var req wire.Request
+	if _, err := req.ReadFrom(conn); err != nil {
+		return nil, err
 	}
 	// fields check success
 	log.Printf("fields check success")
 
-	message := ClientRequestMessage{
-		Cmd:  command,
-		ATYP: TypeIPv4,
-	}
-
-	switch addType {
-	case TypeIPv6:
-		buf = make([]byte, IPv6Length)
-		fallthrough
-	case TypeIPv4:
-		if _, err := io.ReadFull(conn, buf); err != nil {
-			return nil, err
-		}
-		ip := net.IP(buf)
-		message.DstAddr = ip.String()
-	case TypeDomain:
-
-		var firstOctletForDomainLength = 1
-		// The first
-		// octet of the address field contains the number of octets of name that
-		// follow, there is no terminating NUL octet.
-		buf = make([]byte, firstOctletForDomainLength)
-		if _, err := io.ReadFull(conn, buf); err != nil {
-			return nil, err
-		}
-		domainLen := buf[0]
-		buf = make([]byte, domainLen)
-		if _, err := io.ReadFull(conn, buf); err != nil {
-			return nil, err
-		}
-		message.DstAddr = string(buf)
-	}
-
-	// read port number
-	if _, err := io.ReadFull(conn, buf[:PortLength]); err != nil {
-		return nil, err
-	}
-	message.DstPort = uint16(buf[0])<<8 | uint16(buf[1])
-
-	return &message, nil
+	return &ClientRequestMessage{
+		Cmd: req.Cmd,
+		Dst: req.Dst,
+	}, nil
 }
@@ -39,10 +39,8 @@ func TestNewClientRequestMessage(t *testing.T) {
 			Port:     []byte{0x00, 0x50},
 			Error:    nil,
 			Message: ClientRequestMessage{
-				Cmd:     CmdConnect,
-				ATYP:    TypeIPv4,
-				DstAddr: "1.2.3.4",
-				DstPort: 80,
+				Cmd: CmdConnect,
+				Dst: testAddr("1.2.3.4:80"),
 			},
 		},
 		{
@@ -53,10 +51,8 @@ func TestNewClientRequestMessage(t *testing.T) {
 			Port:     []byte{0x00, 0x50},
 			Error:    ErrVersionNotSupported,
 			Message: ClientRequestMessage{
-				Cmd:     CmdConnect,
-				ATYP:    TypeIPv4,
-				DstAddr: "1.2.3.4",
-				DstPort: 80,
+				Cmd: CmdConnect,
+				Dst: testAddr("1.2.3.4:80"),
 			},
 		},
 		{
@@ -67,10 +63,8 @@ func TestNewClientRequestMessage(t *testing.T) {
 			Port:     []byte{0x00, 0x50},
 			Error:    nil,
 			Message: ClientRequestMessage{
-				Cmd:     CmdConnect,
-				ATYP:    TypeIPv4,
-				DstAddr: "1.2.3.4",
-				DstPort: 80,
+				Cmd: CmdConnect,
+				Dst: testAddr("1.2.3.4:80"),
 			},
 		},
 	}
@@ -0,0 +1,59 @@
+package socks5
+
+import (
+	"net"
+	"strings"
+)
+
+// Rewrite replaces a requested destination before it is dialed.
+//
+// From is a host pattern as in AccessRule.Destinations, optionally with a port:
+// "api.internal:443", "*.staging.example.com", "10.0.0.0/8:80", or ":22" for
+// a port on any host. To is "host:port", a "host" keeping the requested port,
+// or ":port" keeping the requested host.
+type Rewrite struct {
+	From string
+	To   string
+}
+
+// Rewrites are tried in order, the first matching one applies.
+type Rewrites []Rewrite
+
+// Rewrite returns the destination address is rewritten to, ok false if no rewrite matches.
+func (r Rewrites) Rewrite(address string) (string, bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, false
+	}
+	for _, rewrite := range r {
+		if !rewrite.match(host, port) {
+			continue
+		}
+		toHost, toPort := splitHostPortPattern(rewrite.To)
+		if toHost == "" {
+			toHost = host
+		}
+		if toPort == "" {
+			toPort = port
+		}
+		return net.JoinHostPort(toHost, toPort), true
+	}
+	return address, false
+}
+
+func (rewrite *Rewrite) match(host, port string) bool {
+	fromHost, fromPort := splitHostPortPattern(rewrite.From)
+	if fromPort != "" && fromPort != port {
+		return false
+	}
+	return fromHost == "" || matchHost(fromHost, host)
+}
+
+// splitHostPortPattern splits an optional port off a pattern,
+// a pattern that is not host:port is all host, such as an IPv6 CIDR
+func splitHostPortPattern(pattern string) (host, port string) {
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		return strings.TrimSuffix(strings.TrimPrefix(h, "["), "]"), p
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]"), ""
+}
@@ -0,0 +1,59 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRewrites(t *testing.T) {
+	rewrites := Rewrites{
+		{From: "api.internal:443", To: "10.2.3.4:8443"},
+		{From: "*.staging.example.com", To: "10.9.9.9"},
+		{From: "10.0.0.0/8:80", To: ":8080"},
+		{From: ":2222", To: ":22"},
+		{From: "2001:db8::/32", To: "[2001:db8::1]:443"},
+	}
+	tests := []struct {
+		address string
+		want    string
+		ok      bool
+	}{
+		{"api.internal:443", "10.2.3.4:8443", true},
+		{"API.internal:443", "10.2.3.4:8443", true},
+		{"api.internal:80", "api.internal:80", false},
+		{"www.staging.example.com:443", "10.9.9.9:443", true},
+		{"10.1.1.1:80", "10.1.1.1:8080", true},
+		{"10.1.1.1:81", "10.1.1.1:81", false},
+		{"example.com:2222", "example.com:22", true},
+		{"[2001:db8::5]:80", "[2001:db8::1]:443", true},
+		{"example.com:443", "example.com:443", false},
+	}
+	for _, test := range tests {
+		got, ok := rewrites.Rewrite(test.address)
+		if got != test.want || ok != test.ok {
+			t.Fatalf("%s: want %s, %v but got %s, %v", test.address, test.want, test.ok, got, ok)
+		}
+	}
+}
+
+func TestRewriteConnect(t *testing.T) {
+	echo := startEchoServer(t)
+	// the client asks for a name that does not resolve
+	config := &Config{AuthMethod: MethodNoAuth, Rewrites: Rewrites{
+		{From: "echo.invalid:7", To: net.JoinHostPort("127.0.0.1", strconv.Itoa(echo.Port))},
+	}}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", "echo.invalid:7")
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("want echo ping but got %q, %v", got, err)
+	}
+}
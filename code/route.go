@@ -0,0 +1,257 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RouteAction is how a routed request reaches its destination.
+type RouteAction int
+
+const (
+	// RouteDirect dials the destination from this host
+	RouteDirect RouteAction = iota
+	// RouteUpstream dials through the upstream proxy named by Route.Upstream
+	RouteUpstream
+	// RouteInterface dials from the source interface or address Route.Interface
+	RouteInterface
+	// RouteReject refuses the request
+	RouteReject
+)
+
+func (a RouteAction) String() string {
+	switch a {
+	case RouteDirect:
+		return "direct"
+	case RouteUpstream:
+		return "upstream"
+	case RouteInterface:
+		return "interface"
+	case RouteReject:
+		return "reject"
+	}
+	return "RouteAction(" + strconv.Itoa(int(a)) + ")"
+}
+
+var ErrRouteRejected = errors.New("request rejected by route")
+
+// ContextDialer dials connections, like net.Dialer and Dialer.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Route matches requests and chooses their outbound path.
+// Every matcher that is set has to match, a route without matchers matches everything.
+type Route struct {
+	// Name identifies the route in logs and Explain
+	Name string
+
+	// Destinations are host names, "*.example.com" domain suffixes, IP addresses or CIDRs
+	Destinations []string
+	Ports        []uint16
+	Users        []string
+	Groups       []string
+	// Countries and ASNs match the destination, ClientCountries and ClientASNs the client,
+	// as looked up in Config.GeoIP
+	Countries       []string
+	ASNs            []uint32
+	ClientCountries []string
+	ClientASNs      []uint32
+
+	Action RouteAction
+	// Upstream names an entry of Router.Upstreams for RouteUpstream
+	Upstream string
+	// Interface is a network interface name or local IP address for RouteInterface
+	Interface string
+	// SocketOptions replace Config.SocketOptions for the route's direct and interface connections.
+	// Upstream routes do not set them, the upstream's own dialer connects to the proxy.
+	SocketOptions *SocketOptions
+}
+
+// Router chooses a route for each request, the first matching route wins
+// and requests matching none take Default, direct unless set otherwise.
+type Router struct {
+	Routes  []Route
+	Default Route
+
+	// Upstreams are the proxies routes can send requests through, by name
+	Upstreams map[string]ContextDialer
+}
+
+// Match returns the index of the route req takes and the route, index -1 for the default route.
+func (r *Router) Match(req *Request) (int, *Route) {
+	for i := range r.Routes {
+		if r.match(&r.Routes[i], req) {
+			return i, &r.Routes[i]
+		}
+	}
+	return -1, &r.Default
+}
+
+// Explain describes the route req takes, for debugging a routing table.
+func (r *Router) Explain(req *Request) string {
+	return explainRoute(r.Match(req))
+}
+
+func explainRoute(i int, route *Route) string {
+	var b strings.Builder
+	if i < 0 {
+		b.WriteString("default route")
+	} else {
+		fmt.Fprintf(&b, "route %d", i)
+	}
+	if route.Name != "" {
+		fmt.Fprintf(&b, " %q", route.Name)
+	}
+	b.WriteString(": ")
+	b.WriteString(route.Action.String())
+	switch route.Action {
+	case RouteUpstream:
+		b.WriteString(" " + route.Upstream)
+	case RouteInterface:
+		b.WriteString(" " + route.Interface)
+	}
+	return b.String()
+}
+
+func (r *Router) match(route *Route, req *Request) bool {
+	if len(route.Users) > 0 && (req.User == nil || !containsString(route.Users, req.User.Name)) {
+		return false
+	}
+	if len(route.Groups) > 0 {
+		member := false
+		for _, g := range route.Groups {
+			if req.User.InGroup(g) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return false
+		}
+	}
+	if len(route.Ports) > 0 {
+		matched := false
+		for _, p := range route.Ports {
+			if p == req.Dst.Port {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(route.Destinations) > 0 {
+		matched := false
+		for _, pattern := range route.Destinations {
+			if matchDst(pattern, req) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return matchGeo(req.DstGeo, route.Countries, route.ASNs) && matchGeo(req.ClientGeo, route.ClientCountries, route.ClientASNs)
+}
+
+// dialRoute connects to address over the route req takes, directly if route is nil,
+// and returns the address of the destination connected to. Interface routes dial
+// directly from the interface's address.
+func (s *SOCKS5Server) dialRoute(ctx context.Context, config *Config, route *Route, req *Request, address string, opts *SocketOptions) (net.Conn, net.Addr, error) {
+	var local *net.TCPAddr
+	if route != nil {
+		switch route.Action {
+		case RouteDirect:
+		case RouteUpstream:
+			return dialUpstream(ctx, config, route, req, address)
+		case RouteInterface:
+			var err error
+			if local, err = sourceAddr(route.Interface); err != nil {
+				return nil, nil, fmt.Errorf("route %q: %w", route.Name, err)
+			}
+		case RouteReject:
+			return nil, nil, ErrRouteRejected
+		default:
+			return nil, nil, fmt.Errorf("route %q: unknown action %s", route.Name, route.Action)
+		}
+	}
+	conn, err := s.dialDirect(ctx, config, req, address, local, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.RemoteAddr(), nil
+}
+
+// dialUpstream connects to address through the upstream of route. The connection's
+// remote address is the upstream's, the destination is returned as a TCP address
+// if its IP is known here.
+func dialUpstream(ctx context.Context, config *Config, route *Route, req *Request, address string) (net.Conn, net.Addr, error) {
+	upstream := config.Router.Upstreams[route.Upstream]
+	if upstream == nil {
+		return nil, nil, fmt.Errorf("route %q: unknown upstream %q", route.Name, route.Upstream)
+	}
+	// upstream groups hash by the user of the request
+	ctx = context.WithValue(ctx, requestContextKey{}, req)
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	port, _ := strconv.Atoi(portStr)
+	if ip := net.ParseIP(host); ip != nil {
+		conn, err := upstream.DialContext(ctx, "tcp", address)
+		return conn, &net.TCPAddr{IP: ip, Port: port}, err
+	}
+	// a domain resolved for the rules goes to the addresses they allowed, not resolved again upstream
+	if ips := req.resolved(host); ips != nil {
+		dial := func(ctx context.Context, ip net.IP) (net.Conn, error) {
+			return upstream.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), portStr))
+		}
+		conn, ip, err := config.raceAddresses(ctx, ips, dial)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, &net.TCPAddr{IP: ip, Port: port}, nil
+	}
+	conn, err := upstream.DialContext(ctx, "tcp", address)
+	return conn, nil, err
+}
+
+// sourceAddr returns the local address to dial from for an interface name or IP,
+// an interface's first IPv4 address is preferred
+func sourceAddr(iface string) (*net.TCPAddr, error) {
+	if ip := net.ParseIP(iface); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var found net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+		if found == nil {
+			found = ipNet.IP
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("interface %s has no address", iface)
+	}
+	return &net.TCPAddr{IP: found}, nil
+}
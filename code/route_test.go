@@ -0,0 +1,94 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRouterExplain(t *testing.T) {
+	router := &Router{
+		Routes: []Route{
+			{Name: "ads", Destinations: []string{"*.ads.example.com"}, Action: RouteReject},
+			{Name: "office", Destinations: []string{"10.0.0.0/8"}, Ports: []uint16{22, 443}, Action: RouteInterface, Interface: "192.0.2.1"},
+			{Name: "admins", Groups: []string{"admins"}, Action: RouteDirect},
+			{Name: "abroad", Countries: []string{"de"}, Action: RouteUpstream, Upstream: "frankfurt"},
+		},
+		Default: Route{Action: RouteUpstream, Upstream: "office"},
+	}
+	admin := &User{Name: "alice", Groups: []string{"admins"}}
+	tests := []struct {
+		req  Request
+		want string
+	}{
+		{Request{Dst: testAddr("www.ads.example.com:80")}, `route 0 "ads": reject`},
+		{Request{Dst: testAddr("10.1.2.3:22")}, `route 1 "office": interface 192.0.2.1`},
+		{Request{Dst: testAddr("10.1.2.3:80")}, `default route: upstream office`},
+		{Request{User: admin, Dst: testAddr("10.1.2.3:80")}, `route 2 "admins": direct`},
+		{Request{Dst: testAddr("203.0.113.7:443"), DstGeo: GeoInfo{Country: "DE"}}, `route 3 "abroad": upstream frankfurt`},
+		{Request{Dst: testAddr("203.0.113.8:443"), DstGeo: GeoInfo{Country: "FR"}}, `default route: upstream office`},
+	}
+	for _, test := range tests {
+		if got := router.Explain(&test.req); got != test.want {
+			t.Fatalf("%s: want %s but got %s", test.req.Dst, test.want, got)
+		}
+	}
+}
+
+func TestRouteConnect(t *testing.T) {
+	echo := startEchoServer(t)
+	upstream := &Dialer{ProxyAddress: startTestServer(t, &Config{AuthMethod: MethodNoAuth}), Timeout: 5 * time.Second}
+	config := &Config{AuthMethod: MethodNoAuth, Router: &Router{
+		Routes: []Route{
+			{Name: "blocked", Ports: []uint16{1}, Action: RouteReject},
+			{Name: "echo", Destinations: []string{"127.0.0.1"}, Action: RouteUpstream, Upstream: "upstream"},
+		},
+		Upstreams: map[string]ContextDialer{"upstream": upstream},
+	}}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("want echo ping but got %q, %v", got, err)
+	}
+
+	_, err = d.Dial("tcp", "127.0.0.1:1")
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Reply != ReplyConnectionNotAllowed {
+		t.Fatalf("want reply %s but got %v", ErrorString(ReplyConnectionNotAllowed), err)
+	}
+}
+
+func TestRouteResolvedAddresses(t *testing.T) {
+	target := startEchoServer(t)
+	upstream := &Dialer{ProxyAddress: startTestServer(t, &Config{AuthMethod: MethodNoAuth}), Timeout: 5 * time.Second}
+	s := &SOCKS5Server{Resolver: noDNS}
+	for _, route := range []Route{
+		{Name: "upstream", Action: RouteUpstream, Upstream: "upstream"},
+		{Name: "interface", Action: RouteInterface, Interface: "127.0.0.1"},
+	} {
+		config := &Config{
+			Router:                 &Router{Routes: []Route{route}, Upstreams: map[string]ContextDialer{"upstream": upstream}},
+			ConnectionAttemptDelay: 10 * time.Millisecond,
+		}
+		// the first allowed address is down, the request goes on to the next
+		req := &Request{
+			Dst:    Addr{FQDN: "two.example", Port: uint16(target.Port)},
+			DstIPs: []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")},
+		}
+		conn, err := s.dial(config, req)
+		if err != nil {
+			t.Fatalf("%s: should get error nil but got %s", route.Name, err)
+		}
+		echo(t, conn, "ping")
+		conn.Close()
+	}
+}
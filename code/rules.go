@@ -0,0 +1,203 @@
+package socks5
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Request is what a RuleSet decides on.
+type Request struct {
+	// User is nil when no authentication took place
+	User       *User
+	ClientAddr net.Addr
+	Cmd        Command
+	Dst        Addr
+	// DstIPs are the addresses a domain destination resolved to, the ones dialed,
+	// nil when it is resolved on dialing
+	DstIPs []net.IP
+
+	// ClientGeo and DstGeo are looked up in Config.GeoIP, zero without one
+	ClientGeo GeoInfo
+	DstGeo    GeoInfo
+}
+
+// RuleSet decides whether a request may proceed.
+// A non-zero deadline ends an allowed session at that time.
+type RuleSet interface {
+	Allow(req *Request) (allowed bool, deadline time.Time)
+}
+
+// AccessRule matches requests by user, group, destination and time.
+// Every criterion that is set has to match, an empty rule matches everything.
+type AccessRule struct {
+	// Deny turns the rule into a deny rule.
+	Deny bool
+
+	Users  []string
+	Groups []string
+	// Destinations are host names, "*.example.com" wildcards, IP addresses or CIDRs.
+	// IP addresses and CIDRs also match the addresses a domain destination resolves to.
+	Destinations []string
+	Ports        []uint16
+
+	// Countries and ASNs match the destination, ClientCountries and ClientASNs the client,
+	// as looked up in Config.GeoIP
+	Countries       []string
+	ASNs            []uint32
+	ClientCountries []string
+	ClientASNs      []uint32
+
+	// Schedule restricts the rule to the given time windows.
+	Schedule *Schedule
+	// CutOff ends sessions allowed by this rule when the schedule window closes.
+	CutOff bool
+}
+
+// AccessRules is a RuleSet evaluating rules in order, the first matching rule wins.
+// Requests matching no rule are allowed unless DefaultDeny is set.
+type AccessRules struct {
+	Rules       []AccessRule
+	DefaultDeny bool
+
+	// Now returns the current time, defaults to time.Now.
+	Now func() time.Time
+}
+
+func (r *AccessRules) Allow(req *Request) (bool, time.Time) {
+	now := time.Now()
+	if r.Now != nil {
+		now = r.Now()
+	}
+	for _, rule := range r.Rules {
+		if !rule.match(req, now) {
+			continue
+		}
+		if rule.Deny {
+			return false, time.Time{}
+		}
+		if rule.CutOff && rule.Schedule != nil {
+			return true, rule.Schedule.End(now)
+		}
+		return true, time.Time{}
+	}
+	return !r.DefaultDeny, time.Time{}
+}
+
+func (rule *AccessRule) match(req *Request, now time.Time) bool {
+	if len(rule.Users) > 0 && (req.User == nil || !containsString(rule.Users, req.User.Name)) {
+		return false
+	}
+	if len(rule.Groups) > 0 {
+		member := false
+		for _, g := range rule.Groups {
+			if req.User.InGroup(g) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return false
+		}
+	}
+	if len(rule.Destinations) > 0 {
+		matched := false
+		for _, pattern := range rule.Destinations {
+			if matchDst(pattern, req) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(rule.Ports) > 0 {
+		matched := false
+		for _, p := range rule.Ports {
+			if p == req.Dst.Port {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if !matchGeo(req.DstGeo, rule.Countries, rule.ASNs) || !matchGeo(req.ClientGeo, rule.ClientCountries, rule.ClientASNs) {
+		return false
+	}
+	if rule.Schedule != nil && !rule.Schedule.Contains(now) {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDst matches the destination of req against a pattern,
+// IP address and CIDR patterns also match the resolved addresses of a domain
+func matchDst(pattern string, req *Request) bool {
+	if matchHost(pattern, req.Dst.Host()) {
+		return true
+	}
+	if !isAddressPattern(pattern) {
+		return false
+	}
+	for _, ip := range req.DstIPs {
+		if matchHost(pattern, ip.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAddressPattern(pattern string) bool {
+	return strings.Contains(pattern, "/") || net.ParseIP(pattern) != nil
+}
+
+// matchesAddresses reports whether rules have IP address or CIDR destinations,
+// domains are then resolved before the rules are checked
+func matchesAddresses(rules RuleSet) bool {
+	r, ok := rules.(*AccessRules)
+	if !ok {
+		return false
+	}
+	for _, rule := range r.Rules {
+		for _, pattern := range rule.Destinations {
+			if isAddressPattern(pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchHost matches a host name or IP address against a pattern,
+// which is a host name, a "*.example.com" wildcard, an IP address or a CIDR.
+func matchHost(pattern, host string) bool {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+	if ip := net.ParseIP(pattern); ip != nil {
+		return ip.Equal(net.ParseIP(host))
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
@@ -0,0 +1,92 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAccessRules(t *testing.T) {
+	business, _ := ParseSchedule("Mon-Fri 09:00-17:00 UTC")
+	monday := time.Date(2023, 3, 20, 10, 0, 0, 0, time.UTC)
+	sunday := time.Date(2023, 3, 19, 10, 0, 0, 0, time.UTC)
+
+	rules := &AccessRules{
+		Rules: []AccessRule{
+			{Deny: true, Destinations: []string{"10.0.0.0/8", "*.internal"}},
+			{Groups: []string{"contractors"}, Schedule: business, CutOff: true},
+			{Deny: true, Groups: []string{"contractors"}},
+			{Users: []string{"admin"}},
+			{Ports: []uint16{80, 443}},
+		},
+		DefaultDeny: true,
+	}
+	admin := &User{Name: "admin"}
+	contractor := &User{Name: "zhangsan", Groups: []string{"contractors"}}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		req      Request
+		allowed  bool
+		deadline time.Time
+	}{
+		{"denied cidr", monday, Request{User: admin, Dst: testAddr("10.1.2.3:22")}, false, time.Time{}},
+		{"denied wildcard", monday, Request{User: admin, Dst: testAddr("db.Internal.:22")}, false, time.Time{}},
+		{"admin", monday, Request{User: admin, Dst: testAddr("example.com:22")}, true, time.Time{}},
+		{"contractor in hours", monday, Request{User: contractor, Dst: testAddr("example.com:22")}, true, time.Date(2023, 3, 20, 17, 0, 0, 0, time.UTC)},
+		{"contractor out of hours", sunday, Request{User: contractor, Dst: testAddr("example.com:443")}, false, time.Time{}},
+		{"anonymous web", monday, Request{Dst: testAddr("example.com:443")}, true, time.Time{}},
+		{"anonymous default", monday, Request{Dst: testAddr("example.com:22")}, false, time.Time{}},
+	}
+	for _, test := range tests {
+		rules.Now = func() time.Time { return test.now }
+		allowed, deadline := rules.Allow(&test.req)
+		if allowed != test.allowed {
+			t.Errorf("%s: want allowed %v but got %v", test.name, test.allowed, allowed)
+		}
+		if !deadline.Equal(test.deadline) {
+			t.Errorf("%s: want deadline %s but got %s", test.name, test.deadline, deadline)
+		}
+	}
+}
+
+func TestDeniedCIDRDomain(t *testing.T) {
+	echo := startEchoServer(t)
+	config := &Config{AuthMethod: MethodNoAuth, Rules: &AccessRules{Rules: []AccessRule{
+		{Deny: true, Destinations: []string{"127.0.0.0/8", "::1"}},
+	}}}
+	// localhost comes from the hosts file, no DNS needed
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+
+	_, err := d.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(echo.Port)))
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Reply != ReplyConnectionNotAllowed {
+		t.Fatalf("want reply %s for a domain resolving into a denied cidr but got %v", ErrorString(ReplyConnectionNotAllowed), err)
+	}
+
+	// the addresses of a domain are matched one by one
+	req := &Request{Dst: Addr{FQDN: "mixed.example", Port: 80}, DstIPs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("192.0.2.1")}}
+	if allowed, _ := config.allow(req); !allowed || len(req.DstIPs) != 1 || !req.DstIPs[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("want only 192.0.2.1 allowed but got %v %v", allowed, req.DstIPs)
+	}
+}
+
+func TestRequestNotAllowed(t *testing.T) {
+	s := &SOCKS5Server{}
+	config := &Config{Rules: &AccessRules{DefaultDeny: true}}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{SOCKS5Version, CmdConnect, ReqReservedField, TypeIPv4, 127, 0, 0, 1, 0x00, 0x50})
+	if err := s.request(&buf, config, nil); err != ErrConnectionNotAllowed {
+		t.Fatalf("want error %s but got %v", ErrConnectionNotAllowed, err)
+	}
+	want := []byte{SOCKS5Version, ReplyConnectionNotAllowed, ReqReservedField, TypeIPv4, 0, 0, 0, 0, 0, 0}
+	if got := buf.Bytes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("should send %v, but sent %v", want, got)
+	}
+}
@@ -0,0 +1,221 @@
+package socks5
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a set of weekly time windows in a time zone.
+type Schedule struct {
+	// Weekdays the windows start on, empty means every day.
+	Weekdays []time.Weekday
+	// Ranges within a day, empty means the whole day.
+	Ranges []TimeRange
+	// Location of the clock times, nil means time.Local.
+	Location *time.Location
+}
+
+// TimeRange is a window between two clock times, given as hours and minutes past 00:00
+// on the clock, which on days of a DST change is not the time elapsed since midnight.
+// A range whose End is not after Start wraps past midnight, e.g. 22:00-06:00.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+const day = 24 * time.Hour
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+/*
+ParseSchedule parses a schedule of the form
+
+	[weekdays] [ranges] [time zone]
+
+where weekdays is a comma separated list of days or day ranges,
+ranges a comma separated list of HH:MM-HH:MM and the time zone an IANA name:
+
+	Mon-Fri 09:00-17:00 Europe/Berlin
+	Sat,Sun 10:00-12:00,14:00-16:00
+	22:00-06:00 UTC
+*/
+func ParseSchedule(s string) (*Schedule, error) {
+	schedule := &Schedule{}
+	for _, field := range strings.Fields(s) {
+		switch {
+		case strings.Contains(field, ":"):
+			for _, r := range strings.Split(field, ",") {
+				tr, err := parseTimeRange(r)
+				if err != nil {
+					return nil, err
+				}
+				schedule.Ranges = append(schedule.Ranges, tr)
+			}
+		case isWeekdayList(field):
+			days, err := parseWeekdays(field)
+			if err != nil {
+				return nil, err
+			}
+			schedule.Weekdays = append(schedule.Weekdays, days...)
+		default:
+			if schedule.Location != nil {
+				return nil, fmt.Errorf("schedule: unexpected %q in %q", field, s)
+			}
+			loc, err := time.LoadLocation(field)
+			if err != nil {
+				return nil, fmt.Errorf("schedule: %w", err)
+			}
+			schedule.Location = loc
+		}
+	}
+	return schedule, nil
+}
+
+func isWeekdayList(s string) bool {
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '-' }) {
+		if _, ok := weekdayNames[strings.ToLower(part)]; !ok {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdayNames[strings.ToLower(from)]
+		if !ok {
+			return nil, fmt.Errorf("schedule: unknown weekday %q", from)
+		}
+		if !isRange {
+			days = append(days, first)
+			continue
+		}
+		last, ok := weekdayNames[strings.ToLower(to)]
+		if !ok {
+			return nil, fmt.Errorf("schedule: unknown weekday %q", to)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeRange(s string) (TimeRange, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return TimeRange{}, fmt.Errorf("schedule: invalid time range %q", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return TimeRange{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return TimeRange{}, err
+	}
+	return TimeRange{Start: start, End: end}, nil
+}
+
+// parseClock parses HH:MM, 24:00 is the end of the day.
+func parseClock(s string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("schedule: invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func (s *Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+func (s *Schedule) onDay(d time.Weekday) bool {
+	if len(s.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range s.Weekdays {
+		if w == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) ranges() []TimeRange {
+	if len(s.Ranges) == 0 {
+		return []TimeRange{{Start: 0, End: day}}
+	}
+	return s.Ranges
+}
+
+// window returns the end of the window containing t, or false if t is outside the schedule.
+// Windows start and end at clock times, so days of a DST change keep their hours.
+func (s *Schedule) window(t time.Time) (time.Time, bool) {
+	loc := s.location()
+	t = t.In(loc)
+	year, month, today := t.Date()
+	// clock is the time of day offset on a day of t's month, 24:00 being the next midnight
+	clock := func(day int, offset time.Duration) time.Time {
+		return time.Date(year, month, day, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, loc)
+	}
+	yesterday := clock(today-1, 0).Weekday()
+
+	for _, r := range s.ranges() {
+		if r.Start < r.End {
+			if s.onDay(t.Weekday()) && !t.Before(clock(today, r.Start)) && t.Before(clock(today, r.End)) {
+				return clock(today, r.End), true
+			}
+			continue
+		}
+		// wraps past midnight
+		if s.onDay(t.Weekday()) && !t.Before(clock(today, r.Start)) {
+			return clock(today+1, r.End), true
+		}
+		if s.onDay(yesterday) && t.Before(clock(today, r.End)) {
+			return clock(today, r.End), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Contains reports whether t is inside one of the schedule's windows.
+func (s *Schedule) Contains(t time.Time) bool {
+	_, ok := s.window(t)
+	return ok
+}
+
+// End returns when the window containing t closes, following adjacent windows,
+// e.g. Mon-Fri 00:00-24:00 ends on Saturday midnight.
+// It returns the zero time if t is outside the schedule or the schedule never closes.
+func (s *Schedule) End(t time.Time) time.Time {
+	end, ok := s.window(t)
+	if !ok {
+		return time.Time{}
+	}
+	for limit := t.Add(8 * day); end.Before(limit); {
+		next, ok := s.window(end)
+		if !ok {
+			return end
+		}
+		end = next
+	}
+	return time.Time{}
+}
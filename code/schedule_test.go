@@ -0,0 +1,127 @@
+package socks5
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	s, err := ParseSchedule("Mon-Fri 09:00-12:00,13:00-17:30 Europe/Berlin")
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	want := &Schedule{
+		Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Ranges: []TimeRange{
+			{Start: 9 * time.Hour, End: 12 * time.Hour},
+			{Start: 13 * time.Hour, End: 17*time.Hour + 30*time.Minute},
+		},
+		Location: berlin,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("want %v but got %v", want, s)
+	}
+
+	s, err = ParseSchedule("Fri-Mon")
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if want := []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}; !reflect.DeepEqual(s.Weekdays, want) {
+		t.Fatalf("want weekdays %v but got %v", want, s.Weekdays)
+	}
+
+	for _, invalid := range []string{"Mon 25:00-26:00", "Mon 09:00", "Mon-Foo", "UTC UTC"} {
+		if _, err := ParseSchedule(invalid); err == nil {
+			t.Errorf("%q: should get error but got nil", invalid)
+		}
+	}
+}
+
+func TestScheduleContains(t *testing.T) {
+	business, _ := ParseSchedule("Mon-Fri 09:00-17:00 UTC")
+	night, _ := ParseSchedule("Fri 22:00-06:00 UTC")
+
+	// 2023-03-20 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2023, 3, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule *Schedule
+		t        time.Time
+		contains bool
+		end      time.Time
+	}{
+		{business, at(20, 9, 0), true, at(20, 17, 0)},
+		{business, at(20, 16, 59), true, at(20, 17, 0)},
+		{business, at(20, 17, 0), false, time.Time{}},
+		{business, at(20, 8, 0), false, time.Time{}},
+		{business, at(25, 10, 0), false, time.Time{}}, // Saturday
+		{business, at(20, 10, 0).In(time.FixedZone("UTC+8", 8*3600)), true, at(20, 17, 0)},
+		{night, at(24, 23, 0), true, at(25, 6, 0)},
+		{night, at(25, 5, 0), true, at(25, 6, 0)},
+		{night, at(26, 5, 0), false, time.Time{}},
+	}
+	for _, test := range tests {
+		if got := test.schedule.Contains(test.t); got != test.contains {
+			t.Errorf("%s: want contains %v but got %v", test.t, test.contains, got)
+		}
+		if got := test.schedule.End(test.t); !got.Equal(test.end) {
+			t.Errorf("%s: want end %s but got %s", test.t, test.end, got)
+		}
+	}
+
+	weekdays, _ := ParseSchedule("Mon-Fri UTC")
+	if got, want := weekdays.End(at(22, 12, 0)), at(25, 0, 0); !got.Equal(want) {
+		t.Errorf("adjacent windows: want end %s but got %s", want, got)
+	}
+	always := &Schedule{}
+	if got := always.End(at(22, 12, 0)); !got.IsZero() {
+		t.Errorf("never closing schedule: want zero end but got %s", got)
+	}
+}
+
+func TestScheduleDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	business, _ := ParseSchedule("09:00-17:00 America/New_York")
+	sunday, _ := ParseSchedule("Sun America/New_York")
+	night, _ := ParseSchedule("22:00-06:00 America/New_York")
+
+	// clocks go forward on 2023-03-12 and back on 2023-11-05, both Sundays
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2023, month, day, hour, min, 0, 0, newYork)
+	}
+	tests := []struct {
+		schedule *Schedule
+		t        time.Time
+		contains bool
+		end      time.Time
+	}{
+		{business, at(3, 12, 8, 59), false, time.Time{}},
+		{business, at(3, 12, 9, 0), true, at(3, 12, 17, 0)},
+		{business, at(3, 12, 16, 59), true, at(3, 12, 17, 0)},
+		{business, at(3, 12, 17, 0), false, time.Time{}},
+		{business, at(11, 5, 9, 0), true, at(11, 5, 17, 0)},
+		{business, at(11, 5, 17, 0), false, time.Time{}},
+		{sunday, at(3, 12, 12, 0), true, at(3, 13, 0, 0)},
+		{sunday, at(11, 5, 12, 0), true, at(11, 6, 0, 0)},
+		{night, at(3, 12, 5, 59), true, at(3, 12, 6, 0)},
+		{night, at(11, 4, 23, 0), true, at(11, 5, 6, 0)},
+	}
+	for _, test := range tests {
+		if got := test.schedule.Contains(test.t); got != test.contains {
+			t.Errorf("%s: want contains %v but got %v", test.t, test.contains, got)
+		}
+		if got := test.schedule.End(test.t); !got.Equal(test.end) {
+			t.Errorf("%s: want end %s but got %s", test.t, test.end, got)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package socks5
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session is a client connection being served, registered by handleConnection.
+type session struct {
+	id         uint64
+	clientAddr net.Addr
+	start      time.Time
+	conn       *sessionConn
+
+	// bytes read from and written to the client
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	mu          sync.Mutex
+	user        string
+	destination string
+	clientGeo   GeoInfo
+	dstGeo      GeoInfo
+	target      net.Conn
+	killed      bool
+}
+
+// SessionInfo is a snapshot of a session.
+type SessionInfo struct {
+	ID          uint64        `json:"id"`
+	Client      string        `json:"client"`
+	User        string        `json:"user,omitempty"`
+	Destination string        `json:"destination,omitempty"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	BytesIn     uint64        `json:"bytes_in"`
+	BytesOut    uint64        `json:"bytes_out"`
+
+	// GeoIP country and ASN of the client and destination, set with Config.GeoIP
+	ClientCountry      string `json:"client_country,omitempty"`
+	ClientASN          uint32 `json:"client_asn,omitempty"`
+	DestinationCountry string `json:"destination_country,omitempty"`
+	DestinationASN     uint32 `json:"destination_asn,omitempty"`
+}
+
+func (s *session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := SessionInfo{
+		ID:          s.id,
+		User:        s.user,
+		Destination: s.destination,
+		Start:       s.start,
+		Duration:    time.Since(s.start),
+		BytesIn:     s.bytesIn.Load(),
+		BytesOut:    s.bytesOut.Load(),
+
+		ClientCountry:      s.clientGeo.Country,
+		ClientASN:          s.clientGeo.ASN,
+		DestinationCountry: s.dstGeo.Country,
+		DestinationASN:     s.dstGeo.ASN,
+	}
+	if s.clientAddr != nil {
+		info.Client = s.clientAddr.String()
+	}
+	return info
+}
+
+// request records the user, destination and their GeoIP data of a request
+func (s *session) request(req *Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.User != nil {
+		s.user = req.User.Name
+	}
+	s.destination = req.Dst.String()
+	s.clientGeo = req.ClientGeo
+	s.dstGeo = req.DstGeo
+}
+
+// relaying records the target connection, so that kill can close it too
+func (s *session) relaying(target net.Conn) {
+	s.mu.Lock()
+	killed := s.killed
+	s.target = target
+	s.mu.Unlock()
+	if killed {
+		target.Close()
+	}
+}
+
+// kill closes the client and target connections of the session.
+func (s *session) kill() {
+	s.mu.Lock()
+	s.killed = true
+	target := s.target
+	s.mu.Unlock()
+	s.conn.Conn.Close()
+	if target != nil {
+		target.Close()
+	}
+}
+
+// sessionConn counts the bytes of a session's client connection
+type sessionConn struct {
+	net.Conn
+	session *session
+}
+
+func (c *sessionConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.session.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *sessionConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.session.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+// sessionOf returns the session a connection belongs to, nil if it is not registered
+func sessionOf(conn interface{}) *session {
+	switch c := conn.(type) {
+	case *sessionConn:
+		return c.session
+	case *bufferedConn:
+		return sessionOf(c.Conn)
+	}
+	return nil
+}
+
+type sessionRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[uint64]*session
+}
+
+// open registers a session for conn
+func (r *sessionRegistry) open(conn net.Conn) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[uint64]*session)
+	}
+	r.nextID++
+	s := &session{id: r.nextID, clientAddr: conn.RemoteAddr(), start: time.Now()}
+	s.conn = &sessionConn{Conn: conn, session: s}
+	r.sessions[s.id] = s
+	return s
+}
+
+// close removes a session and writes its access log record
+func (r *sessionRegistry) close(s *session, err error) {
+	r.mu.Lock()
+	delete(r.sessions, s.id)
+	r.mu.Unlock()
+
+	info := s.info()
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	log.Printf("access session=%d client=%s user=%q destination=%s duration=%s bytes_in=%d bytes_out=%d client_country=%s client_asn=%d destination_country=%s destination_asn=%d result=%q",
+		info.ID, info.Client, info.User, info.Destination, info.Duration.Round(time.Millisecond), info.BytesIn, info.BytesOut,
+		info.ClientCountry, info.ClientASN, info.DestinationCountry, info.DestinationASN, result)
+}
+
+func (r *sessionRegistry) list() []*session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := make([]*session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions
+}
+
+// Sessions returns the sessions being served, oldest first.
+func (s *SOCKS5Server) Sessions() []SessionInfo {
+	sessions := s.sessions.list()
+	infos := make([]SessionInfo, len(sessions))
+	for i, sess := range sessions {
+		infos[i] = sess.info()
+	}
+	return infos
+}
+
+// KillSession closes the session with id, it reports whether there was one.
+func (s *SOCKS5Server) KillSession(id uint64) bool {
+	s.sessions.mu.Lock()
+	sess := s.sessions.sessions[id]
+	s.sessions.mu.Unlock()
+	if sess == nil {
+		return false
+	}
+	log.Printf("session %d killed", id)
+	sess.kill()
+	return true
+}
+
+// KillUserSessions closes every session of the named user and returns how many there were.
+func (s *SOCKS5Server) KillUserSessions(user string) int {
+	n := 0
+	for _, sess := range s.sessions.list() {
+		if sess.info().User == user {
+			log.Printf("session %d of %s killed", sess.id, user)
+			sess.kill()
+			n++
+		}
+	}
+	return n
+}
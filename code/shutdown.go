@@ -0,0 +1,82 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Run and Serve after Shutdown.
+var ErrServerClosed = errors.New("socks5: server closed")
+
+// shutdownPollInterval is how often Shutdown checks for sessions left
+const shutdownPollInterval = 100 * time.Millisecond
+
+type serving struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// track registers a listener being served, false after Shutdown
+func (s *SOCKS5Server) track(listener net.Listener) bool {
+	s.serving.mu.Lock()
+	defer s.serving.mu.Unlock()
+	if s.serving.closed {
+		return false
+	}
+	if s.serving.listeners == nil {
+		s.serving.listeners = make(map[net.Listener]struct{})
+	}
+	s.serving.listeners[listener] = struct{}{}
+	return true
+}
+
+func (s *SOCKS5Server) untrack(listener net.Listener) {
+	s.serving.mu.Lock()
+	defer s.serving.mu.Unlock()
+	delete(s.serving.listeners, listener)
+}
+
+// serveError is what serving a listener returns once it stopped with err
+func (s *SOCKS5Server) serveError(err error) error {
+	s.serving.mu.Lock()
+	defer s.serving.mu.Unlock()
+	if s.serving.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for the open sessions to end,
+// so another process can take over the listeners while forwarding goes on here.
+// When ctx is done first, the sessions left are killed and ctx's error returned.
+func (s *SOCKS5Server) Shutdown(ctx context.Context) error {
+	s.serving.mu.Lock()
+	s.serving.closed = true
+	for listener := range s.serving.listeners {
+		listener.Close()
+	}
+	s.serving.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		active := s.Stats().Active
+		if active == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("shutdown: killing %d sessions left", active)
+			for _, sess := range s.sessions.list() {
+				sess.kill()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
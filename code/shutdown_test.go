@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startInherited runs a server on an already open listener, as a child process handed it
+func startInherited(t *testing.T) (*SOCKS5Server, string, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SOCKS5Server{Listeners: []Listener{{
+		Label:     "inherited",
+		Inherited: ln,
+		Config:    &Config{AuthMethod: MethodNoAuth},
+	}}}
+	done := make(chan error, 1)
+	go func() { done <- s.Run() }()
+	return s, ln.Addr().String(), done
+}
+
+func echo(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	buf := make([]byte, len(msg))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(buf); err != nil || string(buf) != msg {
+		t.Fatalf("want %q echoed but got %q, %v", msg, buf, err)
+	}
+}
+
+func TestShutdownDrainsSessions(t *testing.T) {
+	target := startEchoServer(t)
+	s, proxy, done := startInherited(t)
+	d := &Dialer{ProxyAddress: proxy, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("want error %s but got %v", ErrServerClosed, err)
+	}
+	if c, err := d.Dial("tcp", target.String()); err == nil {
+		c.Close()
+		t.Fatal("want new connections refused after shutdown")
+	}
+
+	// the open session keeps forwarding until its client is done
+	echo(t, conn, "still here")
+	select {
+	case err := <-shutdown:
+		t.Fatalf("want shutdown to wait for the session but it returned %v", err)
+	default:
+	}
+	conn.Close()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return after the last session ended")
+	}
+}
+
+func TestShutdownKillsSessionsAtDeadline(t *testing.T) {
+	target := startEchoServer(t)
+	s, proxy, done := startInherited(t)
+	d := &Dialer{ProxyAddress: proxy, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", target.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	echo(t, conn, "hello")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("want error %s but got %v", context.DeadlineExceeded, err)
+	}
+	<-done
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("want the session closed after the deadline")
+	}
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	s := &SOCKS5Server{Config: &Config{AuthMethod: MethodNoAuth}}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(ln); err != ErrServerClosed {
+		t.Fatalf("want error %s but got %v", ErrServerClosed, err)
+	}
+	if _, err := ln.Accept(); err == nil {
+		t.Fatal("want the listener closed")
+	}
+}
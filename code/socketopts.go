@@ -0,0 +1,95 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+var ErrSocketOptionNotSupported = errors.New("socket option not supported on this platform")
+
+// SocketOptions are set on outbound sockets, set per Route or for a whole Config.
+// Mark, BindToDevice, TOS, KeepAliveInterval, KeepAliveCount and UserTimeout are Linux only,
+// dialing with them fails elsewhere.
+type SocketOptions struct {
+	// Mark is the SO_MARK fwmark policy routing matches on, it needs CAP_NET_ADMIN
+	Mark int
+	// BindToDevice sends through the named interface with SO_BINDTODEVICE
+	BindToDevice string
+	// TOS is IP_TOS for IPv4 and IPV6_TCLASS for IPv6, the DSCP shifted left by 2
+	TOS int
+
+	// KeepAlive is the idle time before keepalive probes, 15 seconds if zero, negative disables them
+	KeepAlive time.Duration
+	// KeepAliveInterval is the time between probes, in whole seconds rounded up,
+	// and KeepAliveCount the unanswered ones after which the connection is dropped
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+
+	// Nagle enables Nagle's algorithm, clearing the TCP_NODELAY Go sets by default
+	Nagle bool
+	// UserTimeout is TCP_USER_TIMEOUT, how long sent data may stay unacknowledged
+	UserTimeout time.Duration
+}
+
+// dial connects with d, setting the options on the socket, o may be nil
+func (o *SocketOptions) dial(ctx context.Context, d *net.Dialer, address string) (net.Conn, error) {
+	if o == nil {
+		return d.DialContext(ctx, "tcp", address)
+	}
+	d.KeepAlive = o.KeepAlive
+	if o.needsControl() {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) { err = o.control(network, fd) }); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	if o.Nagle {
+		tcp.SetNoDelay(false)
+	}
+	// Go sets the keepalive period on the connected socket, overriding earlier options
+	if o.KeepAliveInterval != 0 || o.KeepAliveCount != 0 {
+		if err := setKeepAliveOptions(tcp, o); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func setKeepAliveOptions(conn *net.TCPConn, o *SocketOptions) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) { sockErr = o.keepAlive(fd) }); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// keepAliveSeconds is the probe interval in whole seconds, rounded up as the kernel takes at least 1
+func keepAliveSeconds(d time.Duration) int {
+	if d <= time.Second {
+		return 1
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+func (o *SocketOptions) needsControl() bool {
+	return o.Mark != 0 || o.BindToDevice != "" || o.TOS != 0 || o.UserTimeout != 0
+}
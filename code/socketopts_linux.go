@@ -0,0 +1,59 @@
+//go:build linux
+
+package socks5
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT from linux/tcp.h, which package syscall lacks
+const tcpUserTimeout = 0x12
+
+// control sets the options on the socket fd before it connects
+func (o *SocketOptions) control(network string, fd uintptr) error {
+	s := int(fd)
+	if o.Mark != 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_MARK, o.Mark); err != nil {
+			return fmt.Errorf("SO_MARK: %w", err)
+		}
+	}
+	if o.BindToDevice != "" {
+		if err := syscall.SetsockoptString(s, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, o.BindToDevice); err != nil {
+			return fmt.Errorf("SO_BINDTODEVICE %s: %w", o.BindToDevice, err)
+		}
+	}
+	if o.TOS != 0 {
+		var err error
+		if network == "tcp6" {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS)
+		} else {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS)
+		}
+		if err != nil {
+			return fmt.Errorf("TOS: %w", err)
+		}
+	}
+	if o.UserTimeout != 0 {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, tcpUserTimeout, int(o.UserTimeout.Milliseconds())); err != nil {
+			return fmt.Errorf("TCP_USER_TIMEOUT: %w", err)
+		}
+	}
+	return nil
+}
+
+// keepAlive sets the keepalive probe interval and count on a connected socket
+func (o *SocketOptions) keepAlive(fd uintptr) error {
+	s := int(fd)
+	if o.KeepAliveInterval != 0 {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, keepAliveSeconds(o.KeepAliveInterval)); err != nil {
+			return fmt.Errorf("TCP_KEEPINTVL: %w", err)
+		}
+	}
+	if o.KeepAliveCount != 0 {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, o.KeepAliveCount); err != nil {
+			return fmt.Errorf("TCP_KEEPCNT: %w", err)
+		}
+	}
+	return nil
+}
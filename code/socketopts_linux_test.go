@@ -0,0 +1,108 @@
+//go:build linux
+
+package socks5
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return value
+}
+
+func TestSocketOptions(t *testing.T) {
+	echo := startEchoServer(t)
+	opts := &SocketOptions{
+		TOS:               0xb8, // DSCP EF
+		KeepAlive:         30 * time.Second,
+		KeepAliveInterval: 5 * time.Second,
+		KeepAliveCount:    4,
+		Nagle:             true,
+		UserTimeout:       10 * time.Second,
+	}
+	if os.Geteuid() == 0 {
+		opts.Mark = 42
+		opts.BindToDevice = "lo"
+	}
+	s := &SOCKS5Server{}
+	conn, err := s.dial(&Config{SocketOptions: opts}, &Request{Dst: AddrFromIP(echo.IP, uint16(echo.Port))})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name       string
+		level, opt int
+		want       int
+	}{
+		{"IP_TOS", syscall.IPPROTO_IP, syscall.IP_TOS, 0xb8},
+		{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 30},
+		{"TCP_KEEPINTVL", syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, 5},
+		{"TCP_KEEPCNT", syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 4},
+		{"TCP_NODELAY", syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 0},
+		{"TCP_USER_TIMEOUT", syscall.IPPROTO_TCP, tcpUserTimeout, 10000},
+	}
+	if opts.Mark != 0 {
+		tests = append(tests, struct {
+			name       string
+			level, opt int
+			want       int
+		}{"SO_MARK", syscall.SOL_SOCKET, syscall.SO_MARK, 42})
+	}
+	for _, test := range tests {
+		if got := getsockopt(t, conn, test.level, test.opt); got != test.want {
+			t.Fatalf("%s: want %d but got %d", test.name, test.want, got)
+		}
+	}
+}
+
+func TestSubSecondKeepAliveInterval(t *testing.T) {
+	echo := startEchoServer(t)
+	s := &SOCKS5Server{}
+	opts := &SocketOptions{KeepAliveInterval: 500 * time.Millisecond}
+	conn, err := s.dial(&Config{SocketOptions: opts}, &Request{Dst: AddrFromIP(echo.IP, uint16(echo.Port))})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	if got := getsockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL); got != 1 {
+		t.Fatalf("want the interval rounded up to 1 second but got %d", got)
+	}
+}
+
+func TestRouteSocketOptions(t *testing.T) {
+	echo := startEchoServer(t)
+	s := &SOCKS5Server{}
+	config := &Config{
+		SocketOptions: &SocketOptions{TOS: 0x20},
+		Router: &Router{Routes: []Route{
+			{Name: "voice", Ports: []uint16{uint16(echo.Port)}, SocketOptions: &SocketOptions{TOS: 0xb8}},
+		}},
+	}
+	conn, err := s.dial(config, &Request{Dst: AddrFromIP(echo.IP, uint16(echo.Port))})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	if got := getsockopt(t, conn, syscall.IPPROTO_IP, syscall.IP_TOS); got != 0xb8 {
+		t.Fatalf("want the route's TOS 0xb8 but got %#x", got)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package socks5
+
+func (o *SocketOptions) control(network string, fd uintptr) error {
+	return ErrSocketOptionNotSupported
+}
+
+func (o *SocketOptions) keepAlive(fd uintptr) error {
+	return ErrSocketOptionNotSupported
+}
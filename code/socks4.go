@@ -0,0 +1,229 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+/*
+SOCKS4 (https://www.openssh.com/txt/socks4.protocol) and
+SOCKS4a (https://www.openssh.com/txt/socks4a.protocol) are served on the
+same port as SOCKS5, told apart by the first byte.
+
+	+----+----+----+----+----+----+----+----+----+----+....+----+
+	| VN | CD | DSTPORT |      DSTIP        | USERID       |NULL|
+	+----+----+----+----+----+----+----+----+----+----+....+----+
+	   1    1      2              4           variable       1
+
+For SOCKS4a DSTIP is 0.0.0.x with x non-zero, and the domain name
+to resolve follows the USERID, also terminated by NULL.
+
+The server replies:
+
+	+----+----+----+----+----+----+----+----+
+	| VN | CD | DSTPORT |      DSTIP        |
+	+----+----+----+----+----+----+----+----+
+	   1    1      2              4
+
+	o  VN is 0
+	o  CD 90 request granted
+	o  CD 91 request rejected or failed
+	o  CD 92 rejected, the server cannot connect to identd on the client
+	o  CD 93 rejected, identd and the client report different user-ids
+*/
+const (
+	SOCKS4Version      = 0x04
+	SOCKS4ReplyVersion = 0x00
+)
+
+const (
+	SOCKS4Granted        byte = 90
+	SOCKS4Rejected       byte = 91
+	SOCKS4NoIdentd       byte = 92
+	SOCKS4IdentdMismatch byte = 93
+)
+
+const (
+	socks4MaxStringLength = 255
+	// a BIND waits at most two minutes for the incoming connection
+	socks4BindTimeout = 2 * time.Minute
+)
+
+var ErrSOCKS4StringTooLong = errors.New("socks4 user-id or domain name too long")
+
+type SOCKS4RequestMessage struct {
+	Cmd     Command
+	DstPort uint16
+	DstIP   net.IP
+	UserID  string
+	// Domain is set for SOCKS4a requests
+	Domain string
+}
+
+// Dst returns the address to connect to, the domain for SOCKS4a requests
+func (m *SOCKS4RequestMessage) Dst() Addr {
+	if m.Domain != "" {
+		return Addr{FQDN: m.Domain, Port: m.DstPort}
+	}
+	return AddrFromIP(m.DstIP, m.DstPort)
+}
+
+func NewSOCKS4RequestMessage(conn io.Reader) (*SOCKS4RequestMessage, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != SOCKS4Version {
+		return nil, ErrVersionNotSupported
+	}
+	if buf[1] != CmdConnect && buf[1] != CmdBind {
+		return nil, ErrRequestCommandNotSupported
+	}
+
+	message := SOCKS4RequestMessage{
+		Cmd:     buf[1],
+		DstPort: uint16(buf[2])<<8 | uint16(buf[3]),
+		DstIP:   net.IP(buf[4:8]),
+	}
+
+	userID, err := readNullString(conn)
+	if err != nil {
+		return nil, err
+	}
+	message.UserID = userID
+
+	// SOCKS4a, 0.0.0.x
+	ip := message.DstIP
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readNullString(conn)
+		if err != nil {
+			return nil, err
+		}
+		message.Domain = domain
+	}
+	return &message, nil
+}
+
+// readNullString reads a NULL terminated string one byte at a time,
+// so no bytes after the terminator are consumed from the connection.
+func readNullString(r io.Reader) (string, error) {
+	var s []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(s), nil
+		}
+		if len(s) == socks4MaxStringLength {
+			return "", ErrSOCKS4StringTooLong
+		}
+		s = append(s, b[0])
+	}
+}
+
+func WriteSOCKS4ReplyMessage(conn io.Writer, status byte, ip net.IP, port uint16) error {
+	buf := []byte{SOCKS4ReplyVersion, status, byte(port >> 8), byte(port), 0, 0, 0, 0}
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(buf[4:], ip4)
+	}
+	_, err := conn.Write(buf)
+	return err
+}
+
+func (s *SOCKS5Server) handleSOCKS4(conn *bufferedConn, config *Config, identity *User) error {
+	msg, err := NewSOCKS4RequestMessage(conn.r)
+	if err != nil {
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return err
+	}
+	log.Printf("socks4 request %d to %s from user-id %q", msg.Cmd, msg.Dst(), msg.UserID)
+
+	// SOCKS4 carries no password, only serve it when no authentication is required
+	// or the client is identified on the connection
+	if config.AuthMethod != MethodNoAuth && identity == nil {
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return ErrPasswordAuthFailure
+	}
+
+	req := &Request{
+		User:       identity,
+		ClientAddr: conn.RemoteAddr(),
+		Cmd:        msg.Cmd,
+		Dst:        msg.Dst(),
+	}
+	allowed, deadline := s.allow(conn, config, req)
+	if !allowed {
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return ErrConnectionNotAllowed
+	}
+
+	if msg.Cmd == CmdBind {
+		return s.handleSOCKS4Bind(conn, msg, deadline)
+	}
+
+	targetConn, err := s.dial(config, req)
+	if err != nil {
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return err
+	}
+	ip, port := tcpAddr(targetConn.LocalAddr())
+	if err := WriteSOCKS4ReplyMessage(conn, SOCKS4Granted, ip, port); err != nil {
+		targetConn.Close()
+		return err
+	}
+	return relay(conn, targetConn, deadline)
+}
+
+// handleSOCKS4Bind waits for a single incoming connection from the request's DSTIP.
+// The first reply tells the client where to point the application server,
+// the second one is sent when it has connected.
+func (s *SOCKS5Server) handleSOCKS4Bind(conn *bufferedConn, msg *SOCKS4RequestMessage, deadline time.Time) error {
+	// listen on the address the client reached us on, SOCKS4 replies only carry IPv4
+	localIP, _ := tcpAddr(conn.LocalAddr())
+	if localIP = localIP.To4(); localIP == nil {
+		localIP = net.IPv4zero
+	}
+	l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: localIP})
+	if err != nil {
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return err
+	}
+	defer l.Close()
+
+	bindIP, bindPort := tcpAddr(l.Addr())
+	if err := WriteSOCKS4ReplyMessage(conn, SOCKS4Granted, bindIP, bindPort); err != nil {
+		return err
+	}
+
+	l.SetDeadline(time.Now().Add(socks4BindTimeout))
+	targetConn, err := l.AcceptTCP()
+	if err != nil {
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return err
+	}
+	peerIP, peerPort := tcpAddr(targetConn.RemoteAddr())
+	// SOCKS4a binds name a host, so the peer cannot be checked against DSTIP
+	if msg.Domain == "" && !peerIP.Equal(msg.DstIP) {
+		targetConn.Close()
+		WriteSOCKS4ReplyMessage(conn, SOCKS4Rejected, nil, 0)
+		return ErrConnectionNotAllowed
+	}
+	if err := WriteSOCKS4ReplyMessage(conn, SOCKS4Granted, peerIP, peerPort); err != nil {
+		targetConn.Close()
+		return err
+	}
+	return relay(conn, targetConn, deadline)
+}
+
+// tcpAddr returns the IP and port of a TCP address, zero values for other networks
+func tcpAddr(addr net.Addr) (net.IP, uint16) {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a.IP, uint16(a.Port)
+	}
+	return nil, 0
+}
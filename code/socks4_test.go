@@ -0,0 +1,172 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewSOCKS4RequestMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		message SOCKS4RequestMessage
+		err     error
+	}{
+		{
+			name:    "socks4 connect",
+			input:   []byte{SOCKS4Version, CmdConnect, 0x00, 0x50, 1, 2, 3, 4, 'f', 'r', 'e', 'd', 0},
+			message: SOCKS4RequestMessage{Cmd: CmdConnect, DstPort: 80, DstIP: net.IP{1, 2, 3, 4}, UserID: "fred"},
+		},
+		{
+			name:    "socks4a connect",
+			input:   append([]byte{SOCKS4Version, CmdConnect, 0x01, 0xbb, 0, 0, 0, 1, 0}, "example.com\x00"...),
+			message: SOCKS4RequestMessage{Cmd: CmdConnect, DstPort: 443, DstIP: net.IP{0, 0, 0, 1}, Domain: "example.com"},
+		},
+		{
+			name:  "udp is not a socks4 command",
+			input: []byte{SOCKS4Version, CmdUDP, 0x00, 0x50, 1, 2, 3, 4, 0},
+			err:   ErrRequestCommandNotSupported,
+		},
+		{
+			name:  "user-id not terminated",
+			input: []byte{SOCKS4Version, CmdConnect, 0x00, 0x50, 1, 2, 3, 4, 'f'},
+			err:   io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := NewSOCKS4RequestMessage(bytes.NewReader(test.input))
+		if err != test.err && !(test.err == io.ErrUnexpectedEOF && err == io.EOF) {
+			t.Fatalf("%s: want error %v but got %v", test.name, test.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(*msg, test.message) {
+			t.Fatalf("%s: want %v but got %v", test.name, test.message, *msg)
+		}
+	}
+}
+
+func TestWriteSOCKS4ReplyMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSOCKS4ReplyMessage(&buf, SOCKS4Granted, net.IPv4(123, 123, 123, 123), 1234); err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	want := []byte{SOCKS4ReplyVersion, SOCKS4Granted, 4, 0xd2, 123, 123, 123, 123}
+	if got := buf.Bytes(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("should send %v, but sent %v", want, got)
+	}
+}
+
+// startEchoServer returns the address of a TCP server echoing everything back.
+func startEchoServer(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// startTestServer serves connections with handleConnection and returns its address.
+func startTestServer(t *testing.T, config *Config) string {
+	t.Helper()
+	s := &SOCKS5Server{Config: config}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s.handleConnection(conn, config)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSOCKS4Connect(t *testing.T) {
+	echo := startEchoServer(t)
+	port := []byte{byte(echo.Port >> 8), byte(echo.Port)}
+
+	tests := []struct {
+		name    string
+		config  *Config
+		request []byte
+		status  byte
+	}{
+		{
+			name:    "socks4",
+			config:  &Config{AuthMethod: MethodNoAuth},
+			request: append([]byte{SOCKS4Version, CmdConnect, port[0], port[1], 127, 0, 0, 1}, "fred\x00"...),
+			status:  SOCKS4Granted,
+		},
+		{
+			name:    "socks4a",
+			config:  &Config{AuthMethod: MethodNoAuth},
+			request: append([]byte{SOCKS4Version, CmdConnect, port[0], port[1], 0, 0, 0, 1}, "\x00localhost\x00"...),
+			status:  SOCKS4Granted,
+		},
+		{
+			name:    "rules apply",
+			config:  &Config{AuthMethod: MethodNoAuth, Rules: &AccessRules{Rules: []AccessRule{{Deny: true, Destinations: []string{"127.0.0.0/8"}}}}},
+			request: append([]byte{SOCKS4Version, CmdConnect, port[0], port[1], 127, 0, 0, 1}, "fred\x00"...),
+			status:  SOCKS4Rejected,
+		},
+		{
+			name:    "password required",
+			config:  &Config{AuthMethod: MethodPassword, PasswordChecker: func(string, string) bool { return true }},
+			request: append([]byte{SOCKS4Version, CmdConnect, port[0], port[1], 127, 0, 0, 1}, "fred\x00"...),
+			status:  SOCKS4Rejected,
+		},
+	}
+
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", startTestServer(t, test.config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write(test.request)
+		reply := make([]byte, 8)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatalf("%s: read reply: %s", test.name, err)
+		}
+		if reply[0] != SOCKS4ReplyVersion || reply[1] != test.status {
+			t.Fatalf("%s: want status %d but got reply %v", test.name, test.status, reply)
+		}
+		if test.status == SOCKS4Granted {
+			conn.Write([]byte("ping"))
+			got := make([]byte, 4)
+			if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+				t.Fatalf("%s: want echo ping but got %q, %v", test.name, got, err)
+			}
+		}
+		conn.Close()
+	}
+}
@@ -11,11 +11,18 @@
 package socks5
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,67 +34,206 @@ type SOCKS5Server struct {
 	IP     string
 	Port   int
 	Config *Config
+	// TLSConfig, if set, serves SOCKS over TLS
+	TLSConfig *tls.Config
+	// WebSocketPath, if set, serves SOCKS inside WebSocket connections on this HTTP path
+	WebSocketPath string
+
+	// Network is tcp by default, or unix
+	Network string
+	// Address, if set, is listened on instead of IP and Port.
+	// For unix it is the socket path, a leading @ names a Linux abstract socket.
+	Address    string
+	UnixSocket UnixSocketOptions
+	// TrustedProxies are the CIDRs, IPs or "unix" whose connections start with a PROXY protocol header
+	TrustedProxies []string
+
+	// Listeners, if set, are served by Run instead of the single listener above
+	Listeners []Listener
+	// Resolver looks up target host names for all listeners, net.DefaultResolver if nil
+	Resolver *net.Resolver
+	// MaxConnections limits the connections open at once across all listeners, 0 means no limit
+	MaxConnections int
+
+	stats    serverStats
+	sessions sessionRegistry
+	// serving are the listeners Shutdown closes
+	serving serving
+	// reloaded is set by Reload
+	reloaded atomic.Pointer[reloadedState]
 }
 
 func initConfig(config *Config) error {
-	if config.AuthMethod == MethodPassword && config.PasswordChecker == nil {
+	if config.AuthMethod == MethodPassword && config.PasswordChecker == nil && config.Authenticator == nil {
 		return ErrPasswordCheckerNotSet
 	}
 	return nil
 }
 
 func (s *SOCKS5Server) Run() error {
+	listeners := s.listeners()
+
 	// Initialize server configuration
-	if err := initConfig(s.Config); err != nil {
-		return err
+	for i := range listeners {
+		if err := initConfig(listeners[i].config(s)); err != nil {
+			return err
+		}
 	}
 
-	// Server IP and Port
-	address := fmt.Sprintf("%s:%d", s.IP, s.Port)
-	log.Printf("Server is connecting to %s", address)
-
 	// Listen specific address
 	// Listen announces on the local network address.
 	// What's socket https://www.bilibili.com/video/BV12A411X7gY
 	// Socket Bind -> Listen -> Accept
-	listener, err := net.Listen("tcp", address)
-	if err != nil {
-		return err
+	netListeners := make([]net.Listener, 0, len(listeners))
+	for i := range listeners {
+		if listeners[i].Inherited != nil {
+			log.Printf("start to serve inherited %s", listeners[i].name())
+			netListeners = append(netListeners, listeners[i].Inherited)
+			continue
+		}
+		log.Printf("Server is connecting to %s", listeners[i].Address)
+		listener, err := listeners[i].Listen()
+		if err != nil {
+			for _, l := range netListeners {
+				l.Close()
+			}
+			return err
+		}
+		log.Printf("start to listen %s", listeners[i].name())
+		netListeners = append(netListeners, listener)
 	}
-	log.Printf("start to listen %s", address)
 
+	errc := make(chan error, len(listeners))
+	for i := range listeners {
+		go func(listener net.Listener, l *Listener) {
+			errc <- s.serve(listener, l)
+		}(netListeners[i], &listeners[i])
+	}
+	return <-errc
+}
+
+// Serve accepts connections on listener, wrapping them in TLS or WebSocket as configured
+func (s *SOCKS5Server) Serve(listener net.Listener) error {
+	l := s.defaultListener()
+	return s.serve(listener, &l)
+}
+
+func (s *SOCKS5Server) serve(listener net.Listener, l *Listener) error {
+	if !s.track(listener) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(listener)
+
+	// the PROXY header comes before the TLS handshake
+	if len(l.TrustedProxies) > 0 {
+		pl, err := newProxyListener(listener, l.TrustedProxies)
+		if err != nil {
+			return err
+		}
+		listener = pl
+	}
+	if l.TLSConfig != nil {
+		listener = tls.NewListener(listener, l.TLSConfig)
+	}
+
+	if l.WebSocketPath != "" {
+		mux := http.NewServeMux()
+		mux.Handle(l.WebSocketPath, &webSocketHandler{server: s, listener: l})
+		return s.serveError(http.Serve(listener, mux))
+	}
+
+	var delay time.Duration
 	for {
 		// Connect Success, three-way handshake
 		// client connect, server accept
-		conn, err2 := listener.Accept()
-		log.Printf("Connecting Success")
-		if err2 != nil {
-			log.Printf("connection failure from %s: %s", conn.RemoteAddr(), err2)
-			continue
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return s.serveError(err)
 		}
+		if err != nil {
+			config, _ := s.current(l)
+			config.handleError(fmt.Errorf("%s: accept: %w", l.name(), err))
+			// such as running out of file descriptors, wait for some to be closed
+			if retryAccept(err) {
+				delay = acceptDelay(delay)
+				log.Printf("%s: accept failure: %s, retrying in %s", l.name(), err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		log.Printf("Connecting Success")
 
 		// goroutine handle socks5 connection
-		go func() {
-			// delay close connetion until later time
-			defer conn.Close()
+		go s.serveConn(conn, l)
+	}
+}
 
-			// get err from function
-			if err := s.handleConnection(conn, s.Config); err != nil {
-				log.Printf("handle connection failure from %s: %s", conn.RemoteAddr(), err)
-			}
-		}()
+// serveConn handles a connection accepted on l and closes it
+func (s *SOCKS5Server) serveConn(conn net.Conn, l *Listener) {
+	// delay close connetion until later time
+	defer conn.Close()
+
+	// the connection keeps this config for its whole life, even across a Reload
+	config, maxConnections := s.current(l)
+	if !s.stats.open(l.Label, maxConnections) {
+		log.Printf("%s: connection from %s refused, %d connections open", l.name(), conn.RemoteAddr(), maxConnections)
+		return
+	}
+	defer s.stats.close(l.Label)
+	// a bug hit by one connection must not take down the others
+	defer func() {
+		if v := recover(); v != nil {
+			s.stats.panicked(l.Label)
+			config.handleError(&PanicError{Listener: l.name(), RemoteAddr: conn.RemoteAddr(), Value: v, Stack: debug.Stack()})
+		}
+	}()
+
+	// get err from function
+	if err := s.handleConnection(conn, config); err != nil {
+		log.Printf("%s: handle connection failure from %s: %s", l.name(), conn.RemoteAddr(), err)
 	}
 }
 
-func (s *SOCKS5Server) handleConnection(conn net.Conn, config *Config) error {
+func (s *SOCKS5Server) handleConnection(conn net.Conn, config *Config) (err error) {
+	// Identify the client by its TLS certificate or Unix socket peer credentials
+	identity, err := connIdentity(conn, config)
+	if err != nil {
+		return err
+	}
+
+	// Register the session, it learns user and destination from the request
+	sess := s.sessions.open(conn)
+	defer func() { s.sessions.close(sess, err) }()
+	conn = sess.conn
+
+	// Peek the version byte, SOCKS4, SOCKS5 and HTTP share the port
+	bc := newBufferedConn(conn)
+	version, err := bc.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if version[0] == SOCKS4Version {
+		log.Printf("start socks4 request")
+		return s.handleSOCKS4(bc, config, identity)
+	}
+	if isHTTPMethodByte(version[0]) {
+		log.Printf("start http proxy request")
+		return s.handleHTTP(bc, config, identity)
+	}
+	conn = bc
+
 	// Negotiation
 	log.Printf("start negotiation")
-	if err := auth(conn, config); err != nil {
+	user, err := auth(conn, config, identity)
+	if err != nil {
 		return err
 	}
 	// Request
 	log.Printf("start request")
-	if err := s.request(conn); err != nil {
+	if err := s.request(conn, config, user); err != nil {
 		return err
 	}
 
@@ -101,7 +247,13 @@ func (s *SOCKS5Server) handleConnection(conn net.Conn, config *Config) error {
 func forward(conn io.ReadWriter, targetConn io.ReadWriteCloser) error {
 	defer targetConn.Close()
 
-	go io.Copy(targetConn, conn)
+	go func() {
+		io.Copy(targetConn, conn)
+		// pass the client's EOF on, so the session ends once the target is done too
+		if cw, ok := targetConn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
 
 	// recv, send
 	_, err := io.Copy(conn, targetConn)
@@ -109,20 +261,29 @@ func forward(conn io.ReadWriter, targetConn io.ReadWriteCloser) error {
 }
 
 // request
-func (s *SOCKS5Server) request(conn io.ReadWriter) error {
+func (s *SOCKS5Server) request(conn io.ReadWriter, config *Config, user *User) error {
 	// clientRequestMessage
 	// Read client request message from connection
 	clientReqMsg, err := NewClientRequestMessage(conn)
-
+	if errors.Is(err, ErrAddressTypeNotSupported) {
+		WriteRequestFailureMessage(conn, ReplyAddressTypeNotSupported)
+		return err
+	}
 	if err != nil {
 		return err
 	}
 
-	// Check if the address type is supported
-	log.Printf("start check ipv6")
-	if clientReqMsg.ATYP == TypeIPv6 {
-		WriteRequestFailureMessage(conn, ReplyAddressTypeNotSupported)
-		return ErrAddressTypeNotSupported
+	// Check the request against the rules
+	req := &Request{
+		User:       user,
+		ClientAddr: remoteAddr(conn),
+		Cmd:        clientReqMsg.Cmd,
+		Dst:        clientReqMsg.Dst,
+	}
+	allowed, deadline := s.allow(conn, config, req)
+	if !allowed {
+		WriteRequestFailureMessage(conn, ReplyConnectionNotAllowed)
+		return ErrConnectionNotAllowed
 	}
 
 	// Check if the command is supported
@@ -130,7 +291,7 @@ func (s *SOCKS5Server) request(conn io.ReadWriter) error {
 	// o  BIND X'02'
 	//    UDP X'03'
 	if clientReqMsg.Cmd == CmdConnect {
-		s.handleTCP(conn, clientReqMsg)
+		return s.handleTCP(conn, config, req, deadline)
 	} else if clientReqMsg.Cmd == CmdUDP {
 		s.handleUDP()
 	} else {
@@ -144,11 +305,15 @@ func (s *SOCKS5Server) handleUDP() {
 
 }
 
-func (s *SOCKS5Server) handleTCP(conn io.ReadWriter, clientReqMsg *ClientRequestMessage) error {
+// handleTCP connects to the target, a non-zero deadline ends the session at that time
+func (s *SOCKS5Server) handleTCP(conn io.ReadWriter, config *Config, req *Request, deadline time.Time) error {
 
 	// Request visit tartget TCP Service
-	address := fmt.Sprintf("%s:%d", clientReqMsg.DstAddr, clientReqMsg.DstPort)
-	targetConn, err := net.DialTimeout("tcp", address, s.Config.TCPTimeout)
+	targetConn, err := s.dial(config, req)
+	if errors.Is(err, ErrRouteRejected) {
+		WriteRequestFailureMessage(conn, ReplyConnectionNotAllowed)
+		return err
+	}
 	if err != nil {
 		WriteRequestFailureMessage(conn, ReplyConnectionRefused)
 		return err
@@ -156,30 +321,197 @@ func (s *SOCKS5Server) handleTCP(conn io.ReadWriter, clientReqMsg *ClientRequest
 
 	// Send success reply
 	// net.Addr: LocalAddr returns the local network address, if known.
-	addrValue := targetConn.LocalAddr()
-	addr := addrValue.(*net.TCPAddr)
-	if err := WriteRequestSuccessMessage(conn, addr.IP, uint16(addr.Port)); err != nil {
+	// It is not a TCP address when an upstream proxy is reached over a Unix socket
+	ip, port := tcpAddr(targetConn.LocalAddr())
+	if err := WriteRequestSuccessMessage(conn, ip, port); err != nil {
+		targetConn.Close()
 		return err
 	}
+	return relay(conn, targetConn, deadline)
+}
+
+// dial connects to a request's target address over the route the request takes
+func (s *SOCKS5Server) dial(config *Config, req *Request) (net.Conn, error) {
+	ctx := context.Background()
+	if config.TCPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.TCPTimeout)
+		defer cancel()
+	}
+	address := req.Dst.String()
+	if to, ok := config.Rewrites.Rewrite(address); ok {
+		log.Printf("destination %s rewritten to %s", address, to)
+		address = to
+	}
+	opts := config.SocketOptions
+	var route *Route
+	// route and dial the destination actually dialed, a rewritten one is resolved anew
+	routed := *req
+	if dst, err := ParseAddr(address); err == nil && dst != req.Dst {
+		routed.Dst, routed.DstIPs, routed.DstGeo = dst, nil, GeoInfo{}
+		if err := s.resolve(config, &routed); err != nil {
+			return nil, err
+		}
+		s.locate(config, &routed)
+	}
+	if config.Router != nil {
+		var i int
+		i, route = config.Router.Match(&routed)
+		log.Printf("destination %s takes %s", address, explainRoute(i, route))
+		if route.SocketOptions != nil {
+			opts = route.SocketOptions
+		}
+	}
+	targetConn, dst, err := s.dialRoute(ctx, config, route, &routed, address, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendProxyHeader(config, targetConn, req.ClientAddr, dst); err != nil {
+		targetConn.Close()
+		return nil, err
+	}
+	return targetConn, nil
+}
+
+// relay forwards between client and target, a non-zero deadline ends the session at that time
+func relay(conn io.ReadWriter, targetConn net.Conn, deadline time.Time) error {
+	if sess := sessionOf(conn); sess != nil {
+		sess.relaying(targetConn)
+	}
+	defer closeAt(conn, targetConn, deadline)()
 	return forward(conn, targetConn)
 }
 
+// closeAt closes both connections at a non-zero deadline, until the returned func is called
+func closeAt(conn io.ReadWriter, targetConn net.Conn, deadline time.Time) func() bool {
+	if deadline.IsZero() {
+		return func() bool { return false }
+	}
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		log.Printf("session to %s closed, access window ended", targetConn.RemoteAddr())
+		targetConn.Close()
+		if c, ok := conn.(io.Closer); ok {
+			c.Close()
+		}
+	})
+	return timer.Stop
+}
+
 type Config struct {
 	AuthMethod      Method
 	PasswordChecker func(username, password string) bool
-	TCPTimeout      time.Duration
+	// Authenticator replaces PasswordChecker when set
+	Authenticator PasswordAuthenticator
+	TCPTimeout    time.Duration
+	// Rules, if set, decide which requests are allowed
+	Rules RuleSet
+	// Dial, if set, connects to targets instead of net.Dialer
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// CertUser maps a verified TLS client certificate to a user,
+	// who then needs no RFC 1929 authentication. See CommonNameUser.
+	CertUser func(cert *x509.Certificate) *User
+	// PeerCredUser does the same for the peer credentials of Unix socket clients. See LocalUser.
+	PeerCredUser func(cred PeerCred) *User
+	// SendProxyHeader, 1 or 2, sends a PROXY protocol header of that version to targets,
+	// domain destinations are then resolved here to name the address connected to
+	SendProxyHeader byte
+	// Rewrites replace destinations after the rules allowed them, before dialing
+	Rewrites Rewrites
+	// Router chooses the outbound path of each request, all are dialed directly if nil
+	Router *Router
+	// AddressPreference orders the addresses of domain destinations, which are dialed
+	// in a race starting an attempt every ConnectionAttemptDelay, 250ms if zero
+	AddressPreference      AddressPreference
+	ConnectionAttemptDelay time.Duration
+	// SocketOptions are set on direct connections, unless the route has its own,
+	// not on connections to upstream proxies
+	SocketOptions *SocketOptions
+	// SourcePool chooses the local address of direct connections, which clients see in the reply
+	SourcePool *SourcePool
+	// GeoIP looks up the country and ASN of clients and destinations for rules, routes and the access log
+	// A domain destination is then resolved once, each address checked and only the allowed
+	// ones dialed; a domain that does not resolve is denied.
+	GeoIP GeoLookup
+	// ErrorHandler, if set, receives the accept errors of the listener and the
+	// panics recovered while serving its connections, as *PanicError
+	ErrorHandler func(err error)
+}
+
+// allow locates req, records it in the session of conn and checks it against the rules of config
+func (s *SOCKS5Server) allow(conn io.ReadWriter, config *Config, req *Request) (bool, time.Time) {
+	// a destination that cannot be resolved cannot be checked
+	if err := s.resolve(config, req); err != nil {
+		log.Printf("destination %s denied, not resolved: %s", req.Dst, err)
+		return false, time.Time{}
+	}
+	s.locate(config, req)
+	if sess := sessionOf(conn); sess != nil {
+		sess.request(req)
+	}
+	return config.allow(req)
+}
+
+// allow checks req against the configured rules. The resolved addresses of
+// a domain are checked one by one, only the allowed ones are kept for dialing.
+func (c *Config) allow(req *Request) (bool, time.Time) {
+	if c.Rules == nil {
+		return true, time.Time{}
+	}
+	if len(req.DstIPs) == 0 {
+		return c.Rules.Allow(req)
+	}
+	var allowed []net.IP
+	var deadline time.Time
+	for _, ip := range req.DstIPs {
+		r := *req
+		r.DstIPs = []net.IP{ip}
+		if c.GeoIP != nil {
+			r.DstGeo = c.GeoIP.Lookup(ip)
+		}
+		ok, d := c.Rules.Allow(&r)
+		if !ok {
+			log.Printf("destination %s address %s denied", req.Dst, ip)
+			continue
+		}
+		if len(allowed) == 0 {
+			deadline, req.DstGeo = d, r.DstGeo
+		}
+		allowed = append(allowed, ip)
+	}
+	if len(allowed) == 0 {
+		return false, time.Time{}
+	}
+	req.DstIPs = allowed
+	return true, deadline
+}
+
+func (c *Config) authenticator() PasswordAuthenticator {
+	if c.Authenticator != nil {
+		return c.Authenticator
+	}
+	return PasswordCheckerFunc(c.PasswordChecker)
 }
 
 // func auth(conn net.Conn) error {
-func auth(conn io.ReadWriter, config *Config) error {
+// auth returns the authenticated user, nil for no-auth.
+// A client already identified on the connection (identity) can skip authentication.
+func auth(conn io.ReadWriter, config *Config, identity *User) (*User, error) {
 	// Read client auth message
 	// clientAuthMethod
 	clientAuthMethod, err := NewClientAuthMessage(conn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Println("start: ", clientAuthMethod.Version, clientAuthMethod.NMethods, clientAuthMethod.Methods, "end.")
 
+	if identity != nil {
+		for _, method := range clientAuthMethod.Methods {
+			if method == MethodNoAuth {
+				return identity, NewServerAuthMessage(conn, MethodNoAuth)
+			}
+		}
+	}
+
 	// Only support no-auth
 	// if cam.Methods.contains(no-auth) {
 	// 	return noacceptable
@@ -196,12 +528,12 @@ func auth(conn io.ReadWriter, config *Config) error {
 
 	if !acceptable {
 		NewServerAuthMessage(conn, MethodNoAcceptable)
-		return errors.New("not acceptable for method no auth or username/password")
+		return nil, errors.New("not acceptable for method no auth or username/password")
 	}
 
 	// func NewServerAuthMessage(conn io.Writer, method byte) error
 	if err := NewServerAuthMessage(conn, currentMethod); err != nil {
-		return err
+		return nil, err
 	}
 
 	/*
@@ -223,16 +555,33 @@ func auth(conn io.ReadWriter, config *Config) error {
 	if currentMethod == MethodPassword {
 		clientPasswordMessage, err := NewPasswordAuthMessage(conn)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if !config.PasswordChecker(clientPasswordMessage.Username, clientPasswordMessage.Password) {
+		user, err := config.authenticator().Authenticate(clientPasswordMessage.Username, clientPasswordMessage.Password, remoteAddr(conn))
+		if err != nil {
 			WriteServerPasswordMessage(conn, PasswordAuthFailure)
-			return ErrPasswordAuthFailure
+			return nil, err
 		}
 		// Auth Success
 		if err = WriteServerPasswordMessage(conn, PasswordAuthSuccess); err != nil {
-			return err
+			return nil, err
 		}
+		return user, nil
 	}
-	return nil
+	return nil, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a bufio.Reader,
+// so the first bytes can be peeked at before the protocol is known.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
 }
@@ -24,7 +24,7 @@ func TestAuth(t *testing.T) {
 	t.Run("should pass", func(t *testing.T) {
 		var buf bytes.Buffer
 		buf.Write([]byte{SOCKS5Version, 2, MethodNoAuth, MethodGSSAPI})
-		err := auth(&buf, &config)
+		_, err := auth(&buf, &config, nil)
 		if err != nil {
 			t.Fatalf("should get error nil but got %s", err)
 		}
@@ -39,7 +39,7 @@ func TestAuth(t *testing.T) {
 	t.Run("an invalid client auth message", func(t *testing.T) {
 		var buf bytes.Buffer
 		buf.Write([]byte{SOCKS5Version, 2, MethodNoAuth})
-		if err := auth(&buf, &config); err == nil {
+		if _, err := auth(&buf, &config, nil); err == nil {
 			t.Fatalf("should get error EOF but got nil")
 		}
 	})
@@ -0,0 +1,140 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// SourceStrategy is how a SourcePool picks the local address of a request.
+type SourceStrategy int
+
+const (
+	// SourceRoundRobin takes the addresses in turn
+	SourceRoundRobin SourceStrategy = iota
+	// SourceRandom takes a random address
+	SourceRandom
+	// SourcePerUser gives every user a fixed address, pinned in SourcePool.Users or hashed from the name
+	SourcePerUser
+	// SourceSticky keeps the requests of a session key, the client IP by default, on one address
+	SourceSticky
+)
+
+func (s SourceStrategy) String() string {
+	switch s {
+	case SourceRoundRobin:
+		return "round-robin"
+	case SourceRandom:
+		return "random"
+	case SourcePerUser:
+		return "per-user"
+	case SourceSticky:
+		return "sticky"
+	}
+	return "SourceStrategy(" + strconv.Itoa(int(s)) + ")"
+}
+
+var ErrNoSourceAddress = errors.New("no source address of the destination's address family")
+
+// SourcePool chooses the local address direct connections are dialed from.
+// Only addresses of the destination's family are considered.
+type SourcePool struct {
+	// Addresses are local IP addresses or interface names, whose addresses all join the pool
+	Addresses []string
+	Strategy  SourceStrategy
+
+	// Users pins users to a source address with SourcePerUser, such as for partner allowlists
+	Users map[string]string
+	// StickyKey returns the session key of a request for SourceSticky, the client IP if nil
+	StickyKey func(req *Request) string
+
+	next atomic.Uint64
+}
+
+// Pick returns the source address of a connection to dst for req.
+func (p *SourcePool) Pick(req *Request, dst net.IP) (net.IP, error) {
+	ipv4 := dst.To4() != nil
+	if req.User != nil && p.Strategy == SourcePerUser {
+		if pinned := net.ParseIP(p.Users[req.User.Name]); pinned != nil && (pinned.To4() != nil) == ipv4 {
+			return pinned, nil
+		}
+	}
+	candidates, err := p.addresses(ipv4)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoSourceAddress
+	}
+
+	switch p.Strategy {
+	case SourceRandom:
+		return candidates[rand.Intn(len(candidates))], nil
+	case SourcePerUser:
+		if req.User != nil {
+			return pickHashed(candidates, req.User.Name), nil
+		}
+		return pickHashed(candidates, clientKey(req)), nil
+	case SourceSticky:
+		key := clientKey(req)
+		if p.StickyKey != nil {
+			key = p.StickyKey(req)
+		}
+		return pickHashed(candidates, key), nil
+	}
+	return candidates[(p.next.Add(1)-1)%uint64(len(candidates))], nil
+}
+
+// addresses returns the pool's addresses of one family, expanding interface names
+func (p *SourcePool) addresses(ipv4 bool) ([]net.IP, error) {
+	var ips []net.IP
+	for _, a := range p.Addresses {
+		if ip := net.ParseIP(a); ip != nil {
+			if (ip.To4() != nil) == ipv4 {
+				ips = append(ips, ip)
+			}
+			continue
+		}
+		ifi, err := net.InterfaceByName(a)
+		if err != nil {
+			return nil, fmt.Errorf("source pool: %w", err)
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("source pool: %w", err)
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() || (ipNet.IP.To4() != nil) != ipv4 {
+				continue
+			}
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// pickHashed picks by rendezvous hashing, so a key keeps its address while the pool changes around it
+func pickHashed(ips []net.IP, key string) net.IP {
+	var best net.IP
+	var bestScore uint64
+	for _, ip := range ips {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write(ip.To16())
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = ip, score
+		}
+	}
+	return best
+}
+
+func clientKey(req *Request) string {
+	ip, _ := tcpAddr(req.ClientAddr)
+	return ip.String()
+}
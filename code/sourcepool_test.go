@@ -0,0 +1,84 @@
+package socks5
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSourcePoolPick(t *testing.T) {
+	dst4, dst6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	alice := &Request{User: &User{Name: "alice"}, ClientAddr: &net.TCPAddr{IP: net.IP{198, 51, 100, 1}}}
+	bob := &Request{User: &User{Name: "bob"}, ClientAddr: &net.TCPAddr{IP: net.IP{198, 51, 100, 2}}}
+
+	pool := &SourcePool{Addresses: []string{"10.0.0.1", "10.0.0.2", "2001:db8:1::1", "10.0.0.3"}}
+	for _, want := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"} {
+		if got, err := pool.Pick(alice, dst4); err != nil || got.String() != want {
+			t.Fatalf("round robin: want %s but got %s, %v", want, got, err)
+		}
+	}
+	if got, err := pool.Pick(alice, dst6); err != nil || got.String() != "2001:db8:1::1" {
+		t.Fatalf("want the IPv6 source for an IPv6 destination but got %s, %v", got, err)
+	}
+
+	pool = &SourcePool{
+		Addresses: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		Strategy:  SourcePerUser,
+		Users:     map[string]string{"alice": "10.0.0.9"},
+	}
+	if got, _ := pool.Pick(alice, dst4); got.String() != "10.0.0.9" {
+		t.Fatalf("want alice pinned to 10.0.0.9 but got %s", got)
+	}
+	first, _ := pool.Pick(bob, dst4)
+	for i := 0; i < 5; i++ {
+		if got, _ := pool.Pick(bob, dst4); !got.Equal(first) {
+			t.Fatalf("want bob kept on %s but got %s", first, got)
+		}
+	}
+	if _, err := pool.Pick(bob, dst6); err != ErrNoSourceAddress {
+		t.Fatalf("want error %s but got %v", ErrNoSourceAddress, err)
+	}
+
+	pool = &SourcePool{
+		Addresses: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		Strategy:  SourceSticky,
+		StickyKey: func(req *Request) string { return "team" },
+	}
+	a, _ := pool.Pick(alice, dst4)
+	b, _ := pool.Pick(bob, dst4)
+	if !a.Equal(b) {
+		t.Fatalf("want one address for one sticky key but got %s and %s", a, b)
+	}
+}
+
+func TestSourcePoolConnect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.3 needs the whole loopback network")
+	}
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	sources := make(chan net.Addr, 1)
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		sources <- conn.RemoteAddr()
+		conn.Close()
+	}()
+
+	config := &Config{AuthMethod: MethodNoAuth, SourcePool: &SourcePool{Addresses: []string{"127.0.0.3"}}}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	if ip, _ := tcpAddr(<-sources); !ip.Equal(net.IP{127, 0, 0, 3}) {
+		t.Fatalf("want connection from 127.0.0.3 but got %s", ip)
+	}
+}
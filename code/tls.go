@@ -0,0 +1,121 @@
+package socks5
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// connIdentity returns the user a connection is identified as before any
+// SOCKS negotiation, from a verified TLS client certificate or Unix socket peer credentials.
+// It returns nil if the connection carries no identity.
+func connIdentity(conn net.Conn, config *Config) (*User, error) {
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		return unixIdentity(unixConn, config)
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	if config.CertUser == nil || len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	user := config.CertUser(state.VerifiedChains[0][0])
+	if user != nil {
+		log.Printf("client %s identified as %s by certificate", conn.RemoteAddr(), user.Name)
+	}
+	return user, nil
+}
+
+// CommonNameUser maps a client certificate to the user named by its subject common name.
+func CommonNameUser(cert *x509.Certificate) *User {
+	if cert.Subject.CommonName == "" {
+		return nil
+	}
+	return &User{Name: cert.Subject.CommonName}
+}
+
+// EmailSANUser maps a client certificate to the user named by its first email SAN.
+func EmailSANUser(cert *x509.Certificate) *User {
+	if len(cert.EmailAddresses) == 0 {
+		return nil
+	}
+	return &User{Name: cert.EmailAddresses[0]}
+}
+
+// CertificateReloader serves a certificate and key pair from files,
+// loading them again when either file changes. Use its GetCertificate
+// method in tls.Config.
+type CertificateReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+	checked  time.Time
+}
+
+// files are checked for changes at most this often
+const certificateCheckInterval = 5 * time.Second
+
+// NewCertificateReloader loads the certificate and key pair for the first time.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) reload() error {
+	certInfo, err := os.Stat(r.CertFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && certInfo.ModTime().Equal(r.certTime) && keyInfo.ModTime().Equal(r.keyTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.certTime = certInfo.ModTime()
+	r.keyTime = keyInfo.ModTime()
+	return nil
+}
+
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); r.cert == nil || now.Sub(r.checked) >= certificateCheckInterval {
+		r.checked = now
+		if err := r.reload(); err != nil {
+			if r.cert == nil {
+				return nil, err
+			}
+			// keep serving the last good certificate
+			log.Printf("reload certificate %s: %s", r.CertFile, err)
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,209 @@
+package socks5
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a leaf certificate for localhost, usable by servers and clients
+func (ca *testCA) issue(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writeKeyPair(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+// startTLSTestServer is startTestServer behind a TLS listener
+func startTLSTestServer(t *testing.T, config *Config, tlsConfig *tls.Config) string {
+	t.Helper()
+	s := &SOCKS5Server{Config: config, TLSConfig: tlsConfig}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	tl := tls.NewListener(l, tlsConfig)
+	go func() {
+		for {
+			conn, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s.handleConnection(conn, config)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	echo := startEchoServer(t)
+
+	config := &Config{
+		AuthMethod: MethodPassword,
+		PasswordChecker: func(username, password string) bool {
+			return username == "admin" && password == "123456"
+		},
+		CertUser: CommonNameUser,
+		Rules: &AccessRules{
+			Rules:       []AccessRule{{Users: []string{"alice", "admin"}}},
+			DefaultDeny: true,
+		},
+	}
+	proxyAddr := startTLSTestServer(t, config, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "proxy")},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ca.pool,
+	})
+
+	dial := func(d *Dialer) error {
+		d.ProxyAddress = proxyAddr
+		d.Timeout = 5 * time.Second
+		conn, err := d.Dial("tcp", echo.String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.Write([]byte("ping"))
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	t.Run("certificate user skips password", func(t *testing.T) {
+		err := dial(&Dialer{TLSConfig: &tls.Config{
+			RootCAs:      ca.pool,
+			Certificates: []tls.Certificate{ca.issue(t, "alice")},
+		}})
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+	})
+
+	t.Run("certificate user is subject to rules", func(t *testing.T) {
+		err := dial(&Dialer{TLSConfig: &tls.Config{
+			RootCAs:      ca.pool,
+			Certificates: []tls.Certificate{ca.issue(t, "bob")},
+		}})
+		if _, ok := err.(*ReplyError); !ok {
+			t.Fatalf("want reply error but got %v", err)
+		}
+	})
+
+	t.Run("no certificate falls back to password", func(t *testing.T) {
+		if err := dial(&Dialer{TLSConfig: &tls.Config{RootCAs: ca.pool}}); err != ErrNoAcceptableMethod {
+			t.Fatalf("want error %s but got %v", ErrNoAcceptableMethod, err)
+		}
+		err := dial(&Dialer{TLSConfig: &tls.Config{RootCAs: ca.pool}, Username: "admin", Password: "123456"})
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+	})
+}
+
+func TestCertificateReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, ca.issue(t, "first"))
+
+	r, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	cert, _ := r.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "first" {
+		t.Fatalf("want certificate first but got %s", leaf.Subject.CommonName)
+	}
+
+	writeKeyPair(t, dir, ca.issue(t, "second"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	r.checked = time.Time{}
+
+	cert, _ = r.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "second" {
+		t.Fatalf("want certificate second but got %s", leaf.Subject.CommonName)
+	}
+
+	// a broken file keeps the last good certificate
+	os.WriteFile(keyFile, []byte("broken"), 0o600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	r.checked = time.Time{}
+	if cert, err := r.GetCertificate(nil); err != nil || cert == nil {
+		t.Fatalf("want last good certificate but got %v, %v", cert, err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package socks5
+
+import (
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the umask changes of ListenUnix, the umask is process wide
+var umaskMu sync.Mutex
+
+// withUmask runs f with the file mode creation mask set to mask
+func withUmask(mask int, f func() error) error {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	defer syscall.Umask(syscall.Umask(mask))
+	return f()
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package socks5
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWithUmask(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	before := syscall.Umask(0o022)
+	defer syscall.Umask(before)
+	err := withUmask(0o177, func() error {
+		return os.WriteFile(path, nil, 0o666)
+	})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("want mode 0600 but got %o", info.Mode().Perm())
+	}
+	if umask := syscall.Umask(0o022); umask != 0o022 {
+		t.Fatalf("want the umask restored to 022 but got %o", umask)
+	}
+}
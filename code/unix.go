@@ -0,0 +1,178 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+/*
+SOCKS over Unix domain sockets, for local clients such as containers
+sharing a socket file. A path starting with @ is a Linux abstract socket,
+which has no file and so no mode or owner.
+
+On Linux the uid, gid and pid of the connecting process are read with
+SO_PEERCRED and can identify the client through Config.PeerCredUser,
+the same way a TLS client certificate does.
+*/
+
+// UnixSocketOptions sets the permissions of a Unix socket file.
+type UnixSocketOptions struct {
+	// Mode of the socket file, left as created when zero
+	Mode os.FileMode
+	// User and Group own the socket file, as names or numeric ids.
+	// Empty leaves the owner unchanged.
+	User  string
+	Group string
+}
+
+// PeerCred is the identity of the process on the other end of a Unix socket.
+type PeerCred struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+var ErrPeerCredNotSupported = errors.New("peer credentials not supported on this platform")
+
+// ListenUnix listens on the Unix socket path, replacing a stale socket file
+// left by an earlier run, and applies the file mode and owner of opts.
+// With a mode the socket is created accessible to this user only until then.
+func ListenUnix(path string, opts UnixSocketOptions) (net.Listener, error) {
+	abstract := strings.HasPrefix(path, "@")
+	if !abstract {
+		// only ever remove a socket, never a regular file given by mistake
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var listener net.Listener
+	listen := func() (err error) {
+		listener, err = net.Listen("unix", path)
+		return err
+	}
+	var err error
+	if opts.Mode != 0 && !abstract {
+		// created accessible to this user only until the mode and owner are applied
+		err = withUmask(0o177, listen)
+	} else {
+		err = listen()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if abstract {
+		return listener, nil
+	}
+
+	if err := applyUnixSocketOptions(path, opts); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// applyUnixSocketOptions sets the owner before the mode, so the mode never
+// opens the socket to the group it was created with
+func applyUnixSocketOptions(path string, opts UnixSocketOptions) error {
+	if err := chownUnixSocket(path, opts); err != nil {
+		return err
+	}
+	if opts.Mode != 0 {
+		return os.Chmod(path, opts.Mode)
+	}
+	return nil
+}
+
+func chownUnixSocket(path string, opts UnixSocketOptions) error {
+	if opts.User == "" && opts.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if opts.User != "" {
+		id, err := lookupID(opts.User, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		uid = id
+	}
+	if opts.Group != "" {
+		id, err := lookupID(opts.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		gid = id
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID returns a numeric id as is and resolves a name with lookup
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	s, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("non-numeric id %q for %s", s, nameOrID)
+	}
+	return id, nil
+}
+
+// unixIdentity returns the user a Unix socket client is identified as by its peer credentials
+func unixIdentity(conn *net.UnixConn, config *Config) (*User, error) {
+	if config.PeerCredUser == nil {
+		return nil, nil
+	}
+	cred, err := peerCred(conn)
+	if err != nil {
+		return nil, err
+	}
+	user := config.PeerCredUser(cred)
+	if user != nil {
+		log.Printf("client uid %d pid %d identified as %s by peer credentials", cred.UID, cred.PID, user.Name)
+	}
+	return user, nil
+}
+
+// LocalUser maps peer credentials to the local account with that uid,
+// with its groups. Unknown uids are named by number.
+func LocalUser(cred PeerCred) *User {
+	uid := strconv.FormatUint(uint64(cred.UID), 10)
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return &User{Name: uid}
+	}
+	result := &User{Name: u.Username}
+	if gids, err := u.GroupIds(); err == nil {
+		for _, gid := range gids {
+			if g, err := user.LookupGroupId(gid); err == nil {
+				result.Groups = append(result.Groups, g.Name)
+			}
+		}
+	}
+	return result
+}
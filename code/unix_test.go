@@ -0,0 +1,123 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// startUnixTestServer serves connections on a Unix socket with handleConnection.
+func startUnixTestServer(t *testing.T, path string, opts UnixSocketOptions, config *Config) {
+	t.Helper()
+	s := &SOCKS5Server{Config: config}
+	l, err := ListenUnix(path, opts)
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s.handleConnection(conn, config)
+			}()
+		}
+	}()
+}
+
+func TestListenUnixMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socks.sock")
+	// a stale socket from an earlier run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := ListenUnix(path, UnixSocketOptions{Mode: 0600})
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer l.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("want mode 0600 but got %o", info.Mode().Perm())
+	}
+}
+
+func TestListenUnixKeepsRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socks.sock")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := ListenUnix(path, UnixSocketOptions{}); err == nil {
+		l.Close()
+		t.Fatal("should not listen over a regular file")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Fatalf("regular file should be kept but got %q, %v", data, err)
+	}
+}
+
+func TestUnixConnect(t *testing.T) {
+	echo := startEchoServer(t)
+	path := filepath.Join(t.TempDir(), "socks.sock")
+	startUnixTestServer(t, path, UnixSocketOptions{}, &Config{AuthMethod: MethodNoAuth})
+
+	d := &Dialer{ProxyNetwork: "unix", ProxyAddress: path, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("want echo ping but got %q, %v", got, err)
+	}
+}
+
+func TestUnixPeerCredUser(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is linux only")
+	}
+	echo := startEchoServer(t)
+	// an abstract socket, no file involved
+	path := fmt.Sprintf("@go-socks5-test-%d", os.Getpid())
+
+	creds := make(chan PeerCred, 1)
+	config := &Config{
+		AuthMethod:      MethodPassword,
+		PasswordChecker: func(string, string) bool { return false },
+		PeerCredUser: func(cred PeerCred) *User {
+			creds <- cred
+			return LocalUser(cred)
+		},
+	}
+	startUnixTestServer(t, path, UnixSocketOptions{}, config)
+
+	// no password is sent, the peer credentials identify the client
+	d := &Dialer{ProxyNetwork: "unix", ProxyAddress: path, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Close()
+	got := <-creds
+	if got.UID != uint32(os.Getuid()) || got.PID != int32(os.Getpid()) {
+		t.Fatalf("want uid %d pid %d but got %+v", os.Getuid(), os.Getpid(), got)
+	}
+}
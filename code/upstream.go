@@ -0,0 +1,352 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy is how an UpstreamGroup picks the upstream of a request.
+type BalanceStrategy int
+
+const (
+	// BalanceRoundRobin takes the upstreams in turn
+	BalanceRoundRobin BalanceStrategy = iota
+	// BalanceRandom takes a random upstream
+	BalanceRandom
+	// BalanceLeastConnections takes the upstream with the fewest open connections
+	BalanceLeastConnections
+	// BalanceLatency takes the upstream that connected fastest lately
+	BalanceLatency
+	// BalanceHashUser keeps the requests of a user on the same upstream
+	BalanceHashUser
+	// BalanceHashDestination keeps the requests to a destination on the same upstream
+	BalanceHashDestination
+)
+
+func (b BalanceStrategy) String() string {
+	switch b {
+	case BalanceRoundRobin:
+		return "round-robin"
+	case BalanceRandom:
+		return "random"
+	case BalanceLeastConnections:
+		return "least-connections"
+	case BalanceLatency:
+		return "latency"
+	case BalanceHashUser:
+		return "hash-user"
+	case BalanceHashDestination:
+		return "hash-destination"
+	}
+	return "BalanceStrategy(" + strconv.Itoa(int(b)) + ")"
+}
+
+var ErrNoUpstream = errors.New("upstream group has no upstreams")
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultFailureThreshold    = 3
+	defaultEjectDuration       = 30 * time.Second
+)
+
+// Upstream is a proxy of an UpstreamGroup.
+type Upstream struct {
+	Name   string
+	Dialer ContextDialer
+
+	active atomic.Int64
+	// latency is a moving average of the connect time in nanoseconds, 0 until measured
+	latency atomic.Int64
+
+	mu           sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+}
+
+// UpstreamStatus is a snapshot of an upstream.
+type UpstreamStatus struct {
+	Name     string        `json:"name"`
+	Healthy  bool          `json:"healthy"`
+	Active   int64         `json:"active"`
+	Latency  time.Duration `json:"latency"`
+	Failures int           `json:"failures"`
+}
+
+// UpstreamGroup balances requests over upstream proxies, failing over to the
+// next upstream when a dial fails. It is a ContextDialer for Router.Upstreams.
+//
+// FailureThreshold consecutive failures, of dials or health checks, eject an
+// upstream for EjectDuration. Ejected upstreams are only tried when no other is left.
+type UpstreamGroup struct {
+	Name      string
+	Upstreams []*Upstream
+	Strategy  BalanceStrategy
+
+	// HealthCheckTarget is dialed through every upstream each HealthCheckInterval
+	// once Start is called, such as "example.com:443". Empty disables health checks.
+	HealthCheckTarget   string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	FailureThreshold int
+	EjectDuration    time.Duration
+
+	next      atomic.Uint64
+	startOnce sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type requestContextKey struct{}
+
+// DialContext connects to address through the upstreams, in the order of the strategy.
+func (g *UpstreamGroup) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var key string
+	switch g.Strategy {
+	case BalanceHashUser:
+		if req, _ := ctx.Value(requestContextKey{}).(*Request); req != nil && req.User != nil {
+			key = req.User.Name
+		} else if req != nil {
+			ip, _ := tcpAddr(req.ClientAddr)
+			key = ip.String()
+		}
+	case BalanceHashDestination:
+		key = address
+	}
+	upstreams := g.order(key)
+	if len(upstreams) == 0 {
+		return nil, ErrNoUpstream
+	}
+
+	var errs []error
+	for _, u := range upstreams {
+		conn, err := g.dial(ctx, u, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("upstream %s: %w", u.Name, err))
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("upstream %s of group %s failed for %s, failing over: %s", u.Name, g.Name, address, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (g *UpstreamGroup) dial(ctx context.Context, u *Upstream, network, address string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := u.Dialer.DialContext(ctx, network, address)
+	var reply *ReplyError
+	switch {
+	case err == nil:
+		u.measure(time.Since(start))
+		g.succeeded(u)
+	case errors.As(err, &reply):
+		// the upstream works, it could not reach the destination
+		u.measure(time.Since(start))
+	case ctx.Err() == nil:
+		g.failed(u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.active.Add(1)
+	return &upstreamConn{Conn: conn, upstream: u}, nil
+}
+
+// order returns the upstreams in the order to try them, healthy ones first
+func (g *UpstreamGroup) order(key string) []*Upstream {
+	now := time.Now()
+	var healthy, ejected []*Upstream
+	for _, u := range g.Upstreams {
+		if u.ejected(now) {
+			ejected = append(ejected, u)
+		} else {
+			healthy = append(healthy, u)
+		}
+	}
+
+	switch g.Strategy {
+	case BalanceRoundRobin:
+		if n := len(healthy); n > 0 {
+			i := int(g.next.Add(1) % uint64(n))
+			healthy = append(append([]*Upstream(nil), healthy[i:]...), healthy[:i]...)
+		}
+	case BalanceRandom:
+		rand.Shuffle(len(healthy), func(i, j int) { healthy[i], healthy[j] = healthy[j], healthy[i] })
+	case BalanceLeastConnections:
+		sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].active.Load() < healthy[j].active.Load() })
+	case BalanceLatency:
+		// unmeasured upstreams go first to get measured
+		sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].latency.Load() < healthy[j].latency.Load() })
+	case BalanceHashUser, BalanceHashDestination:
+		// rendezvous hashing, only the keys of an ejected upstream move
+		sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].score(key) > healthy[j].score(key) })
+	}
+	return append(healthy, ejected...)
+}
+
+// Start runs the health checks until Close, it does nothing without HealthCheckTarget.
+func (g *UpstreamGroup) Start() {
+	if g.HealthCheckTarget == "" {
+		return
+	}
+	g.startOnce.Do(func() {
+		g.done = make(chan struct{})
+		interval := g.HealthCheckInterval
+		if interval == 0 {
+			interval = defaultHealthCheckInterval
+		}
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				g.checkHealth()
+				select {
+				case <-ticker.C:
+				case <-g.done:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Close stops the health checks.
+func (g *UpstreamGroup) Close() error {
+	g.startOnce.Do(func() {})
+	g.closeOnce.Do(func() {
+		if g.done != nil {
+			close(g.done)
+		}
+	})
+	return nil
+}
+
+// checkHealth dials the health check target through every upstream at once
+func (g *UpstreamGroup) checkHealth() {
+	timeout := g.HealthCheckTimeout
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	var wg sync.WaitGroup
+	for _, u := range g.Upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			start := time.Now()
+			conn, err := u.Dialer.DialContext(ctx, "tcp", g.HealthCheckTarget)
+			if err != nil {
+				log.Printf("health check of upstream %s of group %s failed: %s", u.Name, g.Name, err)
+				g.failed(u)
+				return
+			}
+			conn.Close()
+			u.measure(time.Since(start))
+			g.succeeded(u)
+		}(u)
+	}
+	wg.Wait()
+}
+
+// Status returns the state of every upstream.
+func (g *UpstreamGroup) Status() []UpstreamStatus {
+	now := time.Now()
+	status := make([]UpstreamStatus, len(g.Upstreams))
+	for i, u := range g.Upstreams {
+		u.mu.Lock()
+		failures := u.failures
+		u.mu.Unlock()
+		status[i] = UpstreamStatus{
+			Name:     u.Name,
+			Healthy:  !u.ejected(now),
+			Active:   u.active.Load(),
+			Latency:  time.Duration(u.latency.Load()),
+			Failures: failures,
+		}
+	}
+	return status
+}
+
+func (g *UpstreamGroup) failed(u *Upstream) {
+	threshold := g.FailureThreshold
+	if threshold == 0 {
+		threshold = defaultFailureThreshold
+	}
+	eject := g.EjectDuration
+	if eject == 0 {
+		eject = defaultEjectDuration
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failures++
+	if u.failures >= threshold {
+		if time.Now().After(u.ejectedUntil) {
+			log.Printf("upstream %s of group %s ejected after %d failures", u.Name, g.Name, u.failures)
+		}
+		u.ejectedUntil = time.Now().Add(eject)
+	}
+}
+
+func (g *UpstreamGroup) succeeded(u *Upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.ejectedUntil.IsZero() {
+		log.Printf("upstream %s of group %s restored", u.Name, g.Name)
+	}
+	u.failures = 0
+	u.ejectedUntil = time.Time{}
+}
+
+func (u *Upstream) ejected(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return now.Before(u.ejectedUntil)
+}
+
+// measure adds a connect time to the moving average
+func (u *Upstream) measure(d time.Duration) {
+	for {
+		old := u.latency.Load()
+		next := int64(d)
+		if old != 0 {
+			next = old + (int64(d)-old)/4
+		}
+		if u.latency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (u *Upstream) score(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(u.Name))
+	return h.Sum64()
+}
+
+// upstreamConn counts the open connections of an upstream
+type upstreamConn struct {
+	net.Conn
+	upstream *Upstream
+	once     sync.Once
+}
+
+func (c *upstreamConn) Close() error {
+	c.once.Do(func() { c.upstream.active.Add(-1) })
+	return c.Conn.Close()
+}
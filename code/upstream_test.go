@@ -0,0 +1,144 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// closedAddress returns an address nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+func testGroup(strategy BalanceStrategy, names ...string) *UpstreamGroup {
+	g := &UpstreamGroup{Name: "test", Strategy: strategy}
+	for _, name := range names {
+		g.Upstreams = append(g.Upstreams, &Upstream{Name: name})
+	}
+	return g
+}
+
+func names(upstreams []*Upstream) string {
+	s := ""
+	for _, u := range upstreams {
+		s += u.Name
+	}
+	return s
+}
+
+func TestUpstreamGroupOrder(t *testing.T) {
+	g := testGroup(BalanceRoundRobin, "a", "b", "c")
+	for _, want := range []string{"bca", "cab", "abc"} {
+		if got := names(g.order("")); got != want {
+			t.Fatalf("round robin: want %s but got %s", want, got)
+		}
+	}
+
+	g = testGroup(BalanceLeastConnections, "a", "b", "c")
+	g.Upstreams[0].active.Store(2)
+	g.Upstreams[1].active.Store(1)
+	if got := names(g.order("")); got != "cba" {
+		t.Fatalf("least connections: want cba but got %s", got)
+	}
+
+	g = testGroup(BalanceLatency, "a", "b", "c")
+	g.Upstreams[0].measure(30 * time.Millisecond)
+	g.Upstreams[1].measure(10 * time.Millisecond)
+	g.Upstreams[2].measure(20 * time.Millisecond)
+	if got := names(g.order("")); got != "bca" {
+		t.Fatalf("latency: want bca but got %s", got)
+	}
+
+	// a key stays on its upstream and only moves when that one is ejected
+	g = testGroup(BalanceHashDestination, "a", "b", "c", "d")
+	first := map[string]string{}
+	for _, key := range []string{"x:1", "y:2", "z:3", "w:4"} {
+		first[key] = g.order(key)[0].Name
+		if again := g.order(key)[0].Name; again != first[key] {
+			t.Fatalf("%s: want %s again but got %s", key, first[key], again)
+		}
+	}
+	g.EjectDuration = time.Minute
+	g.FailureThreshold = 1
+	g.failed(g.Upstreams[0])
+	for key, name := range first {
+		order := g.order(key)
+		if order[len(order)-1].Name != "a" {
+			t.Fatalf("%s: want ejected upstream a last but got %s", key, names(order))
+		}
+		if name != "a" && order[0].Name != name {
+			t.Fatalf("%s: want %s to keep the key but got %s", key, name, order[0].Name)
+		}
+	}
+}
+
+func TestUpstreamGroupFailover(t *testing.T) {
+	echo := startEchoServer(t)
+	group := &UpstreamGroup{
+		Name:     "proxies",
+		Strategy: BalanceLeastConnections,
+		Upstreams: []*Upstream{
+			{Name: "down", Dialer: &Dialer{ProxyAddress: closedAddress(t), Timeout: time.Second}},
+			{Name: "up", Dialer: &Dialer{ProxyAddress: startTestServer(t, &Config{AuthMethod: MethodNoAuth}), Timeout: time.Second}},
+		},
+		FailureThreshold: 1,
+		EjectDuration:    time.Minute,
+	}
+	config := &Config{AuthMethod: MethodNoAuth, Router: &Router{
+		Default:   Route{Action: RouteUpstream, Upstream: "proxies"},
+		Upstreams: map[string]ContextDialer{"proxies": group},
+	}}
+	d := &Dialer{ProxyAddress: startTestServer(t, config), Timeout: 5 * time.Second}
+
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	conn.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("want echo ping but got %q, %v", got, err)
+	}
+
+	status := group.Status()
+	if status[0].Healthy || status[0].Failures != 1 || !status[1].Healthy || status[1].Active != 1 {
+		t.Fatalf("want down ejected and up with one connection but got %+v", status)
+	}
+	conn.Close()
+}
+
+func TestUpstreamGroupHealthCheck(t *testing.T) {
+	echo := startEchoServer(t)
+	group := &UpstreamGroup{
+		Name: "proxies",
+		Upstreams: []*Upstream{
+			{Name: "down", Dialer: &Dialer{ProxyAddress: closedAddress(t)}},
+			{Name: "up", Dialer: &Dialer{ProxyAddress: startTestServer(t, &Config{AuthMethod: MethodNoAuth})}},
+		},
+		HealthCheckTarget:   echo.String(),
+		HealthCheckInterval: 10 * time.Millisecond,
+		FailureThreshold:    2,
+	}
+	group.Start()
+	defer group.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := group.Status()
+		if !status[0].Healthy && status[1].Healthy && status[1].Latency > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want down ejected by health checks but got %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	echo := startEchoServer(t)
+	server := &SOCKS5Server{Config: &Config{
+		AuthMethod: MethodPassword,
+		PasswordChecker: func(username, password string) bool {
+			return username == "admin" && password == "123456"
+		},
+	}}
+	mux := http.NewServeMux()
+	mux.Handle("/socks", server)
+
+	roundTrip := func(t *testing.T, d *Dialer) {
+		d.Username, d.Password, d.Timeout = "admin", "123456", 5*time.Second
+		conn, err := d.Dial("tcp", echo.String())
+		if err != nil {
+			t.Fatalf("should get error nil but got %s", err)
+		}
+		defer conn.Close()
+		// larger than a single short frame
+		want := bytes.Repeat([]byte("ping"), 20000)
+		go conn.Write(want)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("want echo of %d bytes but got %d bytes, %v", len(want), len(got), err)
+		}
+	}
+
+	t.Run("ws", func(t *testing.T) {
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+		roundTrip(t, &Dialer{WebSocketURL: "ws" + strings.TrimPrefix(ts.URL, "http") + "/socks"})
+	})
+
+	t.Run("wss", func(t *testing.T) {
+		ts := httptest.NewTLSServer(mux)
+		defer ts.Close()
+		pool := x509.NewCertPool()
+		pool.AddCert(ts.Certificate())
+		roundTrip(t, &Dialer{
+			WebSocketURL: "wss" + strings.TrimPrefix(ts.URL, "https") + "/socks",
+			TLSConfig:    &tls.Config{RootCAs: pool},
+		})
+	})
+
+	t.Run("plain http is refused", func(t *testing.T) {
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+		resp, err := http.Get(ts.URL + "/socks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUpgradeRequired {
+			t.Fatalf("want status %d but got %d", http.StatusUpgradeRequired, resp.StatusCode)
+		}
+	})
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := &wsConn{Conn: server, r: bufio.NewReader(server)}
+
+	go func() {
+		// masked ping, then a masked binary message split over two frames
+		client.Write([]byte{wsFin | wsOpPing, wsMask | 2, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2})
+		client.Write([]byte{wsOpBinary, wsMask | 2, 0, 0, 0, 0, 'a', 'b'})
+		client.Write([]byte{wsFin | wsOpContinuation, wsMask | 1, 0, 0, 0, 0, 'c'})
+	}()
+
+	pong := make([]byte, 4)
+	readDone := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(io.LimitReader(ws, 3))
+		readDone <- got
+	}()
+	if _, err := io.ReadFull(client, pong); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{wsFin | wsOpPong, 2, 'h', 'i'}; !bytes.Equal(pong, want) {
+		t.Fatalf("want pong %v but got %v", want, pong)
+	}
+	if got := <-readDone; string(got) != "abc" {
+		t.Fatalf("want payload abc but got %q", got)
+	}
+}
@@ -0,0 +1,201 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+/*
+Addr is the address of requests, replies and UDP headers, an IP or a
+fully-qualified domain name plus a port. On the wire it is the ATYP octet,
+the address and the port in network octet order:
+
+	+------+----------+----------+
+	| ATYP | DST.ADDR | DST.PORT |
+	+------+----------+----------+
+	|  1   | Variable |    2     |
+	+------+----------+----------+
+
+The zero Addr is 0.0.0.0:0, the bound address of failure replies.
+*/
+type Addr struct {
+	// FQDN is the domain name, when set IP is not used
+	FQDN string
+	// IP is an IPv4 or IPv6 address, IPv4-mapped addresses are IPv4
+	IP   netip.Addr
+	Port uint16
+}
+
+// ParseAddr parses host:port, the host being an IP or a domain name.
+func ParseAddr(s string) (Addr, error) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return Addr{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return Addr{}, fmt.Errorf("wire: invalid port %q", portStr)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Zone() != "" {
+			return Addr{}, fmt.Errorf("wire: address %s has a zone", host)
+		}
+		return AddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+	}
+	if len(host) == 0 || len(host) > 255 {
+		return Addr{}, ErrDomainNameLength
+	}
+	return Addr{FQDN: host, Port: uint16(port)}, nil
+}
+
+// AddrFromAddrPort returns the Addr of an IP and port.
+func AddrFromAddrPort(ap netip.AddrPort) Addr {
+	return Addr{IP: ap.Addr().Unmap(), Port: ap.Port()}
+}
+
+// AddrFromIP returns the Addr of a net.IP and port, 0.0.0.0 if ip is nil.
+func AddrFromIP(ip net.IP, port uint16) Addr {
+	a, _ := netip.AddrFromSlice(ip)
+	return Addr{IP: a.Unmap(), Port: port}
+}
+
+// Type is the ATYP of the address
+func (a Addr) Type() byte {
+	switch {
+	case a.FQDN != "":
+		return TypeDomain
+	case a.IP.Is6():
+		return TypeIPv6
+	}
+	return TypeIPv4
+}
+
+// Host is the domain name or IP, without the port
+func (a Addr) Host() string {
+	if a.FQDN != "" {
+		return a.FQDN
+	}
+	return a.ip().String()
+}
+
+func (a Addr) String() string {
+	return net.JoinHostPort(a.Host(), strconv.Itoa(int(a.Port)))
+}
+
+// Network makes Addr a net.Addr
+func (a Addr) Network() string {
+	return "socks5"
+}
+
+// AddrPort is the IP and port, not valid for a domain name.
+func (a Addr) AddrPort() netip.AddrPort {
+	if a.FQDN != "" {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(a.ip(), a.Port)
+}
+
+func (a Addr) ip() netip.Addr {
+	if !a.IP.IsValid() {
+		return netip.IPv4Unspecified()
+	}
+	return a.IP
+}
+
+// AppendBinary appends the wire form of the address to b.
+func (a Addr) AppendBinary(b []byte) ([]byte, error) {
+	switch a.Type() {
+	case TypeDomain:
+		if len(a.FQDN) > 255 {
+			return nil, ErrDomainNameLength
+		}
+		b = append(b, TypeDomain, byte(len(a.FQDN)))
+		b = append(b, a.FQDN...)
+	case TypeIPv6:
+		ip := a.IP.As16()
+		b = append(b, TypeIPv6)
+		b = append(b, ip[:]...)
+	default:
+		ip := a.ip().As4()
+		b = append(b, TypeIPv4)
+		b = append(b, ip[:]...)
+	}
+	return append(b, byte(a.Port>>8), byte(a.Port)), nil
+}
+
+// MarshalBinary returns ATYP, address and port as sent on the wire.
+func (a Addr) MarshalBinary() ([]byte, error) {
+	return a.AppendBinary(make([]byte, 0, 1+1+255+2))
+}
+
+// UnmarshalBinary parses exactly one address in wire form.
+func (a *Addr) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := a.ReadFrom(r); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("wire: %d octets after the address", r.Len())
+	}
+	return nil
+}
+
+// WriteTo writes the address in wire form in one Write.
+func (a Addr) WriteTo(w io.Writer) (int64, error) {
+	b, err := a.MarshalBinary()
+	return write(w, b, err)
+}
+
+// ReadFrom reads one address in wire form from r, unlike io.ReaderFrom not until EOF.
+func (a *Addr) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	err := a.read(rd)
+	return rd.n, err
+}
+
+func (a *Addr) read(r *reader) error {
+	buf := make([]byte, 255+2)
+	if err := r.full(buf[:1]); err != nil {
+		return err
+	}
+	atyp := buf[0]
+	var size int
+	switch atyp {
+	case TypeIPv4:
+		size = 4
+	case TypeIPv6:
+		size = 16
+	case TypeDomain:
+		// the first octet is the length of the name, there is no terminating NUL octet
+		if err := r.full(buf[:1]); err != nil {
+			return err
+		}
+		if size = int(buf[0]); size == 0 {
+			return ErrDomainNameLength
+		}
+	default:
+		return ErrAddressTypeNotSupported
+	}
+	if err := r.full(buf[:size+2]); err != nil {
+		return err
+	}
+	body, port := buf[:size], buf[size:size+2]
+
+	*a = Addr{Port: uint16(port[0])<<8 | uint16(port[1])}
+	switch atyp {
+	case TypeIPv4:
+		a.IP = netip.AddrFrom4([4]byte(body))
+	case TypeIPv6:
+		a.IP = netip.AddrFrom16([16]byte(body)).Unmap()
+	case TypeDomain:
+		a.FQDN = string(body)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestAddrRoundTrip(t *testing.T) {
+	tests := []struct {
+		addr string
+		atyp byte
+		wire []byte
+	}{
+		{"1.2.3.4:80", TypeIPv4, []byte{TypeIPv4, 1, 2, 3, 4, 0, 80}},
+		{"[2001:db8::1]:443", TypeIPv6, []byte{TypeIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 0xbb}},
+		{"example.com:8080", TypeDomain, append([]byte{TypeDomain, 11}, append([]byte("example.com"), 0x1f, 0x90)...)},
+	}
+	for _, test := range tests {
+		a, err := ParseAddr(test.addr)
+		if err != nil {
+			t.Fatalf("%s: should get error nil but got %s", test.addr, err)
+		}
+		if a.Type() != test.atyp || a.String() != test.addr {
+			t.Fatalf("%s: want type %d but got %d and %s", test.addr, test.atyp, a.Type(), a)
+		}
+		wire, err := a.MarshalBinary()
+		if err != nil || !bytes.Equal(wire, test.wire) {
+			t.Fatalf("%s: want %v but got %v, %v", test.addr, test.wire, wire, err)
+		}
+		var b Addr
+		if err := b.UnmarshalBinary(wire); err != nil || b != a {
+			t.Fatalf("%s: want %s unmarshaled but got %s, %v", test.addr, a, b, err)
+		}
+	}
+}
+
+func TestAddrConversions(t *testing.T) {
+	a, err := ParseAddr("[::ffff:192.0.2.1]:53")
+	if err != nil {
+		t.Fatalf("should get error nil but got %s", err)
+	}
+	if a.Type() != TypeIPv4 || a.AddrPort() != netip.MustParseAddrPort("192.0.2.1:53") {
+		t.Fatalf("want the IPv4-mapped address as IPv4 but got %s", a)
+	}
+	if a := AddrFromIP(net.ParseIP("10.0.0.1"), 1080); a.String() != "10.0.0.1:1080" {
+		t.Fatalf("want 10.0.0.1:1080 from a 16 octet net.IP but got %s", a)
+	}
+	var zero Addr
+	if wire, _ := zero.MarshalBinary(); !bytes.Equal(wire, []byte{TypeIPv4, 0, 0, 0, 0, 0, 0}) {
+		t.Fatalf("want the zero Addr as 0.0.0.0:0 but got %v", wire)
+	}
+}
+
+func TestAddrErrors(t *testing.T) {
+	for _, s := range []string{"example.com", "1.2.3.4:65536", "[fe80::1%eth0]:80", ":80", strings.Repeat("a", 256) + ":80"} {
+		if _, err := ParseAddr(s); err == nil {
+			t.Fatalf("%s: want an error", s)
+		}
+	}
+	if _, err := (Addr{FQDN: strings.Repeat("a", 256)}).MarshalBinary(); err != ErrDomainNameLength {
+		t.Fatalf("want error %s but got %v", ErrDomainNameLength, err)
+	}
+
+	tests := []struct {
+		wire []byte
+		err  error
+	}{
+		{[]byte{}, io.ErrUnexpectedEOF},
+		{[]byte{0x02, 1, 2, 3, 4, 0, 80}, ErrAddressTypeNotSupported},
+		{[]byte{TypeDomain, 0, 0, 80}, ErrDomainNameLength},
+		{[]byte{TypeIPv4, 1, 2, 3, 4, 0}, io.ErrUnexpectedEOF},
+		{[]byte{TypeIPv4, 1, 2, 3, 4, 0, 80, 0}, nil},
+	}
+	for _, test := range tests {
+		var a Addr
+		err := a.UnmarshalBinary(test.wire)
+		if test.err == nil {
+			// octets after the address
+			if err == nil {
+				t.Fatalf("%v: want an error", test.wire)
+			}
+		} else if err != test.err {
+			t.Fatalf("%v: want error %v but got %v", test.wire, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package wire
+
+import "io"
+
+/*
+MethodSelection is the client's first message, offering its authentication methods.
+
+	+----+----------+----------+
+	|VER | NMETHODS | METHODS  |
+	+----+----------+----------+
+	| 1  |    1     | 1 to 255 |
+	+----+----------+----------+
+*/
+type MethodSelection struct {
+	Methods []byte
+}
+
+func (m *MethodSelection) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 255)
+	if err := rd.full(buf[:2]); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != Version {
+		return rd.n, ErrVersionNotSupported
+	}
+	n := int(buf[1])
+	if n == 0 {
+		return rd.n, ErrMethodCount
+	}
+	if err := rd.full(buf[:n]); err != nil {
+		return rd.n, err
+	}
+	m.Methods = append([]byte(nil), buf[:n]...)
+	return rd.n, nil
+}
+
+func (m *MethodSelection) WriteTo(w io.Writer) (int64, error) {
+	if len(m.Methods) == 0 || len(m.Methods) > 255 {
+		return 0, ErrMethodCount
+	}
+	return write(w, append([]byte{Version, byte(len(m.Methods))}, m.Methods...), nil)
+}
+
+/*
+MethodReply is the method the server selected, X'FF' for none acceptable.
+
+	+----+--------+
+	|VER | METHOD |
+	+----+--------+
+	| 1  |   1    |
+	+----+--------+
+*/
+type MethodReply struct {
+	Method byte
+}
+
+func (m *MethodReply) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 2)
+	if err := rd.full(buf); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != Version {
+		return rd.n, ErrVersionNotSupported
+	}
+	m.Method = buf[1]
+	return rd.n, nil
+}
+
+func (m *MethodReply) WriteTo(w io.Writer) (int64, error) {
+	return write(w, []byte{Version, m.Method}, nil)
+}
+
+/*
+PasswordRequest is the username/password subnegotiation of RFC 1929.
+An empty password is accepted, as some clients send one.
+
+	+----+------+----------+------+----------+
+	|VER | ULEN |  UNAME   | PLEN |  PASSWD  |
+	+----+------+----------+------+----------+
+	| 1  |  1   | 1 to 255 |  1   | 1 to 255 |
+	+----+------+----------+------+----------+
+*/
+type PasswordRequest struct {
+	Username string
+	Password string
+}
+
+func (m *PasswordRequest) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 255+1)
+	if err := rd.full(buf[:2]); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != PasswordVersion {
+		return rd.n, ErrMethodVersionNotSupported
+	}
+	ulen := int(buf[1])
+	if ulen == 0 {
+		return rd.n, ErrUsernameLength
+	}
+	// the username and the length of the password
+	if err := rd.full(buf[:ulen+1]); err != nil {
+		return rd.n, err
+	}
+	username, plen := string(buf[:ulen]), int(buf[ulen])
+	if err := rd.full(buf[:plen]); err != nil {
+		return rd.n, err
+	}
+	m.Username, m.Password = username, string(buf[:plen])
+	return rd.n, nil
+}
+
+func (m *PasswordRequest) WriteTo(w io.Writer) (int64, error) {
+	if len(m.Username) == 0 || len(m.Username) > 255 {
+		return 0, ErrUsernameLength
+	}
+	if len(m.Password) > 255 {
+		return 0, ErrPasswordLength
+	}
+	b := append([]byte{PasswordVersion, byte(len(m.Username))}, m.Username...)
+	b = append(b, byte(len(m.Password)))
+	return write(w, append(b, m.Password...), nil)
+}
+
+/*
+PasswordResponse is the server's verdict, a STATUS of X'00' is success.
+
+	+----+--------+
+	|VER | STATUS |
+	+----+--------+
+	| 1  |   1    |
+	+----+--------+
+*/
+type PasswordResponse struct {
+	Status byte
+}
+
+func (m *PasswordResponse) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 2)
+	if err := rd.full(buf); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != PasswordVersion {
+		return rd.n, ErrMethodVersionNotSupported
+	}
+	m.Status = buf[1]
+	return rd.n, nil
+}
+
+func (m *PasswordResponse) WriteTo(w io.Writer) (int64, error) {
+	return write(w, []byte{PasswordVersion, m.Status}, nil)
+}
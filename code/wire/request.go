@@ -0,0 +1,83 @@
+package wire
+
+import "io"
+
+/*
+Request asks the server to connect, bind or associate UDP for the destination.
+
+	+----+-----+-------+------+----------+----------+
+	|VER | CMD |  RSV  | ATYP | DST.ADDR | DST.PORT |
+	+----+-----+-------+------+----------+----------+
+	| 1  |  1  | X'00' |  1   | Variable |    2     |
+	+----+-----+-------+------+----------+----------+
+*/
+type Request struct {
+	Cmd byte
+	Dst Addr
+}
+
+func (m *Request) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 3)
+	if err := rd.full(buf); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != Version {
+		return rd.n, ErrVersionNotSupported
+	}
+	if !validCommand(buf[1]) {
+		return rd.n, ErrRequestCommandNotSupported
+	}
+	if buf[2] != Reserved {
+		return rd.n, ErrReservedFieldNotZero
+	}
+	m.Cmd = buf[1]
+	return rd.n, m.Dst.read(rd)
+}
+
+func (m *Request) WriteTo(w io.Writer) (int64, error) {
+	if !validCommand(m.Cmd) {
+		return 0, ErrRequestCommandNotSupported
+	}
+	b, err := m.Dst.AppendBinary([]byte{Version, m.Cmd, Reserved})
+	return write(w, b, err)
+}
+
+func validCommand(cmd byte) bool {
+	return cmd == CmdConnect || cmd == CmdBind || cmd == CmdUDPAssociate
+}
+
+/*
+Reply answers a Request, REP X'00' is success and BND the address the server bound.
+
+	+----+-----+-------+------+----------+----------+
+	|VER | REP |  RSV  | ATYP | BND.ADDR | BND.PORT |
+	+----+-----+-------+------+----------+----------+
+	| 1  |  1  | X'00' |  1   | Variable |    2     |
+	+----+-----+-------+------+----------+----------+
+*/
+type Reply struct {
+	Rep   byte
+	Bound Addr
+}
+
+func (m *Reply) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 3)
+	if err := rd.full(buf); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != Version {
+		return rd.n, ErrVersionNotSupported
+	}
+	if buf[2] != Reserved {
+		return rd.n, ErrReservedFieldNotZero
+	}
+	m.Rep = buf[1]
+	return rd.n, m.Bound.read(rd)
+}
+
+func (m *Reply) WriteTo(w io.Writer) (int64, error) {
+	b, err := m.Bound.AppendBinary([]byte{Version, m.Rep, Reserved})
+	return write(w, b, err)
+}
@@ -0,0 +1,44 @@
+package wire
+
+import "io"
+
+/*
+UDPHeader starts every datagram relayed for a UDP ASSOCIATE, the data follows it.
+
+	+----+------+------+----------+----------+----------+
+	|RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+	+----+------+------+----------+----------+----------+
+	| 2  |  1   |  1   | Variable |    2     | Variable |
+	+----+------+------+----------+----------+----------+
+
+FRAG X'00' is a standalone datagram, others are fragments most servers drop.
+*/
+type UDPHeader struct {
+	Frag byte
+	Dst  Addr
+}
+
+// ReadFrom reads the header, leaving the data in r.
+func (m *UDPHeader) ReadFrom(r io.Reader) (int64, error) {
+	rd := &reader{r: r}
+	buf := make([]byte, 3)
+	if err := rd.full(buf); err != nil {
+		return rd.n, err
+	}
+	if buf[0] != Reserved || buf[1] != Reserved {
+		return rd.n, ErrReservedFieldNotZero
+	}
+	m.Frag = buf[2]
+	return rd.n, m.Dst.read(rd)
+}
+
+// WriteTo writes the header, the data is to be written after it in the same datagram.
+func (m *UDPHeader) WriteTo(w io.Writer) (int64, error) {
+	b, err := m.AppendBinary(nil)
+	return write(w, b, err)
+}
+
+// AppendBinary appends the header to b, for a datagram of header and data.
+func (m *UDPHeader) AppendBinary(b []byte) ([]byte, error) {
+	return m.Dst.AppendBinary(append(b, Reserved, Reserved, m.Frag))
+}
@@ -0,0 +1,69 @@
+// Package wire reads and writes the messages of SOCKS5 (RFC 1928) and of its
+// username/password authentication (RFC 1929), for servers and clients alike.
+//
+// Every message has ReadFrom, which validates what it reads and reads no
+// further than the message, and WriteTo, which validates the message and
+// sends it in a single Write.
+package wire
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	Version         = 0x05
+	PasswordVersion = 0x01
+	Reserved        = 0x00
+)
+
+// Commands of a Request
+const (
+	CmdConnect      byte = 0x01
+	CmdBind         byte = 0x02
+	CmdUDPAssociate byte = 0x03
+)
+
+// Address types, the ATYP of an Addr
+const (
+	TypeIPv4   byte = 0x01
+	TypeDomain byte = 0x03
+	TypeIPv6   byte = 0x04
+)
+
+var (
+	ErrVersionNotSupported        = errors.New("protocol version is not supported, see more on https://www.rfc-editor.org/rfc/rfc1928")
+	ErrMethodVersionNotSupported  = errors.New("username password authentication version is not supported")
+	ErrRequestCommandNotSupported = errors.New("request command not supported")
+	ErrReservedFieldNotZero       = errors.New("reserved field is not zero")
+	ErrAddressTypeNotSupported    = errors.New("request address type not supported")
+	ErrDomainNameLength           = errors.New("domain name must be 1 to 255 octets")
+	ErrMethodCount                = errors.New("method selection must offer 1 to 255 methods")
+	ErrUsernameLength             = errors.New("username must be 1 to 255 octets")
+	ErrPasswordLength             = errors.New("password must be at most 255 octets")
+)
+
+// reader reads whole fields from r and counts the octets read for ReadFrom
+type reader struct {
+	r io.Reader
+	n int64
+}
+
+// full fills b, EOF in the middle of a message is io.ErrUnexpectedEOF
+func (r *reader) full(b []byte) error {
+	n, err := io.ReadFull(r.r, b)
+	r.n += int64(n)
+	if err == io.EOF && r.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// write sends b in one Write for WriteTo
+func write(w io.Writer, b []byte, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
@@ -0,0 +1,139 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type message interface {
+	io.ReaderFrom
+	io.WriterTo
+}
+
+// countWriter counts the Writes, every message is to be sent in one
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	ipv4, _ := ParseAddr("1.2.3.4:80")
+	ipv6, _ := ParseAddr("[2001:db8::1]:443")
+	domain, _ := ParseAddr("example.com:8080")
+	// the zero Addr is sent as 0.0.0.0:0 and read back as that
+	unspecified, _ := ParseAddr("0.0.0.0:0")
+	tests := []struct {
+		msg  message
+		wire []byte
+		// empty returns the message to read into
+		empty func() message
+	}{
+		{&MethodSelection{Methods: []byte{0x00, 0x02}}, []byte{5, 2, 0, 2}, func() message { return &MethodSelection{} }},
+		{&MethodReply{Method: 0x02}, []byte{5, 2}, func() message { return &MethodReply{} }},
+		{&MethodReply{Method: 0xff}, []byte{5, 0xff}, func() message { return &MethodReply{} }},
+		{&PasswordRequest{Username: "user", Password: "pass"}, []byte{1, 4, 'u', 's', 'e', 'r', 4, 'p', 'a', 's', 's'}, func() message { return &PasswordRequest{} }},
+		{&PasswordRequest{Username: "user"}, []byte{1, 4, 'u', 's', 'e', 'r', 0}, func() message { return &PasswordRequest{} }},
+		{&PasswordResponse{Status: 1}, []byte{1, 1}, func() message { return &PasswordResponse{} }},
+		{&Request{Cmd: CmdConnect, Dst: ipv4}, []byte{5, 1, 0, TypeIPv4, 1, 2, 3, 4, 0, 80}, func() message { return &Request{} }},
+		{&Request{Cmd: CmdUDPAssociate, Dst: ipv6}, append([]byte{5, 3, 0}, mustMarshal(ipv6)...), func() message { return &Request{} }},
+		{&Reply{Rep: 0, Bound: domain}, append([]byte{5, 0, 0}, mustMarshal(domain)...), func() message { return &Reply{} }},
+		{&Reply{Rep: 5, Bound: unspecified}, []byte{5, 5, 0, TypeIPv4, 0, 0, 0, 0, 0, 0}, func() message { return &Reply{} }},
+		{&UDPHeader{Frag: 0, Dst: ipv4}, []byte{0, 0, 0, TypeIPv4, 1, 2, 3, 4, 0, 80}, func() message { return &UDPHeader{} }},
+	}
+	for _, test := range tests {
+		var w countWriter
+		n, err := test.msg.WriteTo(&w)
+		if err != nil || !bytes.Equal(w.Bytes(), test.wire) || n != int64(len(test.wire)) {
+			t.Fatalf("%#v: want %v written but got %v, %v", test.msg, test.wire, w.Bytes(), err)
+		}
+		if w.writes != 1 {
+			t.Fatalf("%#v: want a single Write but got %d", test.msg, w.writes)
+		}
+
+		got := test.empty()
+		r := bytes.NewBuffer(append(append([]byte(nil), test.wire...), "rest"...))
+		n, err = got.ReadFrom(r)
+		if err != nil || n != int64(len(test.wire)) {
+			t.Fatalf("%v: want %d octets read but got %d, %v", test.wire, len(test.wire), n, err)
+		}
+		if !reflect.DeepEqual(got, test.msg) {
+			t.Fatalf("%v: want %#v but got %#v", test.wire, test.msg, got)
+		}
+		if r.String() != "rest" {
+			t.Fatalf("%v: want only the message read but %q is left", test.wire, r.String())
+		}
+	}
+}
+
+func mustMarshal(a Addr) []byte {
+	b, err := a.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		msg  message
+		wire []byte
+		err  error
+	}{
+		{&MethodSelection{}, []byte{4, 1, 0}, ErrVersionNotSupported},
+		{&MethodSelection{}, []byte{5, 0}, ErrMethodCount},
+		{&MethodSelection{}, []byte{5, 2, 0}, io.ErrUnexpectedEOF},
+		{&MethodReply{}, []byte{4, 0}, ErrVersionNotSupported},
+		{&PasswordRequest{}, []byte{5, 1, 'u', 0}, ErrMethodVersionNotSupported},
+		{&PasswordRequest{}, []byte{1, 0, 0}, ErrUsernameLength},
+		{&PasswordRequest{}, []byte{1, 4, 'u', 's'}, io.ErrUnexpectedEOF},
+		{&PasswordResponse{}, []byte{5, 0}, ErrMethodVersionNotSupported},
+		{&Request{}, []byte{4, 1, 0, TypeIPv4, 1, 2, 3, 4, 0, 80}, ErrVersionNotSupported},
+		{&Request{}, []byte{5, 4, 0, TypeIPv4, 1, 2, 3, 4, 0, 80}, ErrRequestCommandNotSupported},
+		{&Request{}, []byte{5, 1, 1, TypeIPv4, 1, 2, 3, 4, 0, 80}, ErrReservedFieldNotZero},
+		{&Request{}, []byte{5, 1, 0, 0x02, 1, 2, 3, 4, 0, 80}, ErrAddressTypeNotSupported},
+		{&Request{}, []byte{5, 1, 0}, io.ErrUnexpectedEOF},
+		{&Reply{}, []byte{5, 0, 1, TypeIPv4, 0, 0, 0, 0, 0, 0}, ErrReservedFieldNotZero},
+		{&UDPHeader{}, []byte{0, 1, 0, TypeIPv4, 1, 2, 3, 4, 0, 80}, ErrReservedFieldNotZero},
+	}
+	for _, test := range tests {
+		if _, err := test.msg.ReadFrom(bytes.NewReader(test.wire)); err != test.err {
+			t.Fatalf("%T %v: want error %v but got %v", test.msg, test.wire, test.err, err)
+		}
+	}
+	// nothing read at all is a clean end of the stream
+	if _, err := (&MethodSelection{}).ReadFrom(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("want error EOF but got %v", err)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	tests := []struct {
+		msg message
+		err error
+	}{
+		{&MethodSelection{}, ErrMethodCount},
+		{&MethodSelection{Methods: make([]byte, 256)}, ErrMethodCount},
+		{&PasswordRequest{Password: "pass"}, ErrUsernameLength},
+		{&PasswordRequest{Username: strings.Repeat("u", 256)}, ErrUsernameLength},
+		{&PasswordRequest{Username: "user", Password: strings.Repeat("p", 256)}, ErrPasswordLength},
+		{&Request{Cmd: 4}, ErrRequestCommandNotSupported},
+		{&Reply{Bound: Addr{FQDN: strings.Repeat("a", 256)}}, ErrDomainNameLength},
+	}
+	for _, test := range tests {
+		var w countWriter
+		if _, err := test.msg.WriteTo(&w); err != test.err {
+			t.Fatalf("%T: want error %v but got %v", test.msg, test.err, err)
+		}
+		if w.writes != 0 {
+			t.Fatalf("%T: want nothing written for an invalid message", test.msg)
+		}
+	}
+}